@@ -2,14 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/EvgeniyBudaev/shortener/internal/auth"
 	"github.com/EvgeniyBudaev/shortener/internal/compress"
+	"github.com/EvgeniyBudaev/shortener/internal/domains"
+	"github.com/EvgeniyBudaev/shortener/internal/linkcheck"
+	"github.com/EvgeniyBudaev/shortener/internal/ratelimit"
 	"github.com/EvgeniyBudaev/shortener/internal/store"
+	"github.com/EvgeniyBudaev/shortener/internal/webhook"
 	"github.com/gin-contrib/pprof"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -32,33 +40,137 @@ const (
 	timeoutShutdown       = time.Second * 10
 )
 
-func setupRouter(a *app.App) *gin.Engine {
+func setupRouter(a *app.App, limiter ratelimit.Limiter) *gin.Engine {
 	r := gin.New()
-	pprof.Register(r)
+	// IP клиента берется из X-Forwarded-For только от доверенных прокси, иначе заголовок
+	// позволял бы обходить ограничения частоты запросов и подменять IP в журнале аудита
+	if err := r.SetTrustedProxies(a.Config.Get().TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
 	ginLoggerMiddleware, err := ginLogger.Logger()
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.Use(ginLoggerMiddleware)
-	r.Use(auth.AuthMiddleware(a.Config.Seed))
+
+	keyring, err := auth.NewKeyringFromConfig(a.Config.Get())
+	if err != nil {
+		log.Fatal(err)
+	}
+	authenticator, err := auth.NewAuthenticator(keyring, a.Storage(), a.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Use(authenticator.AuthMiddleware())
+	r.Use(authenticator.CSRFMiddleware())
 	r.Use(compress.Compress())
 
-	r.GET("/:id", a.RedirectURL)
-	r.POST("/", a.ShortURL)
+	// limit ограничение частоты запросов группы маршрутов из действующей конфигурации
+	limit := func(group string, get func(conf *config.ServerConfig) string) gin.HandlerFunc {
+		return ratelimit.Middleware(limiter, group, func() ratelimit.Limit {
+			l, _ := ratelimit.ParseLimit(get(a.Config.Get()))
+			return l
+		}, auth.ClientKey)
+	}
+	redirectLimit := limit("redirect", func(conf *config.ServerConfig) string { return conf.RateLimitRedirect })
+	shortenLimit := limit("shorten", func(conf *config.ServerConfig) string { return conf.RateLimitShorten })
+	batchLimit := limit("batch", func(conf *config.ServerConfig) string { return conf.RateLimitBatch })
+
+	admin := r.Group("/", authenticator.RequireInternal())
+	pprof.RouteRegister(admin, "debug/pprof")
+
+	r.GET("/.well-known/jwks.json", keyring.JWKSHandler)
+	r.GET("/:id", redirectLimit, a.RedirectURL)
+	// переход с переносом части пути после ID в адрес перехода
+	r.GET("/:id/*path", redirectLimit, a.RedirectURL)
+	// отправка формы пароля защищенной ссылки
+	r.POST("/:id", redirectLimit, a.RedirectURL)
+	r.POST("/:id/*path", redirectLimit, a.RedirectURL)
+	r.POST("/", shortenLimit, a.ShortURL)
 	r.GET("/ping", a.Ping)
 
 	api := r.Group("/api")
 	{
-		api.POST("/shorten", a.ShortURL)
-		api.POST("/shorten/batch", a.ShortenBatch)
+		api.POST("/shorten", shortenLimit, a.ShortURL)
+		api.POST("/shorten/batch", batchLimit, a.ShortenBatch)
+		api.GET("/urls/:id", redirectLimit, a.LinkInfo)
+		api.GET("/urls/:id/qr", redirectLimit, a.LinkQR)
 
 		api.GET("/user/urls", a.GetUserRecords)
 		api.DELETE("/user/urls", a.DeleteUserRecords)
+		api.PATCH("/user/urls/:id", a.UpdateUserRecord)
+		api.GET("/user/urls/:id/history", a.GetRecordHistory)
+		api.PUT("/user/urls/:id/tags", a.SetRecordTags)
+		api.GET("/user/urls/:id/rules", a.GetRecordRules)
+		api.PUT("/user/urls/:id/rules", a.SetRecordRules)
+		api.GET("/user/urls/:id/destinations", a.GetRecordDestinations)
+		api.PUT("/user/urls/:id/destinations", a.SetRecordDestinations)
+		api.GET("/user/webhooks", a.GetWebhooks)
+		api.POST("/user/webhooks", a.CreateWebhook)
+		api.DELETE("/user/webhooks/:wid", a.DeleteWebhook)
+		api.GET("/user/webhooks/:wid/deliveries", a.GetWebhookDeliveries)
+		api.GET("/user/tags", a.GetUserTags)
+		api.GET("/user/collections", a.GetUserCollections)
+		api.POST("/user/collections", a.CreateCollection)
+		api.PATCH("/user/collections/:name", a.UpdateCollection)
+		api.DELETE("/user/collections/:name", a.DeleteCollection)
+		api.POST("/user/logout", authenticator.Logout)
+
+		api.GET("/workspaces", a.GetWorkspaces)
+		api.POST("/workspaces", a.CreateWorkspace)
+		api.GET("/workspaces/:wid/members", a.GetWorkspaceMembers)
+		api.PUT("/workspaces/:wid/members/:uid", a.SetWorkspaceMember)
+		api.DELETE("/workspaces/:wid/members/:uid", a.RemoveWorkspaceMember)
+		api.POST("/workspaces/:wid/invites", a.CreateInvite)
+		api.POST("/invites/:token/accept", a.AcceptInvite)
+
+		internal := api.Group("/internal", authenticator.RequireInternal())
+		internal.GET("/stats", a.Stats)
+		internal.GET("/events", a.Events)
 	}
 
 	return r
 }
 
+// webhookClient HTTP-клиент доставки событий подпискам. Адреса подписок задают пользователи,
+// поэтому подключение к внутренним адресам разрешается только явно.
+func webhookClient(conf *config.ServerConfig) *http.Client {
+	if !conf.WebhookAllowPrivate {
+		return linkcheck.NewClient(webhook.DefaultTimeout)
+	}
+	return &http.Client{
+		Timeout: webhook.DefaultTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// reloadConfig повторное чтение файла конфигурации и переменных окружения по SIGHUP.
+// Новая конфигурация применяется только после успешной проверки, параметры,
+// требующие перезапуска, остаются прежними.
+func reloadConfig(holder *config.Holder) {
+	next, _, err := config.Load(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args[1:], os.Environ())
+	if err != nil {
+		log.Printf("Config reload rejected: %v", err)
+		return
+	}
+	changed, rejected := holder.Reload(next)
+	if err := ginLogger.SetLevel(holder.Get().LogLevel); err != nil {
+		log.Printf("Error setting log level: %v", err)
+	}
+	if len(changed) == 0 && len(rejected) == 0 {
+		log.Print("Config reloaded, no changes")
+		return
+	}
+	for _, name := range changed {
+		log.Printf("Config reloaded: %s changed", name)
+	}
+	for _, name := range rejected {
+		log.Printf("Config reload: %s is not reloadable, restart required to apply", name)
+	}
+}
+
 func main() {
 	ctx, cancelCtx := signal.NotifyContext(context.Background(), syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 
@@ -73,10 +185,19 @@ func main() {
 
 	defer cancelCtx()
 
-	appConfig, err := config.ParseFlags()
+	appConfig, sources, err := config.ParseFlags()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if appConfig.PrintConfig {
+		if err := appConfig.Print(os.Stdout, sources); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := ginLogger.SetLevel(appConfig.LogLevel); err != nil {
+		log.Fatal(err)
+	}
 
 	storage, err := store.NewStore(ctx, appConfig)
 	if err != nil {
@@ -91,8 +212,54 @@ func main() {
 	componentsErrs := make(chan error, 1)
 
 	appInit := app.NewApp(appConfig, storage)
+	if appConfig.DomainListFile != "" {
+		domainList, err := domains.NewFileList(appConfig.DomainListFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appInit.Domains = domainList
+	}
+	if appConfig.CheckReachability {
+		appInit.Validator = linkcheck.NewChecker(linkcheck.DefaultTimeout, linkcheck.DefaultMaxRedirects)
+	}
+
+	var limiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+	if appConfig.RateLimitShared {
+		shared, ok := storage.(ratelimit.Limiter)
+		if !ok {
+			log.Fatal("shared rate limiter is not supported by the storage")
+		}
+		limiter = shared
+	}
+	appInit.Limiter = limiter
+
+	r := setupRouter(appInit, limiter)
+
+	// отправка событий подпискам и проверка сроков действия ссылок завершаются до закрытия хранилища
+	background := &sync.WaitGroup{}
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		webhook.NewDispatcher(storage, webhookClient(appConfig), appInit.WebhookPayload).Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		appInit.ExpireLinks(ctx)
+	}()
 
-	r := setupRouter(appInit)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reloadConfig(appInit.Config)
+			}
+		}
+	}()
 	srv := http.Server{
 		Addr:    appConfig.FlagRunAddr,
 		Handler: r,
@@ -100,19 +267,43 @@ func main() {
 
 	go func(errs chan<- error) {
 		if appConfig.EnableHTTPS {
-			certFilePath := "./certs/cert.pem"
-			rsaFilePath := "./certs/private.pem"
-			certsExist, err := app.CheckIfCertificatesExist(certFilePath, rsaFilePath)
+			certsExist, err := app.CheckIfCertificatesExist(appConfig.TLSCertFile, appConfig.TLSKeyFile)
 			if err != nil {
-				log.Fatal(err)
+				errs <- err
+				return
 			}
 			if !certsExist {
 				// Если файлы не существуют, создаем новые сертификаты
-				if err := app.CreateCertificates(); err != nil {
+				var hosts []string
+				for _, base := range append([]string{appConfig.RedirectBaseURL}, appConfig.ShortDomains...) {
+					if baseURL, err := url.Parse(base); err == nil {
+						hosts = append(hosts, baseURL.Hostname())
+					}
+				}
+				if err := app.CreateCertificates(appConfig.TLSCertFile, appConfig.TLSKeyFile, hosts); err != nil {
 					errs <- fmt.Errorf("error creating tls certs: %w", err)
+					return
 				}
 			}
-			if err := srv.ListenAndServeTLS(certFilePath, rsaFilePath); err != nil {
+			reloader, err := app.NewCertReloader(appConfig.TLSCertFile, appConfig.TLSKeyFile)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var clientCAs *x509.CertPool
+			if appConfig.TLSClientCAFile != "" {
+				if clientCAs, err = app.LoadClientCAs(appConfig.TLSClientCAFile); err != nil {
+					errs <- err
+					return
+				}
+			}
+			clientAuth, err := app.ParseClientAuth(appConfig.TLSClientAuth)
+			if err != nil {
+				errs <- err
+				return
+			}
+			srv.TLSConfig = app.NewTLSConfig(reloader, clientCAs, clientAuth)
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
 				if errors.Is(err, http.ErrServerClosed) {
 					return
 				}
@@ -139,6 +330,7 @@ func main() {
 		if err := srv.Shutdown(shutdownTimeoutCtx); err != nil {
 			log.Printf("an error occurred during server shutdown: %v", err)
 		}
+		background.Wait()
 		storage.Close()
 	}()
 
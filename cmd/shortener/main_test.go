@@ -2,16 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/EvgeniyBudaev/shortener/internal/app"
+	"github.com/EvgeniyBudaev/shortener/internal/domains"
 	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/ratelimit"
 	"github.com/EvgeniyBudaev/shortener/internal/store/fs"
 	"github.com/EvgeniyBudaev/shortener/internal/utils"
+	"github.com/EvgeniyBudaev/shortener/internal/webhook"
+	"image/png"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/EvgeniyBudaev/shortener/internal/config"
 	"github.com/gin-gonic/gin"
@@ -19,6 +27,59 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// testClient клиент тестового роутера от имени одного пользователя:
+// cookie из первого ответа отправляются со всеми следующими запросами
+type testClient struct {
+	router  *gin.Engine
+	cookies []*http.Cookie
+}
+
+// requestOption изменение тестового запроса перед отправкой
+type requestOption func(req *http.Request)
+
+// withHeader заголовок запроса
+func withHeader(name, value string) requestOption {
+	return func(req *http.Request) {
+		req.Header.Set(name, value)
+	}
+}
+
+// withHost домен запроса в заголовке Host
+func withHost(host string) requestOption {
+	return func(req *http.Request) {
+		req.Host = host
+	}
+}
+
+// newTestServer роутер с файловым хранилищем, удаляемым по завершении теста, и клиент к нему
+func newTestServer(t *testing.T, conf *config.ServerConfig) (*fs.FSStorage, *testClient) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		storage.DeleteStorageFile()
+	})
+	return storage, &testClient{router: setupRouter(app.NewApp(conf, storage), ratelimit.NewMemoryLimiter())}
+}
+
+// do отправка запроса с cookie клиента
+func (c *testClient) do(method, path, body string, opts ...requestOption) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	for _, opt := range opts {
+		opt(req)
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	c.router.ServeHTTP(w, req)
+	if c.cookies == nil {
+		c.cookies = w.Result().Cookies()
+	}
+	return w
+}
+
 func TestRedirectURL(t *testing.T) {
 	type args struct {
 		urls           map[string]string
@@ -66,11 +127,11 @@ func TestRedirectURL(t *testing.T) {
 			}
 			defer storage.DeleteStorageFile()
 			for url := range test.args.urls {
-				storage.Put(ctx, url, test.args.urls[url], "")
+				storage.Put(ctx, models.Link{ID: url, OriginalURL: test.args.urls[url]})
 			}
 
 			testApp := app.NewApp(&config.ServerConfig{}, storage)
-			r := setupRouter(testApp)
+			r := setupRouter(testApp, ratelimit.NewMemoryLimiter())
 			req := httptest.NewRequest(http.MethodGet, test.args.shortURL, nil)
 
 			r.ServeHTTP(w, req)
@@ -127,11 +188,11 @@ func TestShortURLV1(t *testing.T) {
 			}
 			defer storage.DeleteStorageFile()
 			for url := range test.args.urls {
-				storage.Put(ctx, url, test.args.urls[url], "")
+				storage.Put(ctx, models.Link{ID: url, OriginalURL: test.args.urls[url]})
 			}
 
 			testApp := app.NewApp(&config.ServerConfig{}, storage)
-			r := setupRouter(testApp)
+			r := setupRouter(testApp, ratelimit.NewMemoryLimiter())
 			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer([]byte(test.args.originalURL)))
 			req.Header.Add("Content-Type", "text/plain")
 
@@ -187,11 +248,11 @@ func TestShortURLV2(t *testing.T) {
 			}
 			defer storage.DeleteStorageFile()
 			for url := range tt.args.urls {
-				storage.Put(ctx, url, tt.args.urls[url], "")
+				storage.Put(ctx, models.Link{ID: url, OriginalURL: tt.args.urls[url]})
 			}
 
 			testApp := app.NewApp(&config.ServerConfig{}, storage)
-			r := setupRouter(testApp)
+			r := setupRouter(testApp, ratelimit.NewMemoryLimiter())
 			reqObj := models.ShortenReq{
 				URL: tt.args.originalURL,
 			}
@@ -225,7 +286,7 @@ func BenchmarkShortUrl(b *testing.B) {
 	defer storage.DeleteStorageFile()
 
 	testApp := app.NewApp(&config.ServerConfig{}, storage)
-	r := setupRouter(testApp)
+	r := setupRouter(testApp, ratelimit.NewMemoryLimiter())
 
 	b.ResetTimer()
 
@@ -233,7 +294,7 @@ func BenchmarkShortUrl(b *testing.B) {
 		b.StopTimer()
 
 		randURL, _ := utils.GenerateRandomString(length)
-		randURL = fmt.Sprintf("%s.ru", randURL)
+		randURL = fmt.Sprintf("https://%s.ru", randURL)
 		reqObj := models.ShortenReq{
 			URL: randURL,
 		}
@@ -248,3 +309,1102 @@ func BenchmarkShortUrl(b *testing.B) {
 		res.Body.Close()
 	}
 }
+
+func TestShortURLValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		status      int
+	}{
+		{name: "empty body", body: "", contentType: "text/plain", status: http.StatusBadRequest},
+		{name: "javascript url", body: "javascript:alert(1)", contentType: "text/plain", status: http.StatusBadRequest},
+		{name: "relative path", body: "/admin", contentType: "text/plain", status: http.StatusBadRequest},
+		{name: "form body", body: "url=https%3A%2F%2FExample.com%3A443%2F", contentType: "application/x-www-form-urlencoded", status: http.StatusCreated},
+		{name: "equivalent url", body: "https://example.com", contentType: "text/plain", status: http.StatusConflict},
+	}
+
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	r := setupRouter(app.NewApp(&config.ServerConfig{}, storage), ratelimit.NewMemoryLimiter())
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tt.body))
+		req.Header.Add("Content-Type", tt.contentType)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		if tt.status == http.StatusBadRequest {
+			var errRes models.ErrorRes
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errRes), tt.name)
+			assert.NotEmpty(t, errRes.Error, tt.name)
+		}
+	}
+}
+
+type blockedDomains map[string]bool
+
+func (b blockedDomains) Check(host string) error {
+	if b[host] {
+		return domains.ErrBlocked
+	}
+	return nil
+}
+
+func TestDomainPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	testApp := app.NewApp(&config.ServerConfig{}, storage)
+	policy := blockedDomains{"phish.example": true}
+	testApp.Domains = policy
+	r := setupRouter(testApp, ratelimit.NewMemoryLimiter())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://phish.example/login")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	_, err = storage.Put(ctx, models.Link{ID: "abc", OriginalURL: "https://later.example/"})
+	require.NoError(t, err)
+	policy["later.example"] = true
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+}
+
+func TestRedirectOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	testApp := app.NewApp(&config.ServerConfig{
+		RedirectBaseURL:      "http://localhost:8080",
+		RedirectStatus:       http.StatusFound,
+		RedirectCacheControl: "private, max-age=90",
+		ReferrerPolicy:       "no-referrer",
+	}, storage)
+	r := setupRouter(testApp, ratelimit.NewMemoryLimiter())
+
+	obj, err := json.Marshal(models.ShortenReq{
+		URL: "https://seo.example/landing",
+		LinkOptions: models.LinkOptions{
+			RedirectStatus: http.StatusMovedPermanently,
+			CacheControl:   "public, max-age=86400",
+			RobotsTag:      "noindex",
+		},
+	})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(obj)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var shorten models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shorten))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, shorten.Result[len("http://localhost:8080"):], nil))
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "noindex", w.Header().Get("X-Robots-Tag"))
+
+	// повтор с теми же настройками или без них возвращает существующую ссылку,
+	// с другими настройками - ошибку без адреса существующей ссылки
+	for _, options := range []string{`, "redirect_status": 301, "cache_control": "public, max-age=86400", "robots_tag": "noindex"`, ""} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten",
+			strings.NewReader(`{"url": "https://seo.example/landing"`+options+`}`)))
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.JSONEq(t, `{"result": "`+shorten.Result+`"}`, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "https://seo.example/landing", "redirect_status": 302}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error": "url is already shortened, link options can only be set on a new link"}`, w.Body.String())
+
+	obj, err = json.Marshal(models.ShortenReq{URL: "https://seo.example/other", LinkOptions: models.LinkOptions{RedirectStatus: 303}})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(obj)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUpdateUserRecord(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	r := setupRouter(app.NewApp(&config.ServerConfig{}, storage), ratelimit.NewMemoryLimiter())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://example.com/typo")))
+	require.Equal(t, http.StatusCreated, w.Code)
+	id := w.Body.String()
+	cookies := w.Result().Cookies()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://example.com/taken")))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	patch := func(body string, withCookies bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+id, bytes.NewBufferString(body))
+		if withCookies {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, patch(`{"url": "https://example.com/fixed"}`, false).Code)
+	assert.Equal(t, http.StatusBadRequest, patch(`{"url": "javascript:alert(1)"}`, true).Code)
+	assert.Equal(t, http.StatusConflict, patch(`{"url": "https://example.com/taken"}`, true).Code)
+	assert.Equal(t, http.StatusOK, patch(`{"url": "https://example.com/fixed"}`, true).Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	assert.Equal(t, "https://example.com/fixed", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls/"+id+"/history", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var history []models.URLVersion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 1)
+	assert.Equal(t, "https://example.com/typo", history[0].OriginalURL)
+
+	// изменение и история переживают перезапуск
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	link, err := reopened.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/fixed", link.OriginalURL)
+	history, err = reopened.GetHistory(ctx, id, link.UserID)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func TestLinkInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	r := setupRouter(app.NewApp(&config.ServerConfig{}, storage), ratelimit.NewMemoryLimiter())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://example.com/info")))
+	require.Equal(t, http.StatusCreated, w.Code)
+	id := w.Body.String()
+	cookies := w.Result().Cookies()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	info := func(path string, withCookies bool) (int, models.LinkInfoRes) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if withCookies {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+		r.ServeHTTP(w, req)
+		var res models.LinkInfoRes
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	status, res := info("/"+id+"+", true)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "https://example.com/info", res.OriginalURL)
+	assert.NotNil(t, res.CreatedAt)
+	assert.Nil(t, res.ExpiresAt)
+	require.NotNil(t, res.Clicks)
+	assert.Equal(t, int64(1), *res.Clicks)
+
+	// просмотр информации не считается переходом, а чужим пользователям переходы не видны
+	status, res = info("/api/urls/"+id, false)
+	require.Equal(t, http.StatusOK, status)
+	assert.Nil(t, res.Clicks)
+	_, res = info("/api/urls/"+id, true)
+	assert.Equal(t, int64(1), *res.Clicks)
+
+	status, _ = info("/api/urls/missing", false)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// переходы сохраняются без закрытия хранилища, как при аварийном завершении
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	recovered, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	link, err := recovered.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), link.Clicks)
+	recovered.Close()
+	recovered, err = fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	link, err = recovered.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), link.Clicks)
+
+	require.NoError(t, storage.DeleteMany(ctx, models.DeleteUserURLsReq{id}, link.UserID))
+	_, res = info("/"+id+"+", false)
+	assert.True(t, res.Deleted)
+	assert.Empty(t, res.OriginalURL)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+func TestLinkQR(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	r := setupRouter(app.NewApp(&config.ServerConfig{RedirectBaseURL: "http://localhost:8080"}, storage), ratelimit.NewMemoryLimiter())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://example.com/qr")))
+	require.Equal(t, http.StatusCreated, w.Code)
+	id := strings.TrimPrefix(w.Body.String(), "http://localhost:8080/")
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = get("/api/urls/"+id+"/qr?size=200", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err := png.Decode(w.Body)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, img.Bounds().Dx(), 200)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = get("/api/urls/"+id+"/qr?size=200", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+
+	w = get("/api/urls/"+id+"/qr?format=svg&level=H&margin=0", etag)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), "<svg")
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/urls/"+id+"/qr?size=big", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/urls/"+id+"/qr?level=Z", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/urls/missing/qr", "").Code)
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+	a := app.NewApp(&config.ServerConfig{RedirectBaseURL: "http://localhost:8080", RateLimitPassword: "3/m"}, storage)
+	a.Limiter = ratelimit.NewMemoryLimiter()
+	r := setupRouter(a, ratelimit.NewMemoryLimiter())
+
+	shorten := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(body)))
+		return w
+	}
+	w := shorten(`{"url": "https://example.com/private", "password": "s3cret"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var res models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	id := strings.TrimPrefix(res.Result, "http://localhost:8080/")
+
+	// пароль нельзя назначить уже сокращенному URL
+	w = shorten(`{"url": "https://example.com/private", "password": "other"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NotContains(t, w.Body.String(), id)
+
+	remoteAddr := "192.0.2.1:1234"
+	visit := func(method, password string, form bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		var req *http.Request
+		if form {
+			req = httptest.NewRequest(method, "/"+id, strings.NewReader("password="+password))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, "/"+id, nil)
+			if password != "" {
+				req.Header.Set(app.PasswordHeader, password)
+			}
+		}
+		req.RemoteAddr = remoteAddr
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = visit(http.MethodGet, "", false)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), `action="/`+id+`"`)
+	assert.NotContains(t, w.Body.String(), "example.com")
+
+	w = visit(http.MethodGet, "wrong", false)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	w = visit(http.MethodGet, "s3cret", false)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://example.com/private", w.Header().Get("Location"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	// после отправки формы переход выполняется методом GET
+	w = visit(http.MethodPost, "s3cret", true)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://example.com/private", w.Header().Get("Location"))
+
+	// попытки исчерпаны, даже верный пароль не принимается до истечения периода
+	w = visit(http.MethodPost, "s3cret", true)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// подмена X-Forwarded-For не дает новых попыток
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	req.Header.Set(app.PasswordHeader, "guess")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// неудачные попытки одного клиента не закрывают ссылку для других
+	remoteAddr = "203.0.113.1:1234"
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, visit(http.MethodGet, "guess", false).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, visit(http.MethodGet, "guess", false).Code)
+	remoteAddr = "203.0.113.2:1234"
+	assert.Equal(t, http.StatusTemporaryRedirect, visit(http.MethodGet, "s3cret", false).Code)
+
+	// исходный URL защищенной ссылки не раскрывается посторонним
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/urls/"+id, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var info models.LinkInfoRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.True(t, info.Protected)
+	assert.Empty(t, info.OriginalURL)
+
+	w = shorten(`{"url": "https://example.com/pass", "password": "` + strings.Repeat("x", 73) + `"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// хэш пароля сохраняется в файле
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	link, err := reopened.Get(ctx, id)
+	require.NoError(t, err)
+	assert.NotEmpty(t, link.PasswordHash)
+	assert.NotContains(t, link.PasswordHash, "s3cret")
+}
+
+func TestTagsAndCollections(t *testing.T) {
+	storage, client := newTestServer(t, &config.ServerConfig{RedirectBaseURL: "http://localhost:8080"})
+	do := client.do
+	shorten := func(body string) string {
+		w := do(http.MethodPost, "/api/shorten", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var res models.ShortenRes
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return strings.TrimPrefix(res.Result, "http://localhost:8080/")
+	}
+	list := func(query string) []models.URLRecord {
+		w := do(http.MethodGet, "/api/user/urls"+query, "")
+		if w.Code == http.StatusNoContent {
+			return nil
+		}
+		require.Equal(t, http.StatusOK, w.Code)
+		var records []models.URLRecord
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+		return records
+	}
+
+	docs := shorten(`{"url": "https://example.com/docs", "tags": ["Work", " docs ", "work"], "collections": ["Q1"]}`)
+	blog := shorten(`{"url": "https://example.com/blog", "tags": ["work"]}`)
+	shorten(`{"url": "https://example.com/other"}`)
+
+	assert.Len(t, list(""), 3)
+	records := list("?tag=work&tag=docs")
+	require.Len(t, records, 1)
+	assert.Equal(t, []string{"docs", "work"}, records[0].Tags)
+	assert.Equal(t, []string{"Q1"}, records[0].Collections)
+	assert.Len(t, list("?tag=WORK"), 2)
+	assert.Len(t, list("?collection=Q1"), 1)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/user/urls?collection=a/b", "").Code)
+
+	// метки заменяются целиком
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/user/urls/"+blog+"/tags", `["personal"]`).Code)
+	assert.Len(t, list("?tag=work"), 1)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/api/user/urls/missing/tags", `["x"]`).Code)
+
+	w := do(http.MethodGet, "/api/user/tags", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"name":"docs","urls":1},{"name":"personal","urls":1},{"name":"work","urls":1}]`, w.Body.String())
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/user/collections", `{"name": "Reading"}`).Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/user/collections", `{"name": "Reading"}`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPatch, "/api/user/collections/Reading", `{"add": ["`+docs+`", "`+blog+`"]}`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPatch, "/api/user/collections/Reading", `{"remove": ["`+docs+`"]}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/api/user/collections/Reading", `{"add": ["missing"]}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/api/user/collections/Unknown", `{"add": []}`).Code)
+
+	w = do(http.MethodGet, "/api/user/collections", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"name":"Q1","urls":1},{"name":"Reading","urls":1}]`, w.Body.String())
+
+	// удаление коллекции не удаляет ссылки
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/collections/Q1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/collections/Q1", "").Code)
+	assert.Len(t, list(""), 3)
+
+	// метки и коллекции сохраняются в файле
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	link, err := reopened.Get(ctx, blog)
+	require.NoError(t, err)
+	records, err = reopened.GetAllByUserID(ctx, link.UserID, models.URLFilter{Collection: "Reading"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, []string{"personal"}, records[0].Tags)
+}
+
+func TestWorkspaces(t *testing.T) {
+	storage, client := newTestServer(t, &config.ServerConfig{RedirectBaseURL: "http://localhost:8080"})
+	// участник работает со своими cookie через тот же роутер
+	owner, member := client.do, (&testClient{router: client.router}).do
+	members := func(do func(method, path, body string, opts ...requestOption) *httptest.ResponseRecorder, wid string) []models.WorkspaceMember {
+		w := do(http.MethodGet, "/api/workspaces/"+wid+"/members", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res []models.WorkspaceMember
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+	invite := func(wid string, role models.Role) string {
+		w := owner(http.MethodPost, "/api/workspaces/"+wid+"/invites", `{"role": "`+string(role)+`"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var res models.InviteRes
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Token
+	}
+
+	w := owner(http.MethodPost, "/api/workspaces", `{"name": "Marketing"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var workspace models.Workspace
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &workspace))
+	assert.Equal(t, models.RoleOwner, workspace.Role)
+	wid := workspace.ID
+	ownerID := members(owner, wid)[0].UserID
+
+	// посторонний пользователь не видит рабочее пространство и не может создавать в нем ссылки
+	assert.Equal(t, http.StatusNoContent, member(http.MethodGet, "/api/workspaces", "").Code)
+	assert.Equal(t, http.StatusNotFound, member(http.MethodGet, "/api/workspaces/"+wid+"/members", "").Code)
+	assert.Equal(t, http.StatusNotFound, member(http.MethodGet, "/api/user/urls?workspace="+wid, "").Code)
+	assert.Equal(t, http.StatusForbidden, member(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com/intruder", "workspace": "`+wid+`"}`).Code)
+
+	w = owner(http.MethodPost, "/api/shorten", `{"url": "https://example.com/campaign", "workspace": "`+wid+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var shortened models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shortened))
+	slug := strings.TrimPrefix(shortened.Result, "http://localhost:8080/")
+
+	// личная ссылка на тот же URL не переносится в рабочее пространство
+	require.Equal(t, http.StatusCreated, owner(http.MethodPost, "/api/shorten", `{"url": "https://example.com/personal"}`).Code)
+	w = owner(http.MethodPost, "/api/shorten", `{"url": "https://example.com/personal", "workspace": "`+wid+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error": "url is already shortened, a workspace can only be set on a new link"}`, w.Body.String())
+
+	// приглашение одноразовое
+	token := invite(wid, models.RoleViewer)
+	w = member(http.MethodPost, "/api/invites/"+token+"/accept", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": "`+wid+`", "name": "Marketing", "role": "viewer"}`, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, member(http.MethodPost, "/api/invites/"+token+"/accept", "").Code)
+	assert.Equal(t, http.StatusNotFound, member(http.MethodPost, "/api/invites/unknown/accept", "").Code)
+
+	all := members(member, wid)
+	require.Len(t, all, 2)
+	var memberID string
+	for _, m := range all {
+		if m.UserID != ownerID {
+			memberID = m.UserID
+		}
+	}
+
+	// наблюдатель видит ссылки рабочего пространства, но не изменяет их
+	w = member(http.MethodGet, "/api/user/urls?workspace="+wid, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var records []models.URLRecord
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+	require.Len(t, records, 1)
+	assert.Equal(t, wid, records[0].WorkspaceID)
+	assert.Equal(t, http.StatusNoContent, member(http.MethodGet, "/api/user/urls", "").Code)
+	assert.Equal(t, http.StatusForbidden, member(http.MethodPatch, "/api/user/urls/"+slug, `{"url": "https://example.com/changed"}`).Code)
+	assert.Equal(t, http.StatusForbidden, member(http.MethodPut, "/api/user/urls/"+slug+"/tags", `["x"]`).Code)
+	assert.Equal(t, http.StatusForbidden, member(http.MethodPost, "/api/workspaces/"+wid+"/invites", `{"role": "owner"}`).Code)
+	assert.Equal(t, http.StatusForbidden, member(http.MethodPut, "/api/workspaces/"+wid+"/members/"+memberID, `{"role": "owner"}`).Code)
+	// удаление выполняется асинхронно, поэтому права наблюдателя проверяются в хранилище
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.NoError(t, storage.DeleteMany(ctx, models.DeleteUserURLsReq{slug}, memberID))
+	link, err := storage.Get(ctx, slug)
+	require.NoError(t, err)
+	assert.False(t, link.Deleted)
+
+	// редактор изменяет и удаляет ссылки рабочего пространства
+	assert.Equal(t, http.StatusBadRequest, owner(http.MethodPut, "/api/workspaces/"+wid+"/members/"+memberID, `{"role": "admin"}`).Code)
+	assert.Equal(t, http.StatusNoContent, owner(http.MethodPut, "/api/workspaces/"+wid+"/members/"+memberID, `{"role": "editor"}`).Code)
+	assert.Equal(t, http.StatusOK, member(http.MethodPatch, "/api/user/urls/"+slug, `{"url": "https://example.com/changed"}`).Code)
+	assert.Equal(t, http.StatusNoContent, member(http.MethodPut, "/api/user/urls/"+slug+"/tags", `["launch"]`).Code)
+	member(http.MethodDelete, "/api/user/urls", `["`+slug+`"]`)
+	require.Eventually(t, func() bool {
+		w := owner(http.MethodGet, "/api/urls/"+slug, "")
+		var info models.LinkInfoRes
+		return json.Unmarshal(w.Body.Bytes(), &info) == nil && info.Deleted
+	}, time.Second, 10*time.Millisecond)
+
+	// рабочее пространство не остается без владельца
+	assert.Equal(t, http.StatusConflict, owner(http.MethodPut, "/api/workspaces/"+wid+"/members/"+ownerID, `{"role": "viewer"}`).Code)
+	assert.Equal(t, http.StatusConflict, owner(http.MethodDelete, "/api/workspaces/"+wid+"/members/"+ownerID, "").Code)
+	assert.Equal(t, http.StatusNoContent, member(http.MethodDelete, "/api/workspaces/"+wid+"/members/"+memberID, "").Code)
+	assert.Equal(t, http.StatusNotFound, member(http.MethodGet, "/api/workspaces/"+wid+"/members", "").Code)
+
+	// рабочие пространства сохраняются в файле
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	role, err := reopened.GetWorkspaceRole(ctx, wid, ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOwner, role)
+	link, err = reopened.Get(ctx, slug)
+	require.NoError(t, err)
+	assert.Equal(t, wid, link.WorkspaceID)
+	assert.True(t, link.Deleted)
+}
+
+func TestShortDomains(t *testing.T) {
+	_, client := newTestServer(t, &config.ServerConfig{
+		RedirectBaseURL: "http://localhost:8080",
+		ShortDomains:    []string{"https://go.example.com"},
+	})
+	do := client.do
+
+	w := do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/branded", "domain": "GO.example.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var res models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.True(t, strings.HasPrefix(res.Result, "https://go.example.com/"), res.Result)
+	slug := strings.TrimPrefix(res.Result, "https://go.example.com/")
+
+	// ссылка доступна только на своем домене
+	w = do(http.MethodGet, "/"+slug, "", withHost("go.example.com"))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://example.com/branded", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/"+slug, "").Code)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com/unknown", "domain": "evil.example.com"}`).Code)
+
+	// URL сокращается на каждом домене отдельно, повтор на том же домене возвращает его ссылку
+	w = do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/branded"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var defaultRes models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &defaultRes))
+	assert.True(t, strings.HasPrefix(defaultRes.Result, "http://localhost:8080/"), defaultRes.Result)
+	w = do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/branded", "domain": "go.example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"result": "`+res.Result+`"}`, w.Body.String())
+
+	// один ID на разных доменах
+	w = do(http.MethodPost, "/api/shorten/batch", `[
+		{"correlation_id": "promo", "original_url": "https://example.com/default"},
+		{"correlation_id": "promo", "original_url": "https://example.com/promo", "domain": "go.example.com"}
+	]`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.JSONEq(t, `[
+		{"correlation_id": "promo", "short_url": "http://localhost:8080/promo"},
+		{"correlation_id": "promo", "short_url": "https://go.example.com/promo"}
+	]`, w.Body.String())
+	assert.Equal(t, "https://example.com/default", do(http.MethodGet, "/promo", "").Header().Get("Location"))
+	assert.Equal(t, "https://example.com/promo", do(http.MethodGet, "/promo", "", withHost("go.example.com")).Header().Get("Location"))
+
+	// correlation_id не может задать ключ ссылки другого домена или занять существующий ID
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id": "go.example.com/promo", "original_url": "https://example.com/hijack"}]`).Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id": "promo", "original_url": "https://example.com/hijack"}]`).Code)
+	assert.Equal(t, "https://example.com/promo", do(http.MethodGet, "/promo", "", withHost("go.example.com")).Header().Get("Location"))
+	assert.Equal(t, "https://example.com/default", do(http.MethodGet, "/promo", "").Header().Get("Location"))
+
+	w = do(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var records []models.URLRecord
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+	var shortURLs []string
+	for _, record := range records {
+		shortURLs = append(shortURLs, record.ShortURL)
+	}
+	assert.ElementsMatch(t, []string{res.Result, defaultRes.Result, "http://localhost:8080/promo", "https://go.example.com/promo"}, shortURLs)
+
+	// управление ссылкой дополнительного домена через параметр domain
+	w = do(http.MethodPatch, "/api/user/urls/promo?domain=go.example.com", `{"url": "https://example.com/promo2"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"short_url": "https://go.example.com/promo", "original_url": "https://example.com/promo2"}`, w.Body.String())
+	assert.Equal(t, "https://example.com/default", do(http.MethodGet, "/promo", "").Header().Get("Location"))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/urls/promo?domain=evil.example.com", "").Code)
+}
+
+func TestRedirectRules(t *testing.T) {
+	storage, client := newTestServer(t, &config.ServerConfig{RedirectBaseURL: "http://localhost:8080"})
+	do := client.do
+	iphone := withHeader("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148")
+	android := withHeader("User-Agent", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36")
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com/app", "rules": [{"platform": "symbian", "url": "https://example.com"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com/app", "rules": [{"platform": "ios", "url": "ftp://example.com"}]}`).Code)
+
+	w := do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/app", "rules": [
+		{"platform": "ios", "url": "https://apps.apple.com/app/id1"},
+		{"platform": "android", "url": "https://play.google.com/store/apps/details?id=app"}
+	]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var res models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	id := strings.TrimPrefix(res.Result, "http://localhost:8080/")
+
+	w = do(http.MethodGet, "/"+id, "", iphone)
+	assert.Equal(t, "https://apps.apple.com/app/id1", w.Header().Get("Location"))
+	assert.Equal(t, "User-Agent, Accept-Language", w.Header().Get("Vary"))
+	assert.Equal(t, "https://play.google.com/store/apps/details?id=app", do(http.MethodGet, "/"+id, "", android).Header().Get("Location"))
+	assert.Equal(t, "https://example.com/app", do(http.MethodGet, "/"+id, "").Header().Get("Location"))
+
+	// правила заменяются целиком
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/user/urls/"+id+"/rules",
+		`[{"language": "de", "url": "HTTPS://Example.DE/app"}, {"query": {"src": "qr"}, "url": "https://example.com/qr"}]`).Code)
+	w = do(http.MethodGet, "/api/user/urls/"+id+"/rules", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"language": "de", "url": "https://example.de/app"}, {"query": {"src": "qr"}, "url": "https://example.com/qr"}]`, w.Body.String())
+	assert.Equal(t, "https://example.com/app", do(http.MethodGet, "/"+id, "", iphone).Header().Get("Location"))
+	assert.Equal(t, "https://example.de/app", do(http.MethodGet, "/"+id, "", withHeader("Accept-Language", "de-AT, en;q=0.5")).Header().Get("Location"))
+	assert.Equal(t, "https://example.com/qr", do(http.MethodGet, "/"+id+"?src=qr", "").Header().Get("Location"))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/api/user/urls/missing/rules", `[]`).Code)
+
+	// правила сохраняются в файле
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	link, err := reopened.Get(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, link.Options.Rules, 2)
+	assert.Equal(t, "de", link.Options.Rules[0].Language)
+
+	client.router = setupRouter(app.NewApp(&config.ServerConfig{RedirectBaseURL: "http://localhost:8080"}, reopened), ratelimit.NewMemoryLimiter())
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/user/urls/"+id+"/rules", `[]`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls/"+id+"/rules", "").Code)
+	assert.Empty(t, do(http.MethodGet, "/"+id, "").Header().Get("Vary"))
+}
+
+func TestSplitDestinations(t *testing.T) {
+	storage, client := newTestServer(t, &config.ServerConfig{RedirectBaseURL: "http://localhost:8080"})
+	do := client.do
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com", "destinations": [{"url": "https://example.com/a", "weight": 0}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com", "sticky": true}`).Code)
+
+	// вариант с нулевым весом не выбирается, поэтому переход детерминирован
+	w := do(http.MethodPost, "/api/shorten", `{"url": "https://example.com", "sticky": true, "destinations": [
+		{"id": "a", "url": "https://example.com/a", "weight": 1},
+		{"id": "b", "url": "HTTPS://Example.com/b", "weight": 0}
+	]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var res models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	id := strings.TrimPrefix(res.Result, "http://localhost:8080/")
+
+	w = do(http.MethodGet, "/"+id, "")
+	assert.Equal(t, "https://example.com/a", w.Header().Get("Location"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var sticky *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "split_"+id {
+			sticky = cookie
+		}
+	}
+	require.NotNil(t, sticky)
+	assert.Equal(t, "a", sticky.Value)
+	assert.True(t, sticky.HttpOnly)
+
+	// веса меняются без изменения ID, посетитель остается на своем варианте, пока он активен
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/user/urls/"+id+"/destinations", `{"sticky": true, "destinations": [
+		{"id": "a", "url": "https://example.com/a", "weight": 1},
+		{"id": "b", "url": "https://example.com/b", "weight": 1}
+	]}`).Code)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "https://example.com/a", do(http.MethodGet, "/"+id, "", withHeader("Cookie", "split_"+id+"=a")).Header().Get("Location"))
+	}
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/user/urls/"+id+"/destinations", `{"destinations": [
+		{"id": "a", "url": "https://example.com/a", "weight": 0},
+		{"id": "b", "url": "https://example.com/b", "weight": 3}
+	]}`).Code)
+	w = do(http.MethodGet, "/"+id, "", withHeader("Cookie", "split_"+id+"=a"))
+	assert.Equal(t, "https://example.com/b", w.Header().Get("Location"))
+	assert.Empty(t, w.Result().Cookies())
+
+	w = do(http.MethodGet, "/api/user/urls/"+id+"/destinations", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"sticky": false, "destinations": [
+		{"id": "a", "url": "https://example.com/a", "weight": 0, "clicks": 6},
+		{"id": "b", "url": "https://example.com/b", "weight": 3, "clicks": 1}
+	]}`, w.Body.String())
+
+	var info models.LinkInfoRes
+	require.NoError(t, json.Unmarshal(do(http.MethodGet, "/api/urls/"+id, "").Body.Bytes(), &info))
+	require.Len(t, info.Destinations, 2)
+	assert.Equal(t, int64(1), info.Destinations[1].Clicks)
+	require.NotNil(t, info.Clicks)
+	assert.Equal(t, int64(7), *info.Clicks)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/user/urls/"+id+"/destinations",
+		`{"destinations": [{"id": "a", "url": "https://example.com/a", "weight": 0}]}`).Code)
+
+	// счетчики вариантов сохраняются при перезапуске
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	clicks, err := reopened.GetDestinationClicks(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"a": 6, "b": 1}, clicks)
+	link, err := reopened.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, 3, link.Options.Destinations[1].Weight)
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	_, client := newTestServer(t, &config.ServerConfig{RedirectBaseURL: "http://localhost:8080"})
+	do := client.do
+	shorten := func(body string) string {
+		w := do(http.MethodPost, "/api/shorten", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var res models.ShortenRes
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return strings.TrimPrefix(res.Result, "http://localhost:8080/")
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten", `{"url": "https://example.com", "pass_query": "append"}`).Code)
+
+	plain := shorten(`{"url": "https://example.com/plain?lang=de"}`)
+	assert.Equal(t, "https://example.com/plain?lang=de", do(http.MethodGet, "/"+plain+"?lang=en", "").Header().Get("Location"))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/"+plain+"/docs", "").Code)
+
+	id := shorten(`{"url": "https://example.com/base?lang=de", "pass_path": true, "pass_query": "merge",
+		"utm": {"source": "newsletter", "campaign": "spring"}}`)
+	assert.Equal(t, "https://example.com/base?lang=de&utm_campaign=spring&utm_source=newsletter",
+		do(http.MethodGet, "/"+id, "").Header().Get("Location"))
+	w := do(http.MethodGet, "/"+id+"/docs/page?lang=en&ref=tw", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://example.com/base/docs/page?lang=de&ref=tw&utm_campaign=spring&utm_source=newsletter", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/"+id+"/docs/../../admin", "").Code)
+
+	// переход с переносом пути учитывается как переход по ссылке
+	var info models.LinkInfoRes
+	require.NoError(t, json.Unmarshal(do(http.MethodGet, "/api/urls/"+id, "").Body.Bytes(), &info))
+	require.NotNil(t, info.Clicks)
+	assert.Equal(t, int64(2), *info.Clicks)
+
+	override := shorten(`{"url": "https://example.com/?lang=de", "pass_query": "override", "utm": {"source": "newsletter"}}`)
+	assert.Equal(t, "https://example.com/?lang=en&utm_source=qr",
+		do(http.MethodGet, "/"+override+"?lang=en&utm_source=qr", "").Header().Get("Location"))
+
+	// форма пароля отправляется на тот же адрес, путь и параметры переносятся после ввода пароля
+	protected := shorten(`{"url": "https://example.com/private", "password": "s3cret", "pass_path": true, "pass_query": "merge"}`)
+	w = do(http.MethodGet, "/"+protected+"/docs/page?lang=en", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `action="/`+protected+`/docs/page?lang=en"`)
+	req := httptest.NewRequest(http.MethodPost, "/"+protected+"/docs/page?lang=en", strings.NewReader("password=s3cret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	client.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://example.com/private/docs/page?lang=en", w.Header().Get("Location"))
+}
+
+func TestWebhooks(t *testing.T) {
+	storage, client := newTestServer(t, &config.ServerConfig{RedirectBaseURL: "http://localhost:8080"})
+	do := client.do
+
+	var secret string
+	var events []models.WebhookPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if !webhook.Verify(secret, timestamp, body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event models.WebhookPayload
+		if err := json.Unmarshal(body, &event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, event)
+	}))
+	defer receiver.Close()
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/webhooks", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/webhooks", `{"url": "`+receiver.URL+`", "events": ["link.updated"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/webhooks", `{"url": "`+receiver.URL+`", "events": ["link.click_threshold"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/webhooks", `{"url": "ftp://example.com", "events": ["link.created"]}`).Code)
+
+	w := do(http.MethodPost, "/api/user/webhooks", `{"url": "`+receiver.URL+`",
+		"events": ["link.created", "link.deleted", "link.click_threshold", "link.created", "link.expired"], "click_threshold": 2}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created models.WebhookRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	secret = created.Secret
+	assert.Len(t, secret, 64)
+	assert.Equal(t, []string{"link.created", "link.deleted", "link.click_threshold", "link.expired"}, created.Events)
+
+	w = do(http.MethodGet, "/api/user/webhooks", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), secret)
+
+	w = do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/crm"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var res models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	id := strings.TrimPrefix(res.Result, "http://localhost:8080/")
+	// повторное сокращение не создает ссылку
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/crm"}`).Code)
+	for i := 0; i < 3; i++ {
+		do(http.MethodGet, "/"+id, "")
+	}
+	assert.Equal(t, http.StatusAccepted, do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`).Code)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	link, err := storage.Get(ctx, id)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		deliveries, err := storage.GetDeliveries(ctx, created.ID, link.UserID)
+		return err == nil && len(deliveries) == 3
+	}, time.Second, time.Millisecond*10)
+
+	// срок действия задается только в будущем, по истечении переход невозможен и отправляется link.expired
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten",
+		`{"url": "https://example.com/past", "expires_at": "2020-01-01T00:00:00Z"}`).Code)
+	expiresAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	_, err = storage.Put(ctx, models.Link{
+		ID: "expiring", OriginalURL: "https://example.com/expiring", UserID: link.UserID,
+		Options: models.LinkOptions{ExpiresAt: &expiresAt},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGone, do(http.MethodGet, "/expiring", "").Code)
+	w = do(http.MethodGet, "/api/urls/expiring", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var info models.LinkInfoRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	require.NotNil(t, info.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*info.ExpiresAt))
+	expired, err := storage.ExpireLinks(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	expired, err = storage.ExpireLinks(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	// события сохраняются в хранилище и доставляются после перезапуска
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	reopenedApp := app.NewApp(&config.ServerConfig{RedirectBaseURL: "http://localhost:8080"}, reopened)
+	dispatcher := webhook.NewDispatcher(reopened, receiver.Client(), reopenedApp.WebhookPayload)
+	n, err := dispatcher.DeliverPending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	require.Len(t, events, 5)
+	assert.Equal(t, models.EventLinkCreated, events[0].Type)
+	assert.Equal(t, res.Result, events[0].Data.ShortURL)
+	assert.Equal(t, "https://example.com/crm", events[0].Data.OriginalURL)
+	assert.Equal(t, models.EventLinkClickThreshold, events[1].Type)
+	assert.Equal(t, int64(2), events[1].Data.Clicks)
+	assert.Equal(t, models.EventLinkDeleted, events[2].Type)
+	assert.Equal(t, models.EventLinkCreated, events[3].Type)
+	assert.Equal(t, models.EventLinkExpired, events[4].Type)
+	assert.Equal(t, "http://localhost:8080/expiring", events[4].Data.ShortURL)
+
+	client.router = setupRouter(reopenedApp, ratelimit.NewMemoryLimiter())
+	w = do(http.MethodGet, "/api/user/webhooks/"+created.ID+"/deliveries", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var deliveries []models.WebhookDelivery
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries, 5)
+	for _, delivery := range deliveries {
+		assert.Equal(t, models.DeliveryDelivered, delivery.Status)
+		assert.Equal(t, http.StatusOK, delivery.LastStatus)
+	}
+	var payload models.WebhookPayload
+	require.NoError(t, json.Unmarshal(deliveries[0].Payload, &payload))
+	assert.Equal(t, events[4], payload)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/webhooks/"+created.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/webhooks/"+created.ID+"/deliveries", "").Code)
+}
+
+func TestEvents(t *testing.T) {
+	conf := &config.ServerConfig{RedirectBaseURL: "http://localhost:8080", TrustedSubnet: "192.0.2.0/24"}
+	storage, client := newTestServer(t, conf)
+	do := client.do
+	changes := func(w *httptest.ResponseRecorder) models.ChangesRes {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res models.ChangesRes
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/internal/events?since=-1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/internal/events?limit=5000", "").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/internal/events?wait=0", "").Code)
+
+	w := do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/events"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var res models.ShortenRes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	id := strings.TrimPrefix(res.Result, "http://localhost:8080/")
+	// повторное сокращение не создает ссылку и не пишет событие
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/events"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten/batch",
+		`[{"correlation_id": "batch1", "original_url": "https://example.com/batch"}]`).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPatch, "/api/user/urls/"+id, `{"url": "https://example.com/events2"}`).Code)
+
+	page := changes(do(http.MethodGet, "/api/internal/events?limit=2", ""))
+	require.Len(t, page.Events, 2)
+	assert.Equal(t, models.EventLinkCreated, page.Events[0].Type)
+	assert.Equal(t, id, page.Events[0].LinkID)
+	assert.Equal(t, "https://example.com/events", page.Events[0].OriginalURL)
+	assert.Equal(t, models.EventLinkCreated, page.Events[1].Type)
+	assert.Equal(t, page.Events[1].ID, page.Next)
+
+	page = changes(do(http.MethodGet, "/api/internal/events?since="+strconv.FormatInt(page.Next, 10), ""))
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, models.EventLinkUpdated, page.Events[0].Type)
+	assert.Equal(t, models.FieldOriginalURL, page.Events[0].Field)
+	assert.Equal(t, "https://example.com/events2", page.Events[0].OriginalURL)
+	next := page.Next
+
+	// ожидающий запрос получает событие, записанное после его начала
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`)
+	}()
+	w = httptest.NewRecorder()
+	client.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/internal/events?wait=5&since="+strconv.FormatInt(next, 10), nil))
+	page = changes(w)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, models.EventLinkDeleted, page.Events[0].Type)
+	assert.Equal(t, id, page.Events[0].LinkID)
+
+	// курсор действителен после перезапуска
+	storage.Close()
+	reopened, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	client.router = setupRouter(app.NewApp(conf, reopened), ratelimit.NewMemoryLimiter())
+	all := changes(do(http.MethodGet, "/api/internal/events", ""))
+	require.Len(t, all.Events, 4)
+	assert.Equal(t, page.Next, all.Next)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url": "https://example.com/after"}`).Code)
+	page = changes(do(http.MethodGet, "/api/internal/events?since="+strconv.FormatInt(all.Next, 10), ""))
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, all.Next+1, page.Events[0].ID)
+
+	// поток SSE продолжается с ID из Last-Event-ID
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/events", nil).WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(next, 10))
+	w = httptest.NewRecorder()
+	client.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Equal(t, 2, strings.Count(body, "\n\n"), body)
+	assert.Contains(t, body, fmt.Sprintf("id: %d\nevent: link.deleted\n", next+1))
+	assert.Contains(t, body, fmt.Sprintf("id: %d\nevent: link.created\n", next+2))
+
+	// курсор, события после которого удалены из журнала, отклоняется, чтение без курсора начинается с самого старого
+	reopened.LoadChanges([]models.ChangeEvent{
+		{ID: next + 10, Type: models.EventLinkCreated, LinkID: "kept"},
+		{ID: next + 11, Type: models.EventLinkCreated, LinkID: "newest"},
+	})
+	w = do(http.MethodGet, "/api/internal/events?since="+strconv.FormatInt(next, 10), "")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), models.ErrCursorExpired.Error())
+	w = do(http.MethodGet, "/api/internal/events", "", withHeader("Accept", "text/event-stream"), withHeader("Last-Event-ID", strconv.FormatInt(next, 10)))
+	assert.Equal(t, http.StatusGone, w.Code)
+	page = changes(do(http.MethodGet, "/api/internal/events?since="+strconv.FormatInt(next+9, 10), ""))
+	require.Len(t, page.Events, 2)
+	all = changes(do(http.MethodGet, "/api/internal/events", ""))
+	require.Len(t, all.Events, 2)
+	assert.Equal(t, "kept", all.Events[0].LinkID)
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := fs.NewFileStorage("./test.json")
+	require.NoError(t, err)
+	defer storage.DeleteStorageFile()
+
+	shorten := func(r *gin.Engine, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("https://example.com/"+forwardedFor))
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// без доверенных прокси заголовок не меняет IP клиента
+	r := setupRouter(app.NewApp(&config.ServerConfig{RateLimitShorten: "1/m"}, storage), ratelimit.NewMemoryLimiter())
+	assert.Equal(t, http.StatusCreated, shorten(r, "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, shorten(r, "198.51.100.2"))
+
+	// запросы через доверенный прокси считаются по IP из заголовка
+	r = setupRouter(app.NewApp(&config.ServerConfig{RateLimitShorten: "1/m", TrustedProxies: []string{"192.0.2.0/24"}}, storage), ratelimit.NewMemoryLimiter())
+	assert.Equal(t, http.StatusCreated, shorten(r, "198.51.100.3"))
+	assert.Equal(t, http.StatusCreated, shorten(r, "198.51.100.4"))
+	assert.Equal(t, http.StatusTooManyRequests, shorten(r, "198.51.100.4"))
+}
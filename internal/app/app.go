@@ -2,59 +2,127 @@
 package app
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/EvgeniyBudaev/shortener/internal/auth"
 	"github.com/EvgeniyBudaev/shortener/internal/config"
 	"github.com/EvgeniyBudaev/shortener/internal/models"
-	"github.com/EvgeniyBudaev/shortener/internal/store/postgres"
+	"github.com/EvgeniyBudaev/shortener/internal/qr"
+	"github.com/EvgeniyBudaev/shortener/internal/ratelimit"
+	"github.com/EvgeniyBudaev/shortener/internal/rules"
+	"github.com/EvgeniyBudaev/shortener/internal/urlnorm"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Store Интерфейс содержит все необходимые методы для работы сервиса.
 type Store interface {
-	Get(ctx *gin.Context, id string) (string, error)
-	GetAllByUserID(ctx *gin.Context, userID string) ([]models.URLRecord, error)
+	Get(ctx *gin.Context, id string) (models.Link, error)
+	GetAllByUserID(ctx *gin.Context, userID string, filter models.URLFilter) ([]models.URLRecord, error)
 	DeleteMany(ctx *gin.Context, ids models.DeleteUserURLsReq, userID string) error
-	Put(ctx *gin.Context, id string, shortURL string, userID string) (string, error)
+	Put(ctx *gin.Context, link models.Link) (string, error)
 	PutBatch(ctx *gin.Context, data []models.URLBatchReq, userID string) ([]models.URLBatchRes, error)
+	UpdateURL(ctx *gin.Context, id string, userID string, originalURL string) (string, error)
+	SetRules(ctx *gin.Context, id string, userID string, rules []models.RedirectRule) error
+	SetDestinations(ctx *gin.Context, id string, userID string, destinations []models.Destination, sticky bool) error
+	GetHistory(ctx *gin.Context, id string, userID string) ([]models.URLVersion, error)
+	SetTags(ctx *gin.Context, id string, userID string, tags []string) error
+	GetTags(ctx *gin.Context, userID string) ([]models.LabelRes, error)
+	CreateCollection(ctx *gin.Context, userID string, name string) error
+	UpdateCollection(ctx *gin.Context, userID string, name string, add []string, remove []string) error
+	DeleteCollection(ctx *gin.Context, userID string, name string) error
+	GetCollections(ctx *gin.Context, userID string) ([]models.LabelRes, error)
+	CreateWorkspace(ctx *gin.Context, workspace models.Workspace, ownerID string) error
+	GetWorkspaces(ctx *gin.Context, userID string) ([]models.Workspace, error)
+	GetWorkspaceRole(ctx *gin.Context, workspaceID string, userID string) (models.Role, error)
+	GetWorkspaceMembers(ctx *gin.Context, workspaceID string) ([]models.WorkspaceMember, error)
+	SetWorkspaceMember(ctx *gin.Context, workspaceID string, memberID string, role models.Role) error
+	CreateInvite(ctx *gin.Context, invite models.WorkspaceInvite) error
+	AcceptInvite(ctx *gin.Context, tokenHash string, userID string) (models.Workspace, error)
+	CreateWebhook(ctx *gin.Context, webhook models.Webhook, userID string, secret string) error
+	GetWebhooks(ctx *gin.Context, userID string) ([]models.Webhook, error)
+	DeleteWebhook(ctx *gin.Context, id string, userID string) error
+	GetDeliveries(ctx *gin.Context, webhookID string, userID string) ([]models.WebhookDelivery, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	GetChanges(ctx context.Context, since int64, limit int) ([]models.ChangeEvent, error)
+	RecordClick(ctx context.Context, id string, destination string) (int64, error)
+	ExpireLinks(ctx context.Context, now time.Time) (int, error)
+	GetDestinationClicks(ctx *gin.Context, id string) (map[string]int64, error)
+	GetStats(ctx *gin.Context) (models.StatsRes, error)
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
 	Ping() error
 }
 
+// DomainPolicy политика допустимых доменов исходных URL
+type DomainPolicy interface {
+	Check(host string) error
+}
+
+// URLValidator дополнительная проверка исходного URL перед сохранением
+type URLValidator interface {
+	Validate(ctx context.Context, originalURL string) error
+}
+
 // App структура приложения
 type App struct {
-	Config *config.ServerConfig
-	store  Store
+	Config *config.Holder
+	// Domains политика доменов, nil - ограничений нет
+	Domains DomainPolicy
+	// Validator проверка исходных URL, nil - не выполняется
+	Validator URLValidator
+	// Limiter учет попыток ввода пароля ссылок, nil - попытки не ограничиваются
+	Limiter ratelimit.Limiter
+	store   Store
 }
 
 // NewApp конструктор приложения
-func NewApp(config *config.ServerConfig, store Store) *App {
+func NewApp(conf *config.ServerConfig, store Store) *App {
 	return &App{
-		Config: config,
+		Config: config.NewHolder(conf),
 		store:  store,
 	}
 }
 
-// DeleteUserRecords удаление записей по пользователю
+// Storage хранилище приложения
+func (a *App) Storage() Store {
+	return a.store
+}
+
+// DeleteUserRecords удаление записей по пользователю, параметр domain задает домен ссылок
 func (a *App) DeleteUserRecords(c *gin.Context) {
 	req := c.Request
 	res := c.Writer
 	userID := c.GetString(auth.UserIDKey)
 	batch := make(models.DeleteUserURLsReq, 0)
+	domain, err := a.shortDomain(c.Query("domain"))
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
 
 	deleteChan := make(chan models.DeleteUserURLsReq)
 	done := make(chan bool)
 
+	// удаление продолжается после ответа, поэтому используется копия контекста запроса
+	ctx := c.Copy()
 	deleteWorker := func() {
 		for batch := range deleteChan {
-			err := a.store.DeleteMany(c, batch, userID)
+			err := a.store.DeleteMany(ctx, batch, userID)
 			if err != nil {
 				log.Printf("error deleting: %v", err)
 			}
@@ -71,6 +139,9 @@ func (a *App) DeleteUserRecords(c *gin.Context) {
 			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		for idx, id := range batch {
+			batch[idx] = linkKey(domain, id)
+		}
 		batchCh <- batch
 		close(batchCh)
 	}()
@@ -85,12 +156,23 @@ func (a *App) DeleteUserRecords(c *gin.Context) {
 	res.WriteHeader(http.StatusAccepted)
 }
 
-// GetUserRecords получение всех записей пользователя
+// GetUserRecords получение записей пользователя с метками и коллекциями.
+// Параметры tag (можно повторять) и collection отбирают ссылки, имеющие все метки и входящие в коллекцию,
+// параметр workspace заменяет личные ссылки ссылками рабочего пространства.
 func (a *App) GetUserRecords(c *gin.Context) {
 	res := c.Writer
 	userID := c.GetString(auth.UserIDKey)
 
-	records, err := a.store.GetAllByUserID(c, userID)
+	filter, err := urlFilter(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	records, err := a.store.GetAllByUserID(c, userID, filter)
+	if errors.Is(err, models.ErrNotFound) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("Error getting all user urls: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -103,7 +185,7 @@ func (a *App) GetUserRecords(c *gin.Context) {
 	}
 
 	for idx, urlObj := range records {
-		resultURL, err := url.JoinPath(a.Config.RedirectBaseURL, urlObj.ShortURL)
+		resultURL, err := a.shortURL(urlObj.ShortURL)
 		if err != nil {
 			log.Printf("URL cannot be joined: %v", err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -121,30 +203,323 @@ func (a *App) GetUserRecords(c *gin.Context) {
 	}
 }
 
-// RedirectURL перенаправление на URL
+// UpdateUserRecord изменение исходного URL ссылки ее владельцем или редактором ее рабочего пространства.
+// Новый URL проходит те же проверки, что и при сокращении.
+func (a *App) UpdateUserRecord(c *gin.Context) {
+	userID := c.GetString(auth.UserIDKey)
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	var update models.UpdateURLReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&update); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	originalURL, err := a.normalizeURL(update.URL)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := a.checkDomain(originalURL); err != nil {
+		auditDenied(c, "update", originalURL, err)
+		c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorRes{Error: err.Error()})
+		return
+	}
+	if err := a.validateURL(c, originalURL); err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	slug, err := a.store.UpdateURL(c, id, userID, originalURL)
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrForbidden):
+		forbidden(c)
+		return
+	case errors.Is(err, models.ErrURLConflict):
+		shortURL, _ := a.shortURL(slug)
+		c.AbortWithStatusJSON(http.StatusConflict, models.ErrorRes{
+			Error: fmt.Sprintf("url is already shortened as %s", shortURL),
+		})
+		return
+	case err != nil:
+		log.Printf("Error updating original URL: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	shortURL, err := a.shortURL(slug)
+	if err != nil {
+		log.Printf("URL cannot be joined: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, models.URLRecord{ShortURL: shortURL, OriginalURL: originalURL})
+}
+
+// GetRecordHistory предыдущие исходные URL ссылки пользователя
+func (a *App) GetRecordHistory(c *gin.Context) {
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	history, err := a.store.GetHistory(c, id, c.GetString(auth.UserIDKey))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting URL history: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// RedirectURL перенаправление на URL с учетом перехода.
+// Для ID с суффиксом + вместо перехода возвращается информация о ссылке.
+// Для ссылки с паролем переход выполняется только после его проверки.
+// Ссылка ищется на домене из заголовка Host. Путь после ID и параметры запроса
+// переносятся в адрес перехода, если это разрешено настройками ссылки.
+// Для удаленной ссылки и ссылки с истекшим сроком действия возвращается 410.
 func (a *App) RedirectURL(c *gin.Context) {
 	res := c.Writer
+	domain := a.requestDomain(c)
 	id := c.Param("id")
+	if slug, ok := strings.CutSuffix(id, "+"); ok {
+		a.linkInfo(c, linkKey(domain, slug))
+		return
+	}
+	slug := id
+	id = linkKey(domain, id)
 
-	originalURL, err := a.store.Get(c, id)
+	link, err := a.store.Get(c, id)
 	if err != nil {
-		if errors.Is(err, postgres.ErrURLDeleted) {
-			res.WriteHeader(http.StatusGone)
-			return
-		} else {
-			log.Printf("Error getting original URL: %v", err)
-			res.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Error getting original URL: %v", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if link.OriginalURL == "" {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if link.Deleted || link.Options.Expired(time.Now()) {
+		res.WriteHeader(http.StatusGone)
+		return
+	}
+	location := link.OriginalURL
+	var destination string
+	if target, ok := rules.Select(link.Options.Rules, c.Request); ok {
+		location = target
+	} else if d, ok := a.pickDestination(c, link, slug); ok {
+		location = d.URL
+		destination = d.ID
+	}
+	location, err = rules.Forward(location, link.Options, c.Param("path"), c.Request.URL.Query())
+	if errors.Is(err, rules.ErrPathNotAllowed) {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	// домен мог попасть в список запрещенных после сокращения ссылки
+	if err := a.checkDomain(location); err != nil {
+		auditDenied(c, "redirect", location, err)
+		res.WriteHeader(http.StatusUnavailableForLegalReasons)
+		return
+	}
+	if link.PasswordHash != "" && !a.checkPassword(c, link) {
+		return
+	}
+
+	if _, err := a.store.RecordClick(c, id, destination); err != nil {
+		log.Printf("Error recording click: %v", err)
+	}
+	a.redirect(c, link, location)
+}
+
+// LinkInfo информация о ссылке без перехода по ней, параметр domain задает домен ссылки
+func (a *App) LinkInfo(c *gin.Context) {
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	a.linkInfo(c, id)
+}
+
+// linkInfo ответ с информацией о ссылке. Количество переходов видно только владельцу и участникам
+// ее рабочего пространства, исходный URL удаленной ссылки не раскрывается, а ссылки с паролем - только им.
+func (a *App) linkInfo(c *gin.Context, id string) {
+	link, err := a.store.Get(c, id)
+	if err != nil {
+		log.Printf("Error getting original URL: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if link.OriginalURL == "" {
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	shortURL, err := a.shortURL(id)
+	if err != nil {
+		log.Printf("URL cannot be joined: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	owner := a.canView(c, link, c.GetString(auth.UserIDKey))
+	info := models.LinkInfoRes{
+		ShortURL:  shortURL,
+		ExpiresAt: link.Options.ExpiresAt,
+		Deleted:   link.Deleted,
+		Protected: link.PasswordHash != "",
+	}
+	if !link.Deleted && (!info.Protected || owner) {
+		info.OriginalURL = link.OriginalURL
+	}
+	if !link.CreatedAt.IsZero() {
+		info.CreatedAt = &link.CreatedAt
+	}
+	if owner {
+		info.Clicks = &link.Clicks
+		if info.Destinations, err = a.destinationStats(c, id, link.Options.Destinations); err != nil {
+			log.Printf("Error getting destination clicks: %v", err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
+	c.JSON(http.StatusOK, info)
+}
 
-	if originalURL == "" {
-		res.WriteHeader(http.StatusNotFound)
+// LinkQR QR-код короткой ссылки в формате PNG или SVG.
+// Параметры запроса: format (png, svg), size в пикселях, level (L, M, Q, H), margin в модулях
+// и domain - домен ссылки.
+func (a *App) LinkQR(c *gin.Context) {
+	opts, err := parseQROptions(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	link, err := a.store.Get(c, id)
+	if err != nil {
+		log.Printf("Error getting original URL: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if link.OriginalURL == "" {
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if link.Deleted {
+		c.Writer.WriteHeader(http.StatusGone)
+		return
+	}
+
+	shortURL, err := a.shortURL(id)
+	if err != nil {
+		log.Printf("URL cannot be joined: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// изображение зависит только от короткого URL и параметров, поэтому ETag вычисляется без генерации
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%d", shortURL, opts.Format, opts.Size, opts.Level, opts.Margin)))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=86400")
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == "*" || strings.Contains(match, etag)) {
+		c.Writer.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	res.Header().Set("Location", originalURL)
-	res.WriteHeader(http.StatusTemporaryRedirect)
+	image, err := qr.Render(shortURL, opts)
+	if err != nil {
+		log.Printf("Error rendering QR code: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, opts.ContentType(), image)
+}
+
+// parseQROptions параметры QR-кода из строки запроса, отсутствующие берутся по умолчанию
+func parseQROptions(c *gin.Context) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	opts.Format = strings.ToLower(c.DefaultQuery("format", opts.Format))
+	opts.Level = strings.ToUpper(c.DefaultQuery("level", opts.Level))
+	for name, value := range map[string]*int{"size": &opts.Size, "margin": &opts.Margin} {
+		raw, ok := c.GetQuery(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be an integer", name)
+		}
+		*value = n
+	}
+	return opts, opts.Validate()
+}
+
+// redirect ответ с перенаправлением на location: настройки ссылки переопределяют настройки сервиса
+func (a *App) redirect(c *gin.Context, link models.Link, location string) {
+	conf := a.Config.Get()
+	status := firstNonZero(link.Options.RedirectStatus, conf.RedirectStatus, http.StatusTemporaryRedirect)
+	headers := map[string]string{
+		"Cache-Control":   firstNonZero(link.Options.CacheControl, conf.RedirectCacheControl),
+		"Referrer-Policy": firstNonZero(link.Options.ReferrerPolicy, conf.ReferrerPolicy),
+		"X-Robots-Tag":    firstNonZero(link.Options.RobotsTag, conf.RobotsTag),
+	}
+	// переход по ссылке с паролем не должен попадать в кэш, иначе его можно повторить без пароля,
+	// а переход с разделением трафика - иначе все посетители за кэшем получат один вариант
+	if link.PasswordHash != "" || len(link.Options.Destinations) > 0 {
+		headers["Cache-Control"] = "no-store"
+	}
+	// адрес перехода по правилам зависит от заголовков запроса, это должны учитывать кэши
+	if len(link.Options.Rules) > 0 {
+		c.Header("Vary", "User-Agent, Accept-Language")
+	}
+	// после отправки формы пароля браузер должен перейти по ссылке методом GET
+	if c.Request.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	for name, value := range headers {
+		if value != "" {
+			c.Header(name, value)
+		}
+	}
+	c.Header("Location", location)
+	c.Writer.WriteHeader(status)
+}
+
+// firstNonZero первое непустое значение
+func firstNonZero[T comparable](values ...T) T {
+	var zero T
+	for _, v := range values {
+		if v != zero {
+			return v
+		}
+	}
+	return zero
 }
 
 // ShortenBatch метод по работе с батчем
@@ -155,12 +530,52 @@ func (a *App) ShortenBatch(c *gin.Context) {
 
 	batch := make([]models.URLBatchReq, 0)
 	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
-		log.Printf("Body cannot be decoded: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
 		return
 	}
+	for idx, item := range batch {
+		if err := validateLinkID(item.CorrelationID); err != nil {
+			badRequest(c, fmt.Errorf("correlation_id: %w", err))
+			return
+		}
+		normalized, err := a.normalizeURL(item.OriginalURL)
+		if err != nil {
+			badRequest(c, fmt.Errorf("correlation_id %q: %w", item.CorrelationID, err))
+			return
+		}
+		if err := validateOptions(item.LinkOptions); err != nil {
+			badRequest(c, fmt.Errorf("correlation_id %q: %w", item.CorrelationID, err))
+			return
+		}
+		if !a.checkRules(c, item.Rules) || !a.checkDestinations(c, item.Destinations) {
+			return
+		}
+		if err := a.checkDomain(normalized); err != nil {
+			auditDenied(c, "shorten", normalized, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorRes{
+				Error: fmt.Sprintf("correlation_id %q: %v", item.CorrelationID, err),
+			})
+			return
+		}
+		if err := a.validateURL(c, normalized); err != nil {
+			badRequest(c, fmt.Errorf("correlation_id %q: %w", item.CorrelationID, err))
+			return
+		}
+		domain, err := a.shortDomain(item.Domain)
+		if err != nil {
+			badRequest(c, fmt.Errorf("correlation_id %q: %w", item.CorrelationID, err))
+			return
+		}
+		batch[idx].OriginalURL = normalized
+		// correlation_id служит ID ссылки, на дополнительном домене он получает префикс домена
+		batch[idx].CorrelationID = linkKey(domain, item.CorrelationID)
+	}
 
 	result, err := a.store.PutBatch(c, batch, userID)
+	if errors.Is(err, models.ErrIDConflict) {
+		c.AbortWithStatusJSON(http.StatusConflict, models.ErrorRes{Error: "correlation_id is already taken by another link"})
+		return
+	}
 	if err != nil {
 		log.Printf("Cant put batch: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -168,13 +583,14 @@ func (a *App) ShortenBatch(c *gin.Context) {
 	}
 
 	for idx, urlObj := range result {
-		resultURL, err := url.JoinPath(a.Config.RedirectBaseURL, urlObj.CorrelationID)
+		resultURL, err := a.shortURL(urlObj.ShortURL)
 		if err != nil {
 			log.Printf("URL cannot be joined: %v", err)
 			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		result[idx].ShortURL = resultURL
+		_, result[idx].CorrelationID = splitLinkKey(urlObj.CorrelationID)
 	}
 
 	res.WriteHeader(http.StatusCreated)
@@ -192,17 +608,24 @@ func (a *App) ShortURL(c *gin.Context) {
 	res := c.Writer
 	userID := c.GetString(auth.UserIDKey)
 
-	var originalURL string
+	var originalURL, password, workspaceID, domainName string
+	var tags, collections []string
+	var options models.LinkOptions
 
 	switch req.RequestURI {
 	case "/api/shorten":
 		var shorten models.ShortenReq
 		if err := json.NewDecoder(req.Body).Decode(&shorten); err != nil {
-			log.Printf("Body cannot be decoded: %v", err)
-			res.WriteHeader(http.StatusInternalServerError)
+			badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
 			return
 		}
 		originalURL = shorten.URL
+		password = shorten.Password
+		tags = shorten.Tags
+		collections = shorten.Collections
+		workspaceID = shorten.Workspace
+		domainName = shorten.Domain
+		options = shorten.LinkOptions
 	case "/":
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
@@ -211,20 +634,104 @@ func (a *App) ShortURL(c *gin.Context) {
 			return
 		}
 		originalURL = string(body)
+		// консольный клиент отправляет URL в поле url формы,
+		// без этого поля тело считается самим URL
+		if c.ContentType() == binding.MIMEPOSTForm {
+			if form, err := url.ParseQuery(originalURL); err == nil && form.Has("url") {
+				originalURL = form.Get("url")
+				password = form.Get(passwordFormField)
+			}
+		}
+	}
+
+	originalURL, err := a.normalizeURL(originalURL)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := validateOptions(options); err != nil {
+		badRequest(c, err)
+		return
+	}
+	domain, err := a.shortDomain(domainName)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if tags, err = normalizeLabels("tag", tags); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if collections, err = normalizeLabels("collection", collections); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := a.checkDomain(originalURL); err != nil {
+		auditDenied(c, "shorten", originalURL, err)
+		c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorRes{Error: err.Error()})
+		return
+	}
+	if err := a.validateURL(c, originalURL); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !a.checkRules(c, options.Rules) || !a.checkDestinations(c, options.Destinations) {
+		return
+	}
+	if workspaceID != "" {
+		role, err := a.store.GetWorkspaceRole(c, workspaceID, userID)
+		if err != nil {
+			log.Printf("Error getting workspace role: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !role.CanEdit() {
+			forbidden(c)
+			return
+		}
+	}
+	var passwordHash string
+	if password != "" {
+		if passwordHash, err = hashPassword(password); err != nil {
+			badRequest(c, err)
+			return
+		}
 	}
 
 	b := make([]byte, 4)
-	_, err := rand.Read(b)
+	_, err = rand.Read(b)
 	if err != nil {
 		log.Printf("Random string generator error: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	id := hex.EncodeToString(b)
-
-	id, err = a.store.Put(c, id, originalURL, userID)
+	id := linkKey(domain, hex.EncodeToString(b))
+
+	id, err = a.store.Put(c, models.Link{
+		ID:           id,
+		OriginalURL:  originalURL,
+		UserID:       userID,
+		Options:      options,
+		PasswordHash: passwordHash,
+		WorkspaceID:  workspaceID,
+	})
 	if err != nil {
-		if errors.Is(err, postgres.ErrDBInsertConflict) {
+		// существующая ссылка без запрошенных настроек не подменяет новую, поэтому ее адрес не возвращается
+		if errors.Is(err, models.ErrURLConflict) {
+			existing, getErr := a.store.Get(c, id)
+			if getErr != nil {
+				log.Printf("Error getting existing link: %v", getErr)
+				res.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if option := missingOption(existing, passwordHash, workspaceID, options); option != "" {
+				c.AbortWithStatusJSON(http.StatusConflict, models.ErrorRes{
+					Error: fmt.Sprintf("url is already shortened, %s can only be set on a new link", option),
+				})
+				return
+			}
+		}
+		if errors.Is(err, models.ErrURLConflict) {
 			res.WriteHeader(http.StatusConflict)
 		} else {
 			log.Printf("Error saving data: %v", err)
@@ -232,10 +739,16 @@ func (a *App) ShortURL(c *gin.Context) {
 			return
 		}
 	} else {
+		// метки и коллекции назначаются только новой ссылке: существующая может принадлежать другому пользователю
+		if err := a.labelLink(c, id, userID, tags, collections); err != nil {
+			log.Printf("Error labeling link: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 		res.WriteHeader(http.StatusCreated)
 	}
 
-	resultURL, err := url.JoinPath(a.Config.RedirectBaseURL, id)
+	resultURL, err := a.shortURL(id)
 	if err != nil {
 		log.Printf("URL cannot be joined: %v", err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -266,6 +779,88 @@ func (a *App) ShortURL(c *gin.Context) {
 	}
 }
 
+// missingOption запрошенная при сокращении настройка, которой нет у существующей ссылки на тот же URL.
+// Пустая строка - существующая ссылка соответствует запросу.
+func missingOption(existing models.Link, passwordHash string, workspaceID string, options models.LinkOptions) string {
+	switch {
+	case passwordHash != "":
+		return "a password"
+	case workspaceID != "" && workspaceID != existing.WorkspaceID:
+		return "a workspace"
+	case !options.Equal(models.LinkOptions{}) && !options.Equal(existing.Options):
+		return "link options"
+	}
+	return ""
+}
+
+// normalizeURL проверка и нормализация исходного URL перед сохранением
+func (a *App) normalizeURL(raw string) (string, error) {
+	return urlnorm.Normalize(raw, urlnorm.Options{SortQuery: a.Config.Get().SortQueryParams})
+}
+
+// validateOptions проверка настроек ссылки, адреса перехода правил и вариантов проверяют
+// checkRules и checkDestinations. Вариантам без названия назначаются номера.
+func validateOptions(options models.LinkOptions) error {
+	if err := config.ValidateRedirectOptions(options.RedirectStatus, options.CacheControl, options.ReferrerPolicy, options.RobotsTag); err != nil {
+		return err
+	}
+	if err := rules.Validate(options.Rules); err != nil {
+		return err
+	}
+	if err := rules.ValidateForward(options); err != nil {
+		return err
+	}
+	if options.Sticky && len(options.Destinations) == 0 {
+		return errors.New("sticky requires destinations")
+	}
+	if options.Expired(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return rules.NormalizeDestinations(options.Destinations)
+}
+
+// checkDomain проверка домена исходного URL по политике доменов
+func (a *App) checkDomain(originalURL string) error {
+	if a.Domains == nil {
+		return nil
+	}
+	var host string
+	if u, err := url.Parse(originalURL); err == nil {
+		host = u.Hostname()
+	}
+	return a.Domains.Check(host)
+}
+
+// validateURL проверка исходного URL подключенным валидатором
+func (a *App) validateURL(c *gin.Context, originalURL string) error {
+	if a.Validator == nil {
+		return nil
+	}
+	return a.Validator.Validate(c.Request.Context(), originalURL)
+}
+
+// auditDenied запись в журнал аудита об отклоненном по политике доменов URL
+func auditDenied(c *gin.Context, action string, originalURL string, err error) {
+	log.Printf("AUDIT %s denied: user=%q ip=%s url=%q reason=%q",
+		action, c.GetString(auth.UserIDKey), c.ClientIP(), originalURL, err.Error())
+}
+
+// badRequest ответ 400 с описанием ошибки в JSON
+func badRequest(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, models.ErrorRes{Error: err.Error()})
+}
+
+// Stats статистика сервиса: количество сокращенных URL и пользователей
+func (a *App) Stats(c *gin.Context) {
+	stats, err := a.store.GetStats(c)
+	if err != nil {
+		log.Printf("Error getting stats: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
 // Ping метод по проверке соединения с БД
 func (a *App) Ping(c *gin.Context) {
 	if err := a.store.Ping(); err != nil {
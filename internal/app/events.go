@@ -0,0 +1,176 @@
+// Модуль потока изменений ссылок для внутренних сервисов
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventsDefaultLimit количество событий в ответе по умолчанию
+	eventsDefaultLimit = 100
+	// eventsMaxLimit максимальное количество событий в ответе
+	eventsMaxLimit = 1000
+	// eventsDefaultWait время ожидания новых событий по умолчанию
+	eventsDefaultWait = 25 * time.Second
+	// eventsMaxWait максимальное время ожидания новых событий
+	eventsMaxWait = 60 * time.Second
+	// eventsPollInterval период опроса журнала изменений при ожидании событий
+	eventsPollInterval = 200 * time.Millisecond
+	// eventsHeartbeat период отправки комментария в поток событий, чтобы прокси не закрывали соединение
+	eventsHeartbeat = 15 * time.Second
+)
+
+// eventsQuery разбор параметров запроса событий. Курсор берется из параметра since,
+// а при его отсутствии из заголовка Last-Event-ID, который браузер отправляет при переподключении.
+func eventsQuery(c *gin.Context) (since int64, limit int, wait time.Duration, err error) {
+	cursor := c.Query("since")
+	if cursor == "" {
+		cursor = c.GetHeader("Last-Event-ID")
+	}
+	if cursor != "" {
+		since, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || since < 0 {
+			return 0, 0, 0, errors.New("since must be a non-negative event ID")
+		}
+	}
+	limit = eventsDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > eventsMaxLimit {
+			return 0, 0, 0, fmt.Errorf("limit must be between 1 and %d", eventsMaxLimit)
+		}
+	}
+	wait = eventsDefaultWait
+	if value := c.Query("wait"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > eventsMaxWait {
+			return 0, 0, 0, fmt.Errorf("wait must be between 0 and %d seconds", int(eventsMaxWait.Seconds()))
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	return since, limit, wait, nil
+}
+
+// Events события изменения ссылок с ID больше курсора since в порядке их записи.
+// По умолчанию запрос ждет новых событий до wait секунд и возвращает их вместе с курсором
+// следующего запроса, если событий не появилось, возвращается 204. С заголовком
+// Accept: text/event-stream события передаются потоком SSE до отключения клиента,
+// ID события служит курсором для переподключения. Журнал хранит ограниченное количество
+// последних событий: если события после курсора уже удалены, возвращается 410, и клиент
+// должен заново синхронизироваться и читать журнал без since, с самого старого события.
+func (a *App) Events(c *gin.Context) {
+	since, limit, wait, err := eventsQuery(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		a.streamEvents(c, since, limit)
+		return
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(eventsPollInterval)
+	defer ticker.Stop()
+	for {
+		events, err := a.store.GetChanges(c, since, limit)
+		if errors.Is(err, models.ErrCursorExpired) {
+			cursorExpired(c)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting change events: %v", err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(events) > 0 {
+			c.JSON(http.StatusOK, models.ChangesRes{Events: events, Next: events[len(events)-1].ID})
+			return
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-deadline.C:
+			c.Writer.WriteHeader(http.StatusNoContent)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cursorExpired ответ 410 на курсор, события после которого уже удалены из журнала
+func cursorExpired(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusGone, models.ErrorRes{
+		Error: models.ErrCursorExpired.Error() + ", resync and read the events without since",
+	})
+}
+
+// streamEvents передача событий потоком SSE, начиная с событий после курсора since.
+// Курсор проверяется до начала потока, чтобы на устаревший курсор ответить 410.
+func (a *App) streamEvents(c *gin.Context, since int64, limit int) {
+	events, err := a.store.GetChanges(c, since, limit)
+	if errors.Is(err, models.ErrCursorExpired) {
+		cursorExpired(c)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting change events: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventsPollInterval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error encoding change event: %v", err)
+				return
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+			since = event.ID
+		}
+		if len(events) > 0 {
+			c.Writer.Flush()
+		}
+		// полный ответ означает, что в журнале могут быть еще события
+		if len(events) < limit {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			case <-ticker.C:
+			}
+		}
+		if events, err = a.store.GetChanges(c, since, limit); err != nil {
+			// клиент, отставший больше чем на размер журнала, переподключится с курсором и получит 410
+			log.Printf("Error getting change events: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Модуль меток и коллекций ссылок пользователя
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/EvgeniyBudaev/shortener/internal/auth"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxLabelLen максимальная длина названия метки или коллекции в символах
+	maxLabelLen = 64
+	// maxLabels максимальное количество меток ссылки и коллекций в одном запросе
+	maxLabels = 20
+)
+
+// normalizeLabel проверка названия метки или коллекции, пробелы по краям отбрасываются.
+// Название используется в пути запроса, поэтому не может содержать "/".
+func normalizeLabel(kind string, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%s name is empty", kind)
+	}
+	if utf8.RuneCountInString(name) > maxLabelLen {
+		return "", fmt.Errorf("%s name %q is longer than %d characters", kind, name, maxLabelLen)
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r == '/' || unicode.IsControl(r) }) {
+		return "", fmt.Errorf("%s name %q contains forbidden characters", kind, name)
+	}
+	return name, nil
+}
+
+// normalizeLabels проверка списка названий без учета повторов.
+// Метки не зависят от регистра и приводятся к нижнему, названия коллекций сохраняются как есть.
+func normalizeLabels(kind string, names []string) ([]string, error) {
+	result := make([]string, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		name, err := normalizeLabel(kind, name)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "tag" {
+			name = strings.ToLower(name)
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		result = append(result, name)
+	}
+	if len(result) > maxLabels {
+		return nil, fmt.Errorf("at most %d %ss are allowed", maxLabels, kind)
+	}
+	return result, nil
+}
+
+// urlFilter фильтр списка ссылок пользователя из параметров tag, collection и workspace
+func urlFilter(c *gin.Context) (models.URLFilter, error) {
+	filter := models.URLFilter{WorkspaceID: c.Query("workspace")}
+	tags, err := normalizeLabels("tag", c.QueryArray("tag"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Tags = tags
+	if collection, ok := c.GetQuery("collection"); ok {
+		if filter.Collection, err = normalizeLabel("collection", collection); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// labelLink назначение меток и коллекций только что созданной ссылке, недостающие коллекции создаются
+func (a *App) labelLink(c *gin.Context, id string, userID string, tags []string, collections []string) error {
+	if len(tags) > 0 {
+		if err := a.store.SetTags(c, id, userID, tags); err != nil {
+			return err
+		}
+	}
+	for _, name := range collections {
+		if err := a.store.CreateCollection(c, userID, name); err != nil && !errors.Is(err, models.ErrCollectionExists) {
+			return err
+		}
+		if err := a.store.UpdateCollection(c, userID, name, []string{id}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRecordTags замена меток ссылки владельцем или редактором ее рабочего пространства,
+// пустой список удаляет все метки
+func (a *App) SetRecordTags(c *gin.Context) {
+	var tags []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&tags); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	tags, err := normalizeLabels("tag", tags)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := a.store.SetTags(c, id, c.GetString(auth.UserIDKey), tags); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, models.ErrForbidden) {
+			forbidden(c)
+			return
+		}
+		log.Printf("Error setting tags: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// GetUserTags метки пользователя с количеством ссылок
+func (a *App) GetUserTags(c *gin.Context) {
+	tags, err := a.store.GetTags(c, c.GetString(auth.UserIDKey))
+	if err != nil {
+		log.Printf("Error getting tags: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(tags) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// GetUserCollections коллекции пользователя с количеством ссылок
+func (a *App) GetUserCollections(c *gin.Context) {
+	collections, err := a.store.GetCollections(c, c.GetString(auth.UserIDKey))
+	if err != nil {
+		log.Printf("Error getting collections: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(collections) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, collections)
+}
+
+// CreateCollection создание пустой коллекции
+func (a *App) CreateCollection(c *gin.Context) {
+	var req models.CollectionReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	name, err := normalizeLabel("collection", req.Name)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := a.store.CreateCollection(c, c.GetString(auth.UserIDKey), name); err != nil {
+		if errors.Is(err, models.ErrCollectionExists) {
+			c.AbortWithStatusJSON(http.StatusConflict, models.ErrorRes{Error: err.Error()})
+			return
+		}
+		log.Printf("Error creating collection: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, models.LabelRes{Name: name})
+}
+
+// UpdateCollection добавление и удаление ссылок коллекции, параметр domain задает домен ссылок
+func (a *App) UpdateCollection(c *gin.Context) {
+	var req models.CollectionUpdateReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	domain, err := a.shortDomain(c.Query("domain"))
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	for _, ids := range [][]string{req.Add, req.Remove} {
+		for idx, id := range ids {
+			ids[idx] = linkKey(domain, id)
+		}
+	}
+	err = a.store.UpdateCollection(c, c.GetString(auth.UserIDKey), c.Param("name"), req.Add, req.Remove)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("Error updating collection: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteCollection удаление коллекции без удаления входящих в нее ссылок
+func (a *App) DeleteCollection(c *gin.Context) {
+	if err := a.store.DeleteCollection(c, c.GetString(auth.UserIDKey), c.Param("name")); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting collection: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+// Модуль защиты ссылок паролем
+package app
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/EvgeniyBudaev/shortener/internal/auth"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PasswordHeader заголовок, в котором программный клиент передает пароль ссылки
+	PasswordHeader = "X-Link-Password"
+	// passwordFormField поле формы с паролем ссылки
+	passwordFormField = "password"
+	// maxPasswordLen bcrypt учитывает только первые 72 байта пароля
+	maxPasswordLen = 72
+)
+
+// passwordPage форма ввода пароля защищенной ссылки
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<p>This link is protected with a password.</p>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .CSRFToken}}<input type="hidden" name="{{.CSRFField}}" value="{{.CSRFToken}}">{{end}}
+<input type="password" name="{{.PasswordField}}" autocomplete="current-password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// hashPassword bcrypt-хэш пароля ссылки
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLen {
+		return "", fmt.Errorf("password must be at most %d bytes", maxPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword проверка пароля защищенной ссылки перед переходом.
+// Если переход не разрешен, ответ уже отправлен: форма ввода пароля или 429 после исчерпания попыток.
+func (a *App) checkPassword(c *gin.Context, link models.Link) bool {
+	password, ok := linkPassword(c)
+	if !ok {
+		a.passwordForm(c, "")
+		return false
+	}
+	if !a.allowPasswordAttempt(c, link.ID) {
+		return false
+	}
+	// bcrypt сравнивает хэши за постоянное время
+	if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
+		a.passwordForm(c, "Wrong password.")
+		return false
+	}
+	return true
+}
+
+// linkPassword пароль из заголовка или из отправленной формы
+func linkPassword(c *gin.Context) (string, bool) {
+	if values, ok := c.Request.Header[PasswordHeader]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	if c.Request.Method == http.MethodPost {
+		return c.GetPostForm(passwordFormField)
+	}
+	return "", false
+}
+
+// allowPasswordAttempt ограничение попыток ввода пароля ссылки для каждого IP клиента.
+// Попытки не привязываются к пользователю, так как новую куку можно получить на каждый запрос.
+// Общего для ссылки ограничения нет: его исчерпание одним клиентом закрывало бы ссылку для всех,
+// перебор с многих адресов замедляет bcrypt. При ошибке хранилища попытка разрешается,
+// как и в остальных ограничениях частоты.
+func (a *App) allowPasswordAttempt(c *gin.Context, id string) bool {
+	if a.Limiter == nil {
+		return true
+	}
+	limit, _ := ratelimit.ParseLimit(a.Config.Get().RateLimitPassword)
+	if limit.IsZero() {
+		return true
+	}
+	res, err := a.Limiter.Allow(c.Request.Context(), "password:"+id+":ip:"+c.ClientIP(), limit)
+	if err != nil {
+		log.Printf("Error checking password attempts: %v", err)
+		return true
+	}
+	if !res.Allowed {
+		log.Printf("AUDIT password attempts exceeded: link=%q ip=%s", id, c.ClientIP())
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// passwordForm ответ 401 с формой ввода пароля. Форма отправляется на адрес запроса, чтобы
+// перенесенные в адрес перехода часть пути и параметры запроса сохранились после ввода пароля.
+func (a *App) passwordForm(c *gin.Context, message string) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusUnauthorized)
+	err := passwordPage.Execute(c.Writer, map[string]string{
+		"Action":        c.Request.URL.RequestURI(),
+		"Error":         message,
+		"CSRFField":     auth.CSRFFormField,
+		"CSRFToken":     c.GetString(auth.CSRFTokenKey),
+		"PasswordField": passwordFormField,
+	})
+	if err != nil {
+		log.Printf("Error rendering password form: %v", err)
+	}
+	c.Abort()
+}
@@ -0,0 +1,97 @@
+// Модуль правил перехода по ссылке в зависимости от устройства, языка и параметров запроса
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/EvgeniyBudaev/shortener/internal/auth"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/rules"
+	"github.com/gin-gonic/gin"
+)
+
+// checkRules проверка адресов перехода правил теми же проверками, что и исходного URL.
+// Адреса нормализуются на месте; если правила не прошли проверку, ответ уже отправлен.
+func (a *App) checkRules(c *gin.Context, linkRules []models.RedirectRule) bool {
+	for i := range linkRules {
+		target, err := a.normalizeURL(linkRules[i].URL)
+		if err != nil {
+			badRequest(c, fmt.Errorf("rule %d: %w", i+1, err))
+			return false
+		}
+		if err := a.checkDomain(target); err != nil {
+			auditDenied(c, "rule", target, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorRes{Error: fmt.Sprintf("rule %d: %v", i+1, err)})
+			return false
+		}
+		if err := a.validateURL(c, target); err != nil {
+			badRequest(c, fmt.Errorf("rule %d: %w", i+1, err))
+			return false
+		}
+		linkRules[i].URL = target
+	}
+	return true
+}
+
+// GetRecordRules правила перехода ссылки, доступны владельцу и участникам ее рабочего пространства
+func (a *App) GetRecordRules(c *gin.Context) {
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	link, err := a.store.Get(c, id)
+	if err != nil {
+		log.Printf("Error getting original URL: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if link.OriginalURL == "" || link.Deleted || !a.canView(c, link, c.GetString(auth.UserIDKey)) {
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(link.Options.Rules) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, link.Options.Rules)
+}
+
+// SetRecordRules замена правил перехода ссылки, пустой список удаляет все правила
+func (a *App) SetRecordRules(c *gin.Context) {
+	var linkRules []models.RedirectRule
+	if err := json.NewDecoder(c.Request.Body).Decode(&linkRules); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	if err := rules.Validate(linkRules); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !a.checkRules(c, linkRules) {
+		return
+	}
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := a.store.SetRules(c, id, c.GetString(auth.UserIDKey), linkRules); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, models.ErrForbidden) {
+			forbidden(c)
+			return
+		}
+		log.Printf("Error setting redirect rules: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
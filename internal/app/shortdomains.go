@@ -0,0 +1,111 @@
+// Модуль доменов коротких ссылок
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLinkIDLen максимальная длина ID ссылки, заданного клиентом
+const maxLinkIDLen = 64
+
+// validateLinkID проверка ID ссылки, заданного клиентом: латинские буквы, цифры, - и _.
+// Без "/" ключ ссылки основного домена не совпадет с ключом дополнительного.
+func validateLinkID(id string) error {
+	if id == "" {
+		return errors.New("id is empty")
+	}
+	if len(id) > maxLinkIDLen {
+		return fmt.Errorf("id is longer than %d characters", maxLinkIDLen)
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("id %q may contain only latin letters, digits, - and _", id)
+		}
+	}
+	return nil
+}
+
+// linkKey ключ ссылки в хранилище. Ссылки основного домена хранятся под своим ID,
+// ссылки дополнительных доменов - под ID с префиксом домена, поэтому один ID может
+// существовать на разных доменах. ID не содержит "/" (см. validateLinkID), это исключает пересечения ключей.
+func linkKey(domain string, id string) string {
+	if domain == "" {
+		return id
+	}
+	return domain + "/" + id
+}
+
+// splitLinkKey домен и ID ссылки по ключу, для основного домена домен пустой
+func splitLinkKey(key string) (string, string) {
+	if domain, id, ok := strings.Cut(key, "/"); ok {
+		return domain, id
+	}
+	return "", key
+}
+
+// domainBaseURL базовый URL дополнительного домена коротких ссылок из конфигурации
+func (a *App) domainBaseURL(domain string) (string, bool) {
+	for _, base := range a.Config.Get().ShortDomains {
+		if u, err := url.Parse(base); err == nil && strings.EqualFold(u.Host, domain) {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// shortDomain домен коротких ссылок по названию из запроса: пустое название и домен
+// основного базового URL означают основной домен, остальные должны быть настроены
+func (a *App) shortDomain(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", nil
+	}
+	if u, err := url.Parse(a.Config.Get().RedirectBaseURL); err == nil && strings.EqualFold(u.Host, name) {
+		return "", nil
+	}
+	if _, ok := a.domainBaseURL(name); !ok {
+		return "", fmt.Errorf("short domain %q is not configured", name)
+	}
+	return name, nil
+}
+
+// requestDomain домен коротких ссылок по заголовку Host, для прочих хостов - основной домен
+func (a *App) requestDomain(c *gin.Context) string {
+	host := strings.ToLower(c.Request.Host)
+	if _, ok := a.domainBaseURL(host); ok {
+		return host
+	}
+	return ""
+}
+
+// paramKey ключ ссылки из ID в пути и домена из параметра domain
+func (a *App) paramKey(c *gin.Context) (string, error) {
+	domain, err := a.shortDomain(c.Query("domain"))
+	if err != nil {
+		return "", err
+	}
+	return linkKey(domain, c.Param("id")), nil
+}
+
+// shortURL короткий URL ссылки по ключу. Если домен убран из конфигурации,
+// адрес строится по схеме основного базового URL.
+func (a *App) shortURL(key string) (string, error) {
+	domain, id := splitLinkKey(key)
+	base := a.Config.Get().RedirectBaseURL
+	if domain != "" {
+		var ok bool
+		if base, ok = a.domainBaseURL(domain); !ok {
+			scheme := "https"
+			if u, err := url.Parse(a.Config.Get().RedirectBaseURL); err == nil {
+				scheme = u.Scheme
+			}
+			base = scheme + "://" + domain
+		}
+	}
+	return url.JoinPath(base, id)
+}
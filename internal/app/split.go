@@ -0,0 +1,159 @@
+// Модуль разделения трафика ссылки между вариантами адреса перехода
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+
+	"github.com/EvgeniyBudaev/shortener/internal/auth"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/rules"
+	"github.com/gin-gonic/gin"
+)
+
+// splitCookiePrefix префикс куки с выбранным посетителем вариантом, к нему добавляется ID ссылки
+const splitCookiePrefix = "split_"
+
+// splitCookieMaxAge срок хранения выбранного варианта в секундах
+const splitCookieMaxAge = 30 * 24 * 60 * 60
+
+// checkDestinations проверка адресов вариантов теми же проверками, что и исходного URL.
+// Адреса нормализуются на месте; если варианты не прошли проверку, ответ уже отправлен.
+func (a *App) checkDestinations(c *gin.Context, destinations []models.Destination) bool {
+	for i := range destinations {
+		target, err := a.normalizeURL(destinations[i].URL)
+		if err != nil {
+			badRequest(c, fmt.Errorf("destination %q: %w", destinations[i].ID, err))
+			return false
+		}
+		if err := a.checkDomain(target); err != nil {
+			auditDenied(c, "destination", target, err)
+			c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorRes{Error: fmt.Sprintf("destination %q: %v", destinations[i].ID, err)})
+			return false
+		}
+		if err := a.validateURL(c, target); err != nil {
+			badRequest(c, fmt.Errorf("destination %q: %w", destinations[i].ID, err))
+			return false
+		}
+		destinations[i].URL = target
+	}
+	return true
+}
+
+// pickDestination выбор варианта адреса перехода: вариант из куки посетителя, если он еще
+// активен, иначе случайный пропорционально весам. Для ссылки с закреплением выбор сохраняется в куки.
+func (a *App) pickDestination(c *gin.Context, link models.Link, slug string) (models.Destination, bool) {
+	destinations := link.Options.Destinations
+	total := rules.TotalWeight(destinations)
+	if total == 0 {
+		return models.Destination{}, false
+	}
+	name := splitCookiePrefix + slug
+	if link.Options.Sticky {
+		if id, err := c.Cookie(name); err == nil {
+			if d, ok := rules.Find(destinations, id); ok {
+				return d, true
+			}
+		}
+	}
+	d, ok := rules.Pick(destinations, rand.Intn(total))
+	if ok && link.Options.Sticky {
+		// куки привязана к хосту запроса, так как ссылки доступны на нескольких доменах
+		policy, err := auth.NewCookiePolicy(a.Config.Get())
+		if err != nil {
+			log.Printf("Error building cookie policy: %v", err)
+			return d, true
+		}
+		c.SetSameSite(policy.SameSite)
+		c.SetCookie(name, d.ID, splitCookieMaxAge, policy.Path, "", policy.Secure, true)
+	}
+	return d, ok
+}
+
+// destinationStats варианты ссылки с количеством переходов на каждый
+func (a *App) destinationStats(c *gin.Context, id string, destinations []models.Destination) ([]models.DestinationStats, error) {
+	if len(destinations) == 0 {
+		return nil, nil
+	}
+	clicks, err := a.store.GetDestinationClicks(c, id)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]models.DestinationStats, 0, len(destinations))
+	for _, d := range destinations {
+		stats = append(stats, models.DestinationStats{Destination: d, Clicks: clicks[d.ID]})
+	}
+	return stats, nil
+}
+
+// GetRecordDestinations варианты адреса перехода ссылки с количеством переходов,
+// доступны владельцу и участникам ее рабочего пространства
+func (a *App) GetRecordDestinations(c *gin.Context) {
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	link, err := a.store.Get(c, id)
+	if err != nil {
+		log.Printf("Error getting original URL: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if link.OriginalURL == "" || link.Deleted || !a.canView(c, link, c.GetString(auth.UserIDKey)) {
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(link.Options.Destinations) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	stats, err := a.destinationStats(c, id, link.Options.Destinations)
+	if err != nil {
+		log.Printf("Error getting destination clicks: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"destinations": stats, "sticky": link.Options.Sticky})
+}
+
+// SetRecordDestinations замена вариантов адреса перехода ссылки без изменения ее ID.
+// Вариант с нулевым весом приостановлен, пустой список отключает разделение трафика.
+// Счетчики переходов привязаны к ID вариантов и сохраняются при изменении весов.
+func (a *App) SetRecordDestinations(c *gin.Context) {
+	var req models.DestinationsReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	if err := rules.NormalizeDestinations(req.Destinations); err != nil {
+		badRequest(c, err)
+		return
+	}
+	if !a.checkDestinations(c, req.Destinations) {
+		return
+	}
+	id, err := a.paramKey(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := a.store.SetDestinations(c, id, c.GetString(auth.UserIDKey), req.Destinations, req.Sticky); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, models.ErrForbidden) {
+			forbidden(c)
+			return
+		}
+		log.Printf("Error setting destinations: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
@@ -2,60 +2,81 @@
 package app
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"log"
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
 // const Константы для метода по созданию TLS - сертификатов
 const (
-	// serialNumber Уникальный серийный номер сертификата
-	serialNumber = 1
-	// ip4GrayZone IP-адрес, разрешенный для сертификата (в данном случае используется 127.0.0.1)
-	ip4GrayZone = 127
+	// serialNumberBits Длина случайного серийного номера сертификата в битах
+	serialNumberBits = 128
 	// yearsGrant Срок действия сертификата в годах
 	yearsGrant = 1
-	// RSALen Длина приватного ключа RSA в битах
-	RSALen = 4096
+	// certReloadInterval Минимальный интервал между проверками файлов сертификата на изменение
+	certReloadInterval = time.Second * 10
 )
 
-// CreateCertificates - создание TLS - сертификатов
-func CreateCertificates() error {
+// CreateCertificates - создание самоподписанного TLS - сертификата на ключе ECDSA P-256.
+// Сертификат выписывается на переданные имена хостов, а также localhost, 127.0.0.1 и ::1.
+func CreateCertificates(certFilePath, keyFilePath string, hosts []string) error {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return fmt.Errorf("error generating serial number: %w", err)
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	subjectKeyID := sha1.Sum(publicKeyBytes)
+
+	now := time.Now()
 	// создаём шаблон сертификата
 	cert := &x509.Certificate{
-		// указываем уникальный номер сертификата
-		SerialNumber: big.NewInt(serialNumber),
+		SerialNumber: serialNumber,
 		// заполняем базовую информацию о владельце сертификата
 		Subject: pkix.Name{
 			Organization: []string{"Shortener"},
 			Country:      []string{"RU"},
 		},
-		// разрешаем использование сертификата для 127.0.0.1 и ::1
-		IPAddresses: []net.IP{net.IPv4(ip4GrayZone, 0, 0, 1), net.IPv6loopback},
-		// сертификат верен, начиная со времени создания
-		NotBefore: time.Now(),
-		// время жизни сертификата — 10 лет
-		NotAfter:     time.Now().AddDate(yearsGrant, 0, 0),
-		SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		// устанавливаем использование ключа для цифровой подписи,
-		// а также клиентской и серверной авторизации
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature,
-	}
-
-	// создаём новый приватный RSA-ключ длиной 4096 бит
-	// обратите внимание, что для генерации ключа и сертификата
-	// используется rand.Reader в качестве источника случайных данных
-	privateKey, err := rsa.GenerateKey(rand.Reader, RSALen)
-	if err != nil {
-		return err
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		// небольшой запас на расхождение часов клиента и сервера
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(yearsGrant, 0, 0),
+		SubjectKeyId: subjectKeyID[:],
+		// устанавливаем использование ключа для цифровой подписи и серверной авторизации
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if host == "" || host == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		} else {
+			cert.DNSNames = append(cert.DNSNames, host)
+		}
 	}
 
 	// создаём сертификат x.509
@@ -63,41 +84,43 @@ func CreateCertificates() error {
 	if err != nil {
 		return err
 	}
-
-	// кодируем сертификат и ключ в формате PEM, который
-	// используется для хранения и обмена криптографическими ключами
-	certFile, err := os.OpenFile("./certs/cert.pem", os.O_WRONLY|os.O_CREATE, 0644)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
 		return err
 	}
-	defer certFile.Close()
 
-	if err := pem.Encode(certFile, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certBytes,
-	}); err != nil {
+	// кодируем сертификат и ключ в формате PEM, ключ доступен только владельцу
+	if err := writePEMFile(certFilePath, "CERTIFICATE", certBytes, 0644); err != nil {
 		return fmt.Errorf("error creating cert file: %w", err)
 	}
+	if err := writePEMFile(keyFilePath, "PRIVATE KEY", keyBytes, 0600); err != nil {
+		return fmt.Errorf("error creating private key file: %w", err)
+	}
+
+	return nil
+}
 
-	rsaFile, err := os.OpenFile("./certs/private.pem", os.O_WRONLY|os.O_CREATE, 0644)
+// writePEMFile запись PEM-блока в файл, каталог создается при необходимости
+func writePEMFile(path string, blockType string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
-	defer rsaFile.Close()
-	if err := pem.Encode(rsaFile, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	}); err != nil {
-		return fmt.Errorf("error creating RSA private key: %w", err)
+	defer file.Close()
+	// права на уже существующий файл не меняются при открытии
+	if err := file.Chmod(perm); err != nil {
+		return err
 	}
-
-	return nil
+	return pem.Encode(file, &pem.Block{Type: blockType, Bytes: data})
 }
 
 // CheckIfCertificatesExist - функция для проверки наличия файлов сертификатов
-func CheckIfCertificatesExist(certFilePath, rsaFilePath string) (bool, error) {
+func CheckIfCertificatesExist(certFilePath, keyFilePath string) (bool, error) {
 	certExist := false
-	rsaExist := false
+	keyExist := false
 
 	// Проверяем наличие файла сертификата
 	_, err := os.Stat(certFilePath)
@@ -108,15 +131,158 @@ func CheckIfCertificatesExist(certFilePath, rsaFilePath string) (bool, error) {
 		return false, fmt.Errorf("error when checking the certificate file: %w", err)
 	}
 
-	// Проверяем наличие файла RSA-ключа
-	_, err = os.Stat(rsaFilePath)
+	// Проверяем наличие файла приватного ключа
+	_, err = os.Stat(keyFilePath)
 	if err == nil {
-		rsaExist = true
+		keyExist = true
 	} else if !os.IsNotExist(err) {
 		// Если возникла ошибка, отличная от "файл не существует", возвращаем ошибку
-		return false, fmt.Errorf("error when checking the RSA key file: %w", err)
+		return false, fmt.Errorf("error when checking the private key file: %w", err)
 	}
 
 	// Если оба файла существуют, возвращаем true, иначе false
-	return certExist && rsaExist, nil
+	return certExist && keyExist, nil
+}
+
+// CertReloader отдает текущий сертификат и перечитывает его с диска после ротации файлов
+type CertReloader struct {
+	certFilePath string
+	keyFilePath  string
+
+	mux       sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader конструктор, сертификат загружается сразу
+func NewCertReloader(certFilePath, keyFilePath string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFilePath: certFilePath,
+		keyFilePath:  keyFilePath,
+	}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime время последнего изменения файлов сертификата и ключа
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFilePath, r.keyFilePath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load загрузка пары сертификат - ключ
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFilePath, r.keyFilePath)
+	if err != nil {
+		return fmt.Errorf("error loading TLS key pair: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("error parsing TLS certificate: %w", err)
+		}
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// maybeReload перечитывает сертификат, если файлы изменились.
+// При ошибке продолжает использоваться предыдущий сертификат.
+func (r *CertReloader) maybeReload() {
+	r.mux.Lock()
+	if time.Since(r.checkedAt) < certReloadInterval {
+		r.mux.Unlock()
+		return
+	}
+	r.checkedAt = time.Now()
+	current := r.modTime
+	r.mux.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		log.Printf("Error checking TLS certificate files: %v", err)
+		return
+	}
+	if !modTime.After(current) {
+		return
+	}
+	if err := r.load(modTime); err != nil {
+		log.Printf("Error reloading TLS certificate, keeping the previous one: %v", err)
+		return
+	}
+	log.Printf("TLS certificate %s reloaded", r.certFilePath)
+}
+
+// GetCertificate реализация tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.maybeReload()
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.cert, nil
+}
+
+// LoadClientCAs загрузка набора корневых сертификатов для проверки клиентов
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// ParseClientAuth режим проверки клиентских сертификатов
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q", mode)
+	}
+}
+
+// NewTLSConfig конфигурация TLS с современными параметрами: TLS 1.2+, AEAD-шифры и ECDHE.
+// Если передан набор clientCAs, включается взаимная аутентификация клиентов.
+func NewTLSConfig(reloader *CertReloader, clientCAs *x509.CertPool, clientAuth tls.ClientAuthType) *tls.Config {
+	conf := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAs != nil {
+		conf.ClientCAs = clientCAs
+		conf.ClientAuth = clientAuth
+	}
+	return conf
 }
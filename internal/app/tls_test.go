@@ -0,0 +1,52 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "certs", "cert.pem")
+	keyPath := filepath.Join(dir, "certs", "private.pem")
+
+	require.NoError(t, CreateCertificates(certPath, keyPath, []string{"short.example", "10.0.0.1"}))
+
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reloader, err := NewCertReloader(certPath, keyPath)
+	require.NoError(t, err)
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	assert.Contains(t, cert.Leaf.DNSNames, "short.example")
+	assert.Equal(t, "10.0.0.1", cert.Leaf.IPAddresses[len(cert.Leaf.IPAddresses)-1].String())
+}
+
+func TestCertReloaderPicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "private.pem")
+	require.NoError(t, CreateCertificates(certPath, keyPath, nil))
+
+	reloader, err := NewCertReloader(certPath, keyPath)
+	require.NoError(t, err)
+	first, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	require.NoError(t, CreateCertificates(certPath, keyPath, nil))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, future, future))
+	reloader.checkedAt = time.Time{}
+
+	second, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Leaf.SerialNumber, second.Leaf.SerialNumber)
+}
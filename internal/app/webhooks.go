@@ -0,0 +1,193 @@
+// Модуль подписок пользователей на события их ссылок
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/auth"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhooks максимальное количество подписок пользователя
+const maxWebhooks = 10
+
+// webhookEvents события, на которые можно подписаться
+var webhookEvents = map[string]bool{
+	models.EventLinkCreated:        true,
+	models.EventLinkDeleted:        true,
+	models.EventLinkClickThreshold: true,
+	models.EventLinkExpired:        true,
+}
+
+// validateWebhook проверка событий подписки, повторяющиеся события удаляются
+func validateWebhook(req *models.WebhookReq) error {
+	if len(req.Events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	seen := make(map[string]bool, len(req.Events))
+	events := make([]string, 0, len(req.Events))
+	for _, event := range req.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("unknown event %q", event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	req.Events = events
+	if req.ClickThreshold < 0 {
+		return errors.New("click_threshold must be positive")
+	}
+	if seen[models.EventLinkClickThreshold] != (req.ClickThreshold > 0) {
+		return fmt.Errorf("click_threshold is required for %s and only for it", models.EventLinkClickThreshold)
+	}
+	return nil
+}
+
+// expireInterval период проверки сроков действия ссылок
+const expireInterval = time.Minute
+
+// ExpireLinks периодическая проверка сроков действия ссылок до отмены ctx. Хранилище отмечает ссылки
+// с истекшим сроком и ставит в очередь событие link.expired для подписок их владельцев.
+func (a *App) ExpireLinks(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := a.store.ExpireLinks(ctx, now); err != nil {
+				log.Printf("Error expiring links: %v", err)
+			}
+		}
+	}
+}
+
+// WebhookPayload тело запроса с событием: адрес короткой ссылки строится по ключу ссылки из события
+func (a *App) WebhookPayload(event models.WebhookEvent) ([]byte, error) {
+	shortURL, err := a.shortURL(event.LinkID)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(event.Payload(shortURL))
+}
+
+// CreateWebhook создание подписки на события ссылок пользователя. Секрет подписи
+// возвращается только в ответе на создание.
+func (a *App) CreateWebhook(c *gin.Context) {
+	var req models.WebhookReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	target, err := a.normalizeURL(req.URL)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	if err := validateWebhook(&req); err != nil {
+		badRequest(c, err)
+		return
+	}
+	userID := c.GetString(auth.UserIDKey)
+	webhooks, err := a.store.GetWebhooks(c, userID)
+	if err != nil {
+		log.Printf("Error getting webhooks: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(webhooks) >= maxWebhooks {
+		badRequest(c, fmt.Errorf("at most %d webhooks are allowed", maxWebhooks))
+		return
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		log.Printf("Random string generator error: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		log.Printf("Random string generator error: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	webhook := models.Webhook{
+		ID:             id,
+		URL:            target,
+		Events:         req.Events,
+		ClickThreshold: req.ClickThreshold,
+		CreatedAt:      time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := a.store.CreateWebhook(c, webhook, userID, secret); err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, models.WebhookRes{Webhook: webhook, Secret: secret})
+}
+
+// GetWebhooks подписки пользователя без секретов
+func (a *App) GetWebhooks(c *gin.Context) {
+	webhooks, err := a.store.GetWebhooks(c, c.GetString(auth.UserIDKey))
+	if err != nil {
+		log.Printf("Error getting webhooks: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(webhooks) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// DeleteWebhook удаление подписки вместе с журналом и неотправленными событиями
+func (a *App) DeleteWebhook(c *gin.Context) {
+	if err := a.store.DeleteWebhook(c, c.Param("wid"), c.GetString(auth.UserIDKey)); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting webhook: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries журнал последних доставок подписки, от новых к старым
+func (a *App) GetWebhookDeliveries(c *gin.Context) {
+	deliveries, err := a.store.GetDeliveries(c, c.Param("wid"), c.GetString(auth.UserIDKey))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("Error getting webhook deliveries: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(deliveries) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for idx, delivery := range deliveries {
+		if deliveries[idx].Payload, err = a.WebhookPayload(delivery.Data); err != nil {
+			log.Printf("Error encoding webhook event: %v", err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
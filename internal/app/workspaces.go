@@ -0,0 +1,241 @@
+// Модуль рабочих пространств: участники с ролями и приглашения
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/auth"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// inviteTTL срок действия приглашения в рабочее пространство
+const inviteTTL = 7 * 24 * time.Hour
+
+// randomHex случайная строка из n байт в шестнадцатеричном виде
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashInviteToken хэш токена приглашения, сам токен не хранится
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// workspaceRole роль текущего пользователя в рабочем пространстве из пути запроса.
+// Посторонним отвечает 404, чтобы не раскрывать существование рабочего пространства.
+func (a *App) workspaceRole(c *gin.Context) (models.Role, bool) {
+	role, err := a.store.GetWorkspaceRole(c, c.Param("wid"), c.GetString(auth.UserIDKey))
+	if err != nil {
+		log.Printf("Error getting workspace role: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+	if role == "" {
+		c.Writer.WriteHeader(http.StatusNotFound)
+		return "", false
+	}
+	return role, true
+}
+
+// canView пользователь создал ссылку или состоит в ее рабочем пространстве
+func (a *App) canView(c *gin.Context, link models.Link, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	if userID == link.UserID {
+		return true
+	}
+	if link.WorkspaceID == "" {
+		return false
+	}
+	role, err := a.store.GetWorkspaceRole(c, link.WorkspaceID, userID)
+	if err != nil {
+		log.Printf("Error getting workspace role: %v", err)
+		return false
+	}
+	return role != ""
+}
+
+// forbidden ответ 403 на действие, недоступное роли пользователя
+func forbidden(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorRes{Error: models.ErrForbidden.Error()})
+}
+
+// CreateWorkspace создание рабочего пространства, создатель становится владельцем
+func (a *App) CreateWorkspace(c *gin.Context) {
+	var req models.WorkspaceReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	name, err := normalizeLabel("workspace", req.Name)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		log.Printf("Random string generator error: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	workspace := models.Workspace{ID: id, Name: name, Role: models.RoleOwner}
+	if err := a.store.CreateWorkspace(c, workspace, c.GetString(auth.UserIDKey)); err != nil {
+		log.Printf("Error creating workspace: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, workspace)
+}
+
+// GetWorkspaces рабочие пространства пользователя с его ролями
+func (a *App) GetWorkspaces(c *gin.Context) {
+	workspaces, err := a.store.GetWorkspaces(c, c.GetString(auth.UserIDKey))
+	if err != nil {
+		log.Printf("Error getting workspaces: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(workspaces) == 0 {
+		c.Writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, workspaces)
+}
+
+// GetWorkspaceMembers участники рабочего пространства, видны любому участнику
+func (a *App) GetWorkspaceMembers(c *gin.Context) {
+	if _, ok := a.workspaceRole(c); !ok {
+		return
+	}
+	members, err := a.store.GetWorkspaceMembers(c, c.Param("wid"))
+	if err != nil {
+		log.Printf("Error getting workspace members: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// SetWorkspaceMember изменение роли участника владельцем
+func (a *App) SetWorkspaceMember(c *gin.Context) {
+	var req models.RoleReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	if !req.Role.Valid() {
+		badRequest(c, fmt.Errorf("unknown role %q", req.Role))
+		return
+	}
+	role, ok := a.workspaceRole(c)
+	if !ok {
+		return
+	}
+	if role != models.RoleOwner {
+		forbidden(c)
+		return
+	}
+	a.setWorkspaceMember(c, req.Role)
+}
+
+// RemoveWorkspaceMember исключение участника владельцем или выход из рабочего пространства
+func (a *App) RemoveWorkspaceMember(c *gin.Context) {
+	role, ok := a.workspaceRole(c)
+	if !ok {
+		return
+	}
+	if role != models.RoleOwner && c.Param("uid") != c.GetString(auth.UserIDKey) {
+		forbidden(c)
+		return
+	}
+	a.setWorkspaceMember(c, "")
+}
+
+// setWorkspaceMember изменение участника из пути запроса, пустая роль исключает его
+func (a *App) setWorkspaceMember(c *gin.Context, role models.Role) {
+	err := a.store.SetWorkspaceMember(c, c.Param("wid"), c.Param("uid"), role)
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.Writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, models.ErrLastOwner):
+		c.AbortWithStatusJSON(http.StatusConflict, models.ErrorRes{Error: err.Error()})
+	case err != nil:
+		log.Printf("Error updating workspace member: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+	default:
+		c.Writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateInvite создание одноразового приглашения владельцем. Токен возвращается только в этом ответе.
+func (a *App) CreateInvite(c *gin.Context) {
+	var req models.RoleReq
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		badRequest(c, fmt.Errorf("body cannot be decoded: %w", err))
+		return
+	}
+	if !req.Role.Valid() {
+		badRequest(c, fmt.Errorf("unknown role %q", req.Role))
+		return
+	}
+	role, ok := a.workspaceRole(c)
+	if !ok {
+		return
+	}
+	if role != models.RoleOwner {
+		forbidden(c)
+		return
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		log.Printf("Random string generator error: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	invite := models.WorkspaceInvite{
+		TokenHash:   hashInviteToken(token),
+		WorkspaceID: c.Param("wid"),
+		Role:        req.Role,
+		CreatedBy:   c.GetString(auth.UserIDKey),
+		ExpiresAt:   time.Now().Add(inviteTTL).UTC().Truncate(time.Second),
+	}
+	if err := a.store.CreateInvite(c, invite); err != nil {
+		log.Printf("Error creating invite: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusCreated, models.InviteRes{Token: token, Role: invite.Role, ExpiresAt: invite.ExpiresAt})
+}
+
+// AcceptInvite вступление в рабочее пространство по приглашению
+func (a *App) AcceptInvite(c *gin.Context) {
+	workspace, err := a.store.AcceptInvite(c, hashInviteToken(c.Param("token")), c.GetString(auth.UserIDKey))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.Writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("Error accepting invite: %v", err)
+		c.Writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("AUDIT workspace invite accepted: workspace=%s user=%s role=%s",
+		workspace.ID, c.GetString(auth.UserIDKey), workspace.Role)
+	c.JSON(http.StatusOK, workspace)
+}
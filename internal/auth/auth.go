@@ -2,14 +2,18 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/EvgeniyBudaev/shortener/internal/config"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
 )
 
 // Claims структура клайма
@@ -21,28 +25,72 @@ type Claims struct {
 // tokenExp время жизни токена
 const tokenExp = time.Hour * 3
 
+// refreshWindow время после истечения токена, в течение которого его можно обменять на новый
+const refreshWindow = time.Hour * 24 * 30
+
+// refreshGrace время, в течение которого обмененный токен продолжает обмениваться на тот же новый.
+// Параллельные запросы со старой кукой (вкладки, загрузка ресурсов) не разлогинивают пользователя.
+const refreshGrace = time.Second * 30
+
 // cookieName название куки
 const cookieName = "jwt-token"
 
+// cookieMaxAge время жизни куки в секундах
+const cookieMaxAge = int(refreshWindow / time.Second)
+
 // UserIDKey ID пользователя в качестве ключа
 const UserIDKey = "userID"
 
+// tokenIDKey ключ контекста с идентификатором текущего токена
+const tokenIDKey = "tokenID"
+
+// tokenRefreshUntilKey ключ контекста с моментом, до которого текущий токен может быть обновлен
+const tokenRefreshUntilKey = "tokenRefreshUntil"
+
+// authMethodKey ключ контекста со способом аутентификации запроса
+const authMethodKey = "authMethod"
+
+// sessionExistedKey ключ контекста: запрос пришел с действующей сессионной кукой, а не получил новую
+const sessionExistedKey = "sessionExisted"
+
+// Способы аутентификации запроса
+const (
+	authMethodCookie     = "cookie"
+	authMethodAPIKey     = "api_key"
+	authMethodClientCert = "client_cert"
+)
+
 // ErrTokenNotValid ошибка - токен не валиден
 var ErrTokenNotValid = errors.New("token is not valid")
 
+// ErrTokenExpired ошибка - срок действия токена истек
+var ErrTokenExpired = errors.New("token is expired")
+
 // ErrNoUserInToken ошибка - в токене отсутствует информацию по пользователю
 var ErrNoUserInToken = errors.New("no user data in token")
 
-// BuildJWTString метод по созданию JWT токена в виде строки
-func BuildJWTString(seed string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// RevocationList хранилище отозванных токенов
+type RevocationList interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// BuildJWTString метод по созданию JWT токена в виде строки для нового пользователя
+func BuildJWTString(keyring *Keyring) (string, error) {
+	return buildJWTString(keyring, uuid.New().String())
+}
+
+// buildJWTString создание токена для указанного пользователя
+func buildJWTString(keyring *Keyring, userID string) (string, error) {
+	now := time.Now()
+	tokenString, err := keyring.Sign(Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokenExp)),
 		},
-		UserID: uuid.New().String(),
+		UserID: userID,
 	})
-
-	tokenString, err := token.SignedString([]byte(seed))
 	if err != nil {
 		return "", err
 	}
@@ -51,72 +99,294 @@ func BuildJWTString(seed string) (string, error) {
 }
 
 // GetUserID метод по получению пользователя по ID
-func GetUserID(tokenString string, seed string) (string, error) {
+func GetUserID(tokenString string, keyring *Keyring) (string, error) {
+	claims, err := parseClaims(tokenString, keyring)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// parseClaims разбор токена. Для токена с валидной подписью, но истекшим сроком действия
+// возвращаются клаймы вместе с ошибкой ErrTokenExpired.
+func parseClaims(tokenString string, keyring *Keyring) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(seed), nil
-		})
+	_, err := keyring.Parse(tokenString, claims)
 	if err != nil {
-		if !token.Valid {
-			return "", ErrTokenNotValid
-		} else {
-			return "", errors.New("parsing error")
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired {
+			if claims.UserID == "" {
+				return nil, ErrNoUserInToken
+			}
+			return claims, ErrTokenExpired
 		}
+		return nil, ErrTokenNotValid
 	}
 
 	if claims.UserID == "" {
-		return "", ErrNoUserInToken
+		return nil, ErrNoUserInToken
+	}
+	// токен без срока действия не выпускается сервисом и не может быть обновлен
+	if claims.ExpiresAt == nil {
+		return nil, ErrTokenNotValid
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
 
-// AuthMiddleware метод для установки куки и ID пользователя
-func AuthMiddleware(seed string) gin.HandlerFunc {
+// ClientKey ключ клиента для ограничения частоты запросов: пользователь, если он
+// аутентифицирован сертификатом или ключом API, иначе IP-адрес. Пользователь с кукой
+// анонимный: новую куку можно получить любым запросом без нее, поэтому такие запросы считаются по IP.
+func ClientKey(c *gin.Context) string {
+	switch c.GetString(authMethodKey) {
+	case authMethodAPIKey, authMethodClientCert:
+		if userID := c.GetString(UserIDKey); userID != "" {
+			return "user:" + userID
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// Authenticator выдает, обновляет и отзывает токены пользователей
+type Authenticator struct {
+	keyring *Keyring
+	revoked RevocationList
+	cookies CookiePolicy
+	csrf    bool
+	// apiKeys пользователи по хэшу ключа API
+	apiKeys map[string]string
+	// clientCertIDs пользователи по имени субъекта клиентского сертификата
+	clientCertIDs map[string]string
+	// conf действующая конфигурация, из нее на каждый запрос берутся перезагружаемые параметры
+	conf    *config.Holder
+	devMode bool
+
+	// reissued новые токены по ID обмененных, хранятся refreshGrace
+	reissued    map[string]reissuedToken
+	reissuedMux sync.Mutex
+	// refreshing объединяет одновременные обмены одного токена
+	refreshing singleflight.Group
+}
+
+// reissuedToken токен, выданный при обмене истекшего
+type reissuedToken struct {
+	token  string
+	claims *Claims
+	until  time.Time
+}
+
+// NewAuthenticator конструктор
+func NewAuthenticator(keyring *Keyring, revoked RevocationList, holder *config.Holder) (*Authenticator, error) {
+	conf := holder.Get()
+	cookies, err := NewCookiePolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := parseAPIKeys(conf.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	clientCertIDs, err := parseClientCertIDs(conf.ClientCertIDs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseTrustedSubnet(conf.TrustedSubnet); err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		keyring:       keyring,
+		revoked:       revoked,
+		cookies:       cookies,
+		csrf:          conf.EnableCSRF,
+		apiKeys:       apiKeys,
+		clientCertIDs: clientCertIDs,
+		conf:          holder,
+		devMode:       conf.DevMode,
+		reissued:      make(map[string]reissuedToken),
+	}, nil
+}
+
+// AuthMiddleware метод для установки куки и ID пользователя.
+// Клиенты с проверенным сертификатом или ключом API аутентифицируются без куки.
+// Истекший токен с валидной подписью обменивается на новый с тем же пользователем,
+// отозванный токен отклоняется.
+func (a *Authenticator) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cookie, err := c.Cookie(cookieName)
-		if err != nil {
-			if errors.Is(err, http.ErrNoCookie) {
-				token, err := BuildJWTString(seed)
-				if err != nil {
-					log.Printf("Error building JWT string: %v", err)
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				c.SetCookie(cookieName, token, 3600*24*30, "", "", false, true)
-				cookie = token
-			} else {
-				log.Printf("Error reading cookie[%v]: %v", cookieName, err)
-				c.Writer.WriteHeader(http.StatusInternalServerError)
+		if userID, ok := a.clientCertIdentity(c.Request); ok {
+			c.Set(UserIDKey, userID)
+			c.Set(authMethodKey, authMethodClientCert)
+			c.Next()
+			return
+		}
+
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			userID, ok := a.apiKeys[hashAPIKey(key)]
+			if !ok {
+				c.AbortWithStatus(http.StatusUnauthorized)
 				return
 			}
+			c.Set(UserIDKey, userID)
+			c.Set(authMethodKey, authMethodAPIKey)
+			c.Next()
+			return
 		}
 
-		userID, err := GetUserID(cookie, seed)
+		cookie, err := c.Cookie(cookieName)
+		if err != nil && !errors.Is(err, http.ErrNoCookie) {
+			log.Printf("Error reading cookie[%v]: %v", cookieName, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		var claims *Claims
+		if cookie != "" {
+			claims, err = parseClaims(cookie, a.keyring)
+		}
+		existed := cookie != ""
+		switch {
+		case cookie == "" || errors.Is(err, ErrTokenNotValid):
+			existed = false
+			claims, err = a.issue(c, uuid.New().String())
+		case errors.Is(err, ErrNoUserInToken):
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrTokenExpired):
+			claims, err = a.refresh(c, claims)
+		default:
+			err = a.checkRevoked(c, claims)
+		}
 		if err != nil {
-			if errors.Is(err, ErrNoUserInToken) {
-				c.Writer.WriteHeader(http.StatusUnauthorized)
-				return
-			}
 			if errors.Is(err, ErrTokenNotValid) {
-				token, err := BuildJWTString(seed)
-				if err != nil {
-					log.Printf("Error building JWT string: %v", err)
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				userID, err = GetUserID(token, seed)
-				if err != nil {
-					log.Printf("Revalidate error user id from renewed token: %v", err)
-					c.Writer.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				c.SetCookie(cookieName, token, 3600*24*30, "", "", false, true)
+				a.cookies.set(c, cookieName, "", -1, true)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
 			}
+			log.Printf("Error authenticating request: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
 		}
 
-		c.Set(UserIDKey, userID)
+		c.Set(UserIDKey, claims.UserID)
+		c.Set(authMethodKey, authMethodCookie)
+		c.Set(sessionExistedKey, existed)
+		c.Set(tokenIDKey, claims.ID)
+		c.Set(tokenRefreshUntilKey, claims.ExpiresAt.Add(refreshWindow))
 		c.Next()
 	}
 }
+
+// Logout отзыв текущего токена и удаление куки
+func (a *Authenticator) Logout(c *gin.Context) {
+	if tokenID := c.GetString(tokenIDKey); tokenID != "" {
+		if err := a.revoked.RevokeToken(c, tokenID, c.GetTime(tokenRefreshUntilKey)); err != nil {
+			log.Printf("Error revoking token: %v", err)
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	a.cookies.set(c, cookieName, "", -1, true)
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// checkRevoked проверка, что токен не был отозван
+func (a *Authenticator) checkRevoked(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" {
+		return nil
+	}
+	revoked, err := a.revoked.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenNotValid
+	}
+	return nil
+}
+
+// refresh обмен истекшего токена на новый для того же пользователя.
+// Старый токен отзывается, чтобы его нельзя было обменять повторно; в течение refreshGrace
+// запросы с ним получают тот же новый токен. Одновременные обмены одного токена выполняются
+// один раз, остальные запросы получают его результат.
+func (a *Authenticator) refresh(c *gin.Context, expired *Claims) (*Claims, error) {
+	refreshUntil := expired.ExpiresAt.Add(refreshWindow)
+	if expired.ID == "" || time.Now().After(refreshUntil) {
+		return a.issue(c, uuid.New().String())
+	}
+	// обмен не прерывается отменой запроса, начавшего его: результат ждут и другие запросы
+	ctx := context.WithoutCancel(c)
+	result, err, _ := a.refreshing.Do(expired.ID, func() (any, error) {
+		if reissued, ok := a.lookupReissued(expired.ID); ok {
+			return reissued, nil
+		}
+		if err := a.checkRevoked(ctx, expired); err != nil {
+			return nil, err
+		}
+		if err := a.revoked.RevokeToken(ctx, expired.ID, refreshUntil); err != nil {
+			return nil, err
+		}
+		token, claims, err := a.sign(expired.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return a.rememberReissued(expired.ID, token, claims), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	reissued := result.(reissuedToken)
+	// выданный ранее новый токен мог быть отозван выходом
+	if err := a.checkRevoked(c, reissued.claims); err != nil {
+		return nil, err
+	}
+	a.cookies.set(c, cookieName, reissued.token, cookieMaxAge, true)
+	return reissued.claims, nil
+}
+
+// lookupReissued новый токен, выданный при обмене токена с ID tokenID не ранее refreshGrace назад
+func (a *Authenticator) lookupReissued(tokenID string) (reissuedToken, bool) {
+	a.reissuedMux.Lock()
+	defer a.reissuedMux.Unlock()
+	reissued, ok := a.reissued[tokenID]
+	if !ok || time.Now().After(reissued.until) {
+		return reissuedToken{}, false
+	}
+	return reissued, true
+}
+
+// rememberReissued сохранение нового токена для обмененного, заодно удаляет устаревшие записи
+func (a *Authenticator) rememberReissued(tokenID string, token string, claims *Claims) reissuedToken {
+	a.reissuedMux.Lock()
+	defer a.reissuedMux.Unlock()
+	now := time.Now()
+	for id, reissued := range a.reissued {
+		if now.After(reissued.until) {
+			delete(a.reissued, id)
+		}
+	}
+	reissued := reissuedToken{token: token, claims: claims, until: now.Add(refreshGrace)}
+	a.reissued[tokenID] = reissued
+	return reissued
+}
+
+// issue выпуск нового токена и установка куки
+func (a *Authenticator) issue(c *gin.Context, userID string) (*Claims, error) {
+	token, claims, err := a.sign(userID)
+	if err != nil {
+		return nil, err
+	}
+	a.cookies.set(c, cookieName, token, cookieMaxAge, true)
+	return claims, nil
+}
+
+// sign выпуск нового токена для пользователя
+func (a *Authenticator) sign(userID string) (string, *Claims, error) {
+	token, err := buildJWTString(a.keyring, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	claims, err := parseClaims(token, a.keyring)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/config"
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/store/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter(t *testing.T) (*gin.Engine, *Keyring) {
+	storage, err := memory.NewMemoryStorage(make(map[string]models.URLRecordMemory))
+	require.NoError(t, err)
+	return newTestRouterWithRevocations(t, storage)
+}
+
+func newTestRouterWithRevocations(t *testing.T, revoked RevocationList) (*gin.Engine, *Keyring) {
+	gin.SetMode(gin.TestMode)
+	keyring, err := NewKeyring(NewHMACKey("test", "secret"))
+	require.NoError(t, err)
+
+	authenticator, err := NewAuthenticator(keyring, revoked, config.NewHolder(&config.ServerConfig{
+		EnableCSRF: true,
+		APIKeys:    []string{"secret-key=service-user"},
+	}))
+	require.NoError(t, err)
+	r := gin.New()
+	r.Use(authenticator.AuthMiddleware())
+	r.Use(authenticator.CSRFMiddleware())
+	r.GET("/whoami", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(UserIDKey))
+	})
+	r.GET("/client-key", func(c *gin.Context) {
+		c.String(http.StatusOK, ClientKey(c))
+	})
+	r.POST("/logout", authenticator.Logout)
+	r.POST("/form", func(c *gin.Context) {
+		c.String(http.StatusOK, c.PostForm("value"))
+	})
+	return r, keyring
+}
+
+func doRequest(r *gin.Engine, method string, token string, headers ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, map[string]string{
+		http.MethodGet:  "/whoami",
+		http.MethodPost: "/logout",
+	}[method], nil)
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+		if headers[i] == CSRFHeader {
+			req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: headers[i+1]})
+		}
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func responseToken(w *httptest.ResponseRecorder) string {
+	return responseCookie(w, cookieName)
+}
+
+func TestAuthMiddlewareRefreshesExpiredToken(t *testing.T) {
+	r, keyring := newTestRouter(t)
+	expired, err := keyring.Sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "expired-token",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+		UserID: "user-1",
+	})
+	require.NoError(t, err)
+
+	w := doRequest(r, http.MethodGet, expired)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user-1", w.Body.String())
+	refreshed := responseToken(w)
+	assert.NotEmpty(t, refreshed)
+
+	// запрос со старой кукой после обмена получает тот же новый токен
+	w = doRequest(r, http.MethodGet, expired)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, refreshed, responseToken(w))
+
+	w = doRequest(r, http.MethodGet, refreshed)
+	assert.Equal(t, "user-1", w.Body.String())
+
+	// после выхода старый токен не обменивается
+	w = doRequest(r, http.MethodPost, refreshed, CSRFHeader, "csrf")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(r, http.MethodGet, expired)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// slowRevocations список отзыва с задержкой записи, чтобы одновременные обмены пересекались
+type slowRevocations struct {
+	*memory.MemoryStorage
+}
+
+func (s slowRevocations) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	time.Sleep(20 * time.Millisecond)
+	return s.MemoryStorage.RevokeToken(ctx, tokenID, expiresAt)
+}
+
+func TestAuthMiddlewareRefreshesTokenOnceForParallelRequests(t *testing.T) {
+	storage, err := memory.NewMemoryStorage(make(map[string]models.URLRecordMemory))
+	require.NoError(t, err)
+	r, keyring := newTestRouterWithRevocations(t, slowRevocations{storage})
+	expired, err := keyring.Sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "parallel-token",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+		UserID: "user-1",
+	})
+	require.NoError(t, err)
+
+	const requests = 16
+	start := make(chan struct{})
+	responses := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			responses[i] = doRequest(r, http.MethodGet, expired)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	refreshed := responseToken(responses[0])
+	require.NotEmpty(t, refreshed)
+	for _, w := range responses {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "user-1", w.Body.String())
+		assert.Equal(t, refreshed, responseToken(w))
+	}
+}
+
+func TestAuthMiddlewareRejectsTokenWithoutExpiry(t *testing.T) {
+	r, keyring := newTestRouter(t)
+	token, err := keyring.Sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ID: "no-exp"},
+		UserID:           "user-1",
+	})
+	require.NoError(t, err)
+
+	w := doRequest(r, http.MethodGet, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, "user-1", w.Body.String())
+}
+
+func TestAuthMiddlewareRejectsRevokedToken(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	w := doRequest(r, http.MethodGet, "")
+	token := responseToken(w)
+	userID := w.Body.String()
+	require.NotEmpty(t, token)
+
+	w = doRequest(r, http.MethodGet, token)
+	assert.Equal(t, userID, w.Body.String())
+
+	w = doRequest(r, http.MethodPost, token, CSRFHeader, "csrf")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(r, http.MethodGet, token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	w := doRequest(r, http.MethodGet, "")
+	token := responseToken(w)
+	csrf := responseCookie(w, csrfCookieName)
+	require.NotEmpty(t, csrf)
+
+	w = doRequest(r, http.MethodPost, token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = doRequest(r, http.MethodPost, token, CSRFHeader, csrf)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// HTML-форма передает токен в поле, тело остается доступным обработчику;
+	// после выхода нужна новая сессия
+	w = doRequest(r, http.MethodGet, "")
+	token = responseToken(w)
+	csrf = responseCookie(w, csrfCookieName)
+	form := url.Values{CSRFFormField: {csrf}, "value": {"submitted"}}
+	req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: csrf})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "submitted", w.Body.String())
+}
+
+func TestAPIKeyBypassesCSRF(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	w := doRequest(r, http.MethodGet, "", APIKeyHeader, "secret-key")
+	assert.Equal(t, "service-user", w.Body.String())
+	assert.Empty(t, responseToken(w))
+
+	w = doRequest(r, http.MethodPost, "", APIKeyHeader, "secret-key")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(r, http.MethodGet, "", APIKeyHeader, "wrong-key")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestClientKey(t *testing.T) {
+	r, _ := newTestRouter(t)
+	clientKey := func(token string, headers ...string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/client-key", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	// новая и уже выданная кука не дают отдельного лимита: пользователя с кукой может создать любой запрос
+	assert.Equal(t, "ip:192.0.2.1", clientKey(""))
+	token := responseToken(doRequest(r, http.MethodGet, ""))
+	require.NotEmpty(t, token)
+	assert.Equal(t, "ip:192.0.2.1", clientKey(token))
+
+	assert.Equal(t, "user:service-user", clientKey("", APIKeyHeader, "secret-key"))
+}
+
+func TestRequireInternal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keyring, err := NewKeyring(NewHMACKey("test", "secret"))
+	require.NoError(t, err)
+	storage, err := memory.NewMemoryStorage(make(map[string]models.URLRecordMemory))
+	require.NoError(t, err)
+	holder := config.NewHolder(&config.ServerConfig{
+		ClientCertIDs: []string{"billing=billing-service"},
+		TrustedSubnet: "10.0.0.0/8",
+	})
+	authenticator, err := NewAuthenticator(keyring, storage, holder)
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(authenticator.AuthMiddleware())
+	r.GET("/internal", authenticator.RequireInternal(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(UserIDKey))
+	})
+
+	withCert := func(cn string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/internal", nil)
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}},
+		}
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		status   int
+		identity string
+	}{
+		{name: "mapped certificate", req: withCert("billing"), status: http.StatusOK, identity: "billing-service"},
+		{name: "unmapped certificate", req: withCert("reports"), status: http.StatusOK, identity: "cert:reports"},
+		{name: "cookie client", req: httptest.NewRequest(http.MethodGet, "/internal", nil), status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.identity != "" {
+				assert.Equal(t, tt.identity, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("trusted subnet", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/internal", nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("reloaded trusted subnet", func(t *testing.T) {
+		next := *holder.Get()
+		next.TrustedSubnet = "192.168.0.0/16"
+		holder.Reload(&next)
+
+		req := httptest.NewRequest(http.MethodGet, "/internal", nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
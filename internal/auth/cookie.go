@@ -0,0 +1,172 @@
+// Модуль политики куки и защиты от CSRF.
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/EvgeniyBudaev/shortener/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// csrfCookieName название куки с CSRF-токеном
+const csrfCookieName = "csrf-token"
+
+// CSRFHeader заголовок, в котором клиент передает CSRF-токен
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFFormField поле HTML-формы с CSRF-токеном для форм, которые не могут передать заголовок
+const CSRFFormField = "csrf_token"
+
+// CSRFTokenKey ключ контекста с CSRF-токеном текущей сессии для встраивания в HTML-формы
+const CSRFTokenKey = "csrfToken"
+
+// APIKeyHeader заголовок с ключом API
+const APIKeyHeader = "X-API-Key"
+
+// csrfTokenLen длина CSRF-токена в байтах
+const csrfTokenLen = 32
+
+// CookiePolicy атрибуты устанавливаемых куки
+type CookiePolicy struct {
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+	Path     string
+}
+
+// NewCookiePolicy построение политики куки из конфигурации.
+// Атрибут Secure включается автоматически при работе по HTTPS.
+func NewCookiePolicy(conf *config.ServerConfig) (CookiePolicy, error) {
+	policy := CookiePolicy{
+		Secure: conf.CookieSecure || conf.EnableHTTPS,
+		Domain: conf.CookieDomain,
+		Path:   conf.CookiePath,
+	}
+	switch strings.ToLower(conf.CookieSameSite) {
+	case "":
+		policy.SameSite = http.SameSiteDefaultMode
+	case "lax":
+		policy.SameSite = http.SameSiteLaxMode
+	case "strict":
+		policy.SameSite = http.SameSiteStrictMode
+	case "none":
+		if !policy.Secure {
+			return CookiePolicy{}, errors.New("SameSite=None cookies require the Secure attribute")
+		}
+		policy.SameSite = http.SameSiteNoneMode
+	default:
+		return CookiePolicy{}, fmt.Errorf("unknown SameSite mode %q", conf.CookieSameSite)
+	}
+	return policy, nil
+}
+
+// set установка куки с атрибутами политики
+func (p CookiePolicy) set(c *gin.Context, name string, value string, maxAge int, httpOnly bool) {
+	c.SetSameSite(p.SameSite)
+	c.SetCookie(name, value, maxAge, p.Path, p.Domain, p.Secure, httpOnly)
+}
+
+// hashAPIKey хэш ключа API, по которому выполняется поиск, чтобы не сравнивать ключи напрямую
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKeys разбор ключей API вида key=userID
+func parseAPIKeys(entries []string) (map[string]string, error) {
+	keys := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		key, userID, ok := strings.Cut(entry, "=")
+		if !ok || key == "" || userID == "" {
+			return nil, errors.New("API key must be in form key=userID")
+		}
+		keys[hashAPIKey(key)] = userID
+	}
+	return keys, nil
+}
+
+// isSafeMethod метод запроса не изменяет состояние
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CSRFMiddleware проверка CSRF-токена по схеме double-submit.
+// Клиент должен повторить значение куки csrf-token в заголовке X-CSRF-Token,
+// HTML-формы передают его в поле csrf_token.
+// Проверка применяется только к изменяющим запросам с уже существующей сессионной кукой,
+// клиенты с ключом API ее не проходят.
+func (a *Authenticator) CSRFMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.csrf || c.GetString(authMethodKey) != authMethodCookie {
+			c.Next()
+			return
+		}
+
+		token, err := c.Cookie(csrfCookieName)
+		if err != nil || token == "" {
+			token, err = newCSRFToken()
+			if err != nil {
+				log.Printf("Error generating CSRF token: %v", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			a.cookies.set(c, csrfCookieName, token, cookieMaxAge, false)
+		}
+		c.Set(CSRFTokenKey, token)
+
+		if !isSafeMethod(c.Request.Method) && c.GetBool(sessionExistedKey) {
+			header := c.GetHeader(CSRFHeader)
+			if header == "" {
+				header = csrfFormToken(c)
+			}
+			if header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// csrfFormToken CSRF-токен из поля формы. Тело запроса восстанавливается,
+// чтобы обработчик мог прочитать его повторно.
+func csrfFormToken(c *gin.Context) string {
+	if c.ContentType() != binding.MIMEPOSTForm {
+		return ""
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return form.Get(CSRFFormField)
+}
+
+// newCSRFToken генерация случайного CSRF-токена
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
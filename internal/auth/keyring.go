@@ -0,0 +1,264 @@
+// Модуль связки ключей для подписи и проверки JWT.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/EvgeniyBudaev/shortener/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// seedKeyID идентификатор ключа, построенного из секрета Seed
+const seedKeyID = "seed"
+
+// ErrDefaultSeed ошибка - используется встроенный секрет вне режима разработки
+var ErrDefaultSeed = errors.New("refusing to use the built-in default seed outside dev mode")
+
+// ErrUnknownKey ошибка - токен подписан неизвестным ключом
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key ключ подписи или проверки JWT
+type Key struct {
+	// ID идентификатор ключа, передается в заголовке kid
+	ID string
+	// Method алгоритм подписи
+	Method jwt.SigningMethod
+	// signKey ключ подписи, nil для ключей только на проверку
+	signKey interface{}
+	// verifyKey ключ проверки подписи
+	verifyKey interface{}
+}
+
+// NewHMACKey создание симметричного ключа HS256
+func NewHMACKey(id string, secret string) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// LoadPEMKey загрузка ключа RSA или Ed25519 из PEM-файла.
+// Приватный ключ используется и для подписи, и для проверки, публичный - только для проверки.
+func LoadPEMKey(id string, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file %s: %w", path, err)
+	}
+	return ParsePEMKey(id, data)
+}
+
+// ParsePEMKey разбор ключа RSA или Ed25519 в формате PEM
+func ParsePEMKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing PEM key: %w", err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// Keyring связка ключей: один активный ключ подписи и набор принимаемых ключей проверки
+type Keyring struct {
+	signing *Key
+	// legacy ключ для токенов, выпущенных без заголовка kid
+	legacy *Key
+	keys   map[string]*Key
+}
+
+// NewKeyring конструктор связки ключей
+func NewKeyring(signing *Key, verify ...*Key) (*Keyring, error) {
+	if signing == nil || signing.signKey == nil {
+		return nil, errors.New("signing key must contain a private part")
+	}
+	k := &Keyring{
+		signing: signing,
+		keys:    map[string]*Key{signing.ID: signing},
+	}
+	for _, key := range verify {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// NewKeyringFromConfig построение связки ключей из конфигурации приложения
+func NewKeyringFromConfig(conf *config.ServerConfig) (*Keyring, error) {
+	seedAllowed := conf.DevMode || conf.Seed != config.DefaultSeed
+
+	var signing *Key
+	verify := make([]*Key, 0)
+	if conf.JWTSigningKey != "" {
+		key, err := LoadPEMKey(conf.JWTKeyID, conf.JWTSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		if key.signKey == nil {
+			return nil, fmt.Errorf("signing key %s must be a private key", conf.JWTSigningKey)
+		}
+		signing = key
+		if seedAllowed {
+			verify = append(verify, NewHMACKey(seedKeyID, conf.Seed))
+		}
+	} else {
+		if !seedAllowed {
+			return nil, ErrDefaultSeed
+		}
+		signing = NewHMACKey(conf.JWTKeyID, conf.Seed)
+	}
+
+	for _, entry := range conf.JWTPrevSeeds {
+		id, secret, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, errors.New("previous seed must be in form kid=secret")
+		}
+		verify = append(verify, NewHMACKey(id, secret))
+	}
+	for _, entry := range conf.JWTVerifyKeys {
+		id, path, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("verification key must be in form kid=path, got %q", entry)
+		}
+		key, err := LoadPEMKey(id, path)
+		if err != nil {
+			return nil, err
+		}
+		verify = append(verify, key)
+	}
+
+	keyring, err := NewKeyring(signing, verify...)
+	if err != nil {
+		return nil, err
+	}
+	if seedAllowed {
+		keyring.legacy = NewHMACKey(seedKeyID, conf.Seed)
+	}
+	return keyring, nil
+}
+
+// Sign подпись набора клаймов активным ключом
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.signKey)
+}
+
+// Parse разбор и проверка токена одним из ключей связки
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.NewParser(options...).ParseWithClaims(tokenString, claims, k.keyFunc)
+}
+
+// keyFunc выбор ключа проверки по заголовку kid
+func (k *Keyring) keyFunc(t *jwt.Token) (interface{}, error) {
+	var key *Key
+	if kid, ok := t.Header["kid"].(string); ok {
+		key = k.keys[kid]
+	} else {
+		key = k.legacy
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	// алгоритм токена должен совпадать с алгоритмом ключа,
+	// иначе публичный ключ мог бы использоваться как HMAC-секрет
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", t.Method.Alg(), key.ID)
+	}
+	return key.verifyKey, nil
+}
+
+// JWK описание публичного ключа в формате JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet набор публичных ключей
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS набор публичных ключей связки, симметричные ключи не публикуются
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// JWKSHandler обработчик, отдающий публичные ключи для проверки токенов другими сервисами
+func (k *Keyring) JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, k.JWKS())
+}
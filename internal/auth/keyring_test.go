@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/EvgeniyBudaev/shortener/internal/config"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := NewHMACKey("old", "old-secret")
+	oldRing, err := NewKeyring(oldKey)
+	require.NoError(t, err)
+	token, err := BuildJWTString(oldRing)
+	require.NoError(t, err)
+
+	newRing, err := NewKeyring(NewHMACKey("new", "new-secret"), oldKey)
+	require.NoError(t, err)
+	userID, err := GetUserID(token, newRing)
+	require.NoError(t, err)
+	assert.NotEmpty(t, userID)
+
+	withoutOld, err := NewKeyring(NewHMACKey("new", "new-secret"))
+	require.NoError(t, err)
+	_, err = GetUserID(token, withoutOld)
+	assert.ErrorIs(t, err, ErrTokenNotValid)
+}
+
+func TestKeyringAsymmetric(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})
+
+	tests := []struct {
+		name string
+		pem  []byte
+		alg  string
+		kty  string
+	}{
+		{name: "rsa", pem: rsaPEM, alg: "RS256", kty: "RSA"},
+		{name: "ed25519", pem: edPEM, alg: "EdDSA", kty: "OKP"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePEMKey(tt.name, tt.pem)
+			require.NoError(t, err)
+			keyring, err := NewKeyring(key)
+			require.NoError(t, err)
+
+			token, err := BuildJWTString(keyring)
+			require.NoError(t, err)
+			_, err = GetUserID(token, keyring)
+			require.NoError(t, err)
+
+			jwks := keyring.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, tt.name, jwks.Keys[0].Kid)
+			assert.Equal(t, tt.alg, jwks.Keys[0].Alg)
+			assert.Equal(t, tt.kty, jwks.Keys[0].Kty)
+		})
+	}
+}
+
+func TestKeyringRejectsAlgorithmSwitch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(&Key{ID: "rsa", Method: jwt.SigningMethodRS256, signKey: rsaKey, verifyKey: &rsaKey.PublicKey})
+	require.NoError(t, err)
+
+	// токен подписан HMAC с публичным ключом в качестве секрета
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "attacker"})
+	forged.Header["kid"] = "rsa"
+	tokenString, err := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	require.NoError(t, err)
+
+	_, err = GetUserID(tokenString, keyring)
+	assert.Error(t, err)
+}
+
+func TestNewKeyringFromConfigDefaultSeed(t *testing.T) {
+	_, err := NewKeyringFromConfig(&config.ServerConfig{Seed: config.DefaultSeed})
+	assert.ErrorIs(t, err, ErrDefaultSeed)
+
+	_, err = NewKeyringFromConfig(&config.ServerConfig{Seed: config.DefaultSeed, DevMode: true})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,86 @@
+// Модуль аутентификации внутренних сервисов по клиентским сертификатам.
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientCertIDPrefix префикс идентификатора сервиса, для сертификата которого нет явного сопоставления
+const clientCertIDPrefix = "cert:"
+
+// parseClientCertIDs разбор сопоставлений CN=userID
+func parseClientCertIDs(entries []string) (map[string]string, error) {
+	ids := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		cn, userID, ok := strings.Cut(entry, "=")
+		if !ok || cn == "" || userID == "" {
+			return nil, errors.New("client certificate identity must be in form CN=userID")
+		}
+		ids[cn] = userID
+	}
+	return ids, nil
+}
+
+// parseTrustedSubnet разбор доверенной подсети, пустая строка означает отсутствие подсети
+func parseTrustedSubnet(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted subnet: %w", err)
+	}
+	return subnet, nil
+}
+
+// clientCertIdentity идентификатор пользователя или сервиса по проверенному клиентскому сертификату
+func (a *Authenticator) clientCertIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	subject := subjectName(leaf)
+	if userID, ok := a.clientCertIDs[subject]; ok {
+		return userID, true
+	}
+	return clientCertIDPrefix + subject, true
+}
+
+// subjectName имя субъекта сертификата: CN, а при его отсутствии полная строка субъекта
+func subjectName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// RequireInternal политика маршрута для внутренних и административных ручек:
+// запрос должен быть аутентифицирован клиентским сертификатом или прийти из доверенной подсети.
+// В режиме разработки ограничение не применяется. Доверенная подсеть берется из действующей конфигурации.
+func (a *Authenticator) RequireInternal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.devMode || c.GetString(authMethodKey) == authMethodClientCert {
+			c.Next()
+			return
+		}
+		trustedSubnet, err := parseTrustedSubnet(a.conf.Get().TrustedSubnet)
+		if err != nil {
+			log.Printf("Error parsing trusted subnet: %v", err)
+		}
+		if trustedSubnet != nil {
+			if ip := net.ParseIP(c.RemoteIP()); ip != nil && trustedSubnet.Contains(ip) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
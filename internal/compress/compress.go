@@ -43,6 +43,15 @@ func (c *compressWriter) WriteHeader(statusCode int) {
 	c.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Flush отправляет клиенту уже сжатые данные, нужен для потоковых ответов
+func (c *compressWriter) Flush() {
+	if err := c.zw.Flush(); err != nil {
+		log.Printf("Error flushing gzip writer: %v", err)
+		return
+	}
+	c.ResponseWriter.Flush()
+}
+
 // Close закрывает gzip.Writer и досылает все данные из буфера.
 func (c *compressWriter) Close() error {
 	return c.zw.Close()
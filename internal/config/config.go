@@ -2,52 +2,255 @@
 package config
 
 import (
-	"bytes"
-	"dario.cat/mergo"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/caarlos0/env/v6"
+	"net/http"
 	"os"
+	"reflect"
+	"strings"
 )
 
+// DefaultSeed встроенный секрет подписи JWT, допустим только в режиме разработки
+const DefaultSeed = "b4952c3809196592c026529df00774e46bfb5be0"
+
+// DefaultRateLimitPassword ограничение попыток ввода пароля ссылки по умолчанию
+const DefaultRateLimitPassword = "5/m"
+
 // ServerConfig описывает структуру конфигурации приложения
 type ServerConfig struct {
-	FlagRunAddr     string `json:"server_address" env:"SERVER_ADDRESS"`
-	EnableHTTPS     bool   `env:"ENABLE_HTTPS"`
-	RedirectBaseURL string `json:"base_url" env:"BASE_URL"`
-	FileStoragePath string `json:"file_storage_path" env:"FILE_STORAGE_PATH"`
-	DatabaseDSN     string `json:"database_dsn" env:"DATABASE_DSN"`
-	Seed            string `json:"-" env:"SEED"`
-	Config          string `json:"-" env:"CONFIG"`
-}
-
-var serverConfig ServerConfig
-
-// ServerConfig парсит значения из переменных окружения
-func ParseFlags() (*ServerConfig, error) {
-	flag.StringVar(&serverConfig.FlagRunAddr, "a", ":8080", "address and port to run server")
-	flag.BoolVar(&serverConfig.EnableHTTPS, "s", false, "enable https")
-	flag.StringVar(&serverConfig.RedirectBaseURL, "b", "http://localhost:8080", "server URI prefix")
-	flag.StringVar(&serverConfig.FileStoragePath, "f", "", "file storage path")
-	flag.StringVar(&serverConfig.DatabaseDSN, "d", "", "Data Source Name (DSN)")
-	flag.StringVar(&serverConfig.Seed, "e", "b4952c3809196592c026529df00774e46bfb5be0", "seed")
-	flag.StringVar(&serverConfig.Config, "c", "", "Config json file path")
-	flag.Parse()
-
-	if serverConfig.Config != "" {
-		data, err := os.ReadFile(serverConfig.Config)
+	FlagRunAddr          string   `json:"server_address" yaml:"server_address" toml:"server_address" env:"SERVER_ADDRESS"`
+	EnableHTTPS          bool     `json:"enable_https" yaml:"enable_https" toml:"enable_https" env:"ENABLE_HTTPS"`
+	TLSCertFile          string   `json:"tls_cert_file" yaml:"tls_cert_file" toml:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile           string   `json:"tls_key_file" yaml:"tls_key_file" toml:"tls_key_file" env:"TLS_KEY_FILE"`
+	TLSClientCAFile      string   `json:"tls_client_ca_file" yaml:"tls_client_ca_file" toml:"tls_client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth        string   `json:"tls_client_auth" yaml:"tls_client_auth" toml:"tls_client_auth" env:"TLS_CLIENT_AUTH"`
+	ClientCertIDs        []string `json:"client_cert_ids" yaml:"client_cert_ids" toml:"client_cert_ids" env:"CLIENT_CERT_IDS"`
+	TrustedSubnet        string   `json:"trusted_subnet" yaml:"trusted_subnet" toml:"trusted_subnet" env:"TRUSTED_SUBNET" reload:"true"`
+	TrustedProxies       []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	RedirectBaseURL      string   `json:"base_url" yaml:"base_url" toml:"base_url" env:"BASE_URL" reload:"true"`
+	ShortDomains         []string `json:"short_domains" yaml:"short_domains" toml:"short_domains" env:"SHORT_DOMAINS" reload:"true"`
+	FileStoragePath      string   `json:"file_storage_path" yaml:"file_storage_path" toml:"file_storage_path" env:"FILE_STORAGE_PATH"`
+	DatabaseDSN          string   `json:"database_dsn" yaml:"database_dsn" toml:"database_dsn" env:"DATABASE_DSN" secret:"true"`
+	Seed                 string   `json:"-" yaml:"-" toml:"-" env:"SEED" secret:"true"`
+	JWTKeyID             string   `json:"jwt_key_id" yaml:"jwt_key_id" toml:"jwt_key_id" env:"JWT_KEY_ID"`
+	JWTSigningKey        string   `json:"jwt_signing_key" yaml:"jwt_signing_key" toml:"jwt_signing_key" env:"JWT_SIGNING_KEY"`
+	JWTVerifyKeys        []string `json:"jwt_verify_keys" yaml:"jwt_verify_keys" toml:"jwt_verify_keys" env:"JWT_VERIFY_KEYS"`
+	JWTPrevSeeds         []string `json:"-" yaml:"-" toml:"-" env:"JWT_PREV_SEEDS" secret:"true"`
+	LogLevel             string   `json:"log_level" yaml:"log_level" toml:"log_level" env:"LOG_LEVEL" reload:"true"`
+	DevMode              bool     `json:"dev_mode" yaml:"dev_mode" toml:"dev_mode" env:"DEV_MODE"`
+	CookieSecure         bool     `json:"cookie_secure" yaml:"cookie_secure" toml:"cookie_secure" env:"COOKIE_SECURE"`
+	CookieSameSite       string   `json:"cookie_same_site" yaml:"cookie_same_site" toml:"cookie_same_site" env:"COOKIE_SAME_SITE"`
+	CookieDomain         string   `json:"cookie_domain" yaml:"cookie_domain" toml:"cookie_domain" env:"COOKIE_DOMAIN"`
+	CookiePath           string   `json:"cookie_path" yaml:"cookie_path" toml:"cookie_path" env:"COOKIE_PATH"`
+	EnableCSRF           bool     `json:"enable_csrf" yaml:"enable_csrf" toml:"enable_csrf" env:"ENABLE_CSRF"`
+	APIKeys              []string `json:"-" yaml:"-" toml:"-" env:"API_KEYS" secret:"true"`
+	RedirectStatus       int      `json:"redirect_status" yaml:"redirect_status" toml:"redirect_status" env:"REDIRECT_STATUS" reload:"true"`
+	RedirectCacheControl string   `json:"redirect_cache_control" yaml:"redirect_cache_control" toml:"redirect_cache_control" env:"REDIRECT_CACHE_CONTROL" reload:"true"`
+	ReferrerPolicy       string   `json:"referrer_policy" yaml:"referrer_policy" toml:"referrer_policy" env:"REFERRER_POLICY" reload:"true"`
+	RobotsTag            string   `json:"robots_tag" yaml:"robots_tag" toml:"robots_tag" env:"ROBOTS_TAG" reload:"true"`
+	DomainListFile       string   `json:"domain_list_file" yaml:"domain_list_file" toml:"domain_list_file" env:"DOMAIN_LIST_FILE"`
+	CheckReachability    bool     `json:"check_reachability" yaml:"check_reachability" toml:"check_reachability" env:"CHECK_REACHABILITY"`
+	SortQueryParams      bool     `json:"sort_query_params" yaml:"sort_query_params" toml:"sort_query_params" env:"SORT_QUERY_PARAMS" reload:"true"`
+	RateLimitRedirect    string   `json:"rate_limit_redirect" yaml:"rate_limit_redirect" toml:"rate_limit_redirect" env:"RATE_LIMIT_REDIRECT" reload:"true"`
+	RateLimitShorten     string   `json:"rate_limit_shorten" yaml:"rate_limit_shorten" toml:"rate_limit_shorten" env:"RATE_LIMIT_SHORTEN" reload:"true"`
+	RateLimitBatch       string   `json:"rate_limit_batch" yaml:"rate_limit_batch" toml:"rate_limit_batch" env:"RATE_LIMIT_BATCH" reload:"true"`
+	RateLimitPassword    string   `json:"rate_limit_password" yaml:"rate_limit_password" toml:"rate_limit_password" env:"RATE_LIMIT_PASSWORD" reload:"true"`
+	RateLimitShared      bool     `json:"rate_limit_shared" yaml:"rate_limit_shared" toml:"rate_limit_shared" env:"RATE_LIMIT_SHARED"`
+	WebhookAllowPrivate  bool     `json:"webhook_allow_private" yaml:"webhook_allow_private" toml:"webhook_allow_private" env:"WEBHOOK_ALLOW_PRIVATE"`
+	Config               string   `json:"-" yaml:"-" toml:"-" env:"CONFIG"`
+	PrintConfig          bool     `json:"-" yaml:"-" toml:"-"`
+}
+
+// Source источник действующего значения параметра конфигурации
+type Source string
+
+// Источники значений в порядке возрастания приоритета
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Sources источники значений по имени переменной окружения параметра
+type Sources map[string]Source
+
+// Default конфигурация по умолчанию
+func Default() ServerConfig {
+	return ServerConfig{
+		FlagRunAddr:       ":8080",
+		TLSCertFile:       "./certs/cert.pem",
+		TLSKeyFile:        "./certs/private.pem",
+		TLSClientAuth:     "optional",
+		RedirectBaseURL:   "http://localhost:8080",
+		LogLevel:          "debug",
+		RedirectStatus:    http.StatusTemporaryRedirect,
+		Seed:              DefaultSeed,
+		JWTKeyID:          "default",
+		CookieSameSite:    "lax",
+		CookiePath:        "/",
+		RateLimitPassword: DefaultRateLimitPassword,
+	}
+}
+
+// ParseFlags загрузка конфигурации из аргументов командной строки, файла и переменных окружения процесса
+func ParseFlags() (*ServerConfig, Sources, error) {
+	return Load(flag.NewFlagSet(os.Args[0], flag.ExitOnError), os.Args[1:], os.Environ())
+}
+
+// Load построение новой конфигурации. Флаги регистрируются в переданном fs, поэтому загрузку
+// можно выполнять повторно. Приоритет источников: значения по умолчанию < файл конфигурации
+// < переменные окружения < явно заданные флаги. Путь к файлу берется из флага -c или CONFIG.
+func Load(fs *flag.FlagSet, args []string, environ []string) (*ServerConfig, Sources, error) {
+	conf := Default()
+	sources := make(Sources)
+	forEachField(&conf, func(field reflect.StructField, _ reflect.Value) {
+		if name, ok := field.Tag.Lookup("env"); ok {
+			sources[name] = SourceDefault
+		}
+	})
+
+	flagConf := Default()
+	flagFields := bindFlags(fs, &flagConf)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	envMap := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			envMap[k] = v
+		}
+	}
+
+	conf.Config = envMap["CONFIG"]
+	if explicit["c"] {
+		conf.Config = flagConf.Config
+	}
+	if conf.Config != "" {
+		keys, err := ReadFile(conf.Config, &conf)
 		if err != nil {
-			return nil, fmt.Errorf("error opening config file: %w", err)
+			return nil, nil, err
 		}
-		var configFromFile ServerConfig
-		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&configFromFile); err != nil {
-			return nil, fmt.Errorf("error parsing json file config: %w", err)
+		forEachField(&conf, func(field reflect.StructField, _ reflect.Value) {
+			if keys[field.Tag.Get("json")] {
+				sources[field.Tag.Get("env")] = SourceFile
+			}
+		})
+	}
+
+	if err := env.Parse(&conf, env.Options{Environment: envMap}); err != nil {
+		return nil, nil, err
+	}
+	forEachField(&conf, func(field reflect.StructField, _ reflect.Value) {
+		if name, ok := field.Tag.Lookup("env"); ok {
+			if _, set := envMap[name]; set {
+				sources[name] = SourceEnv
+			}
 		}
-		if err := mergo.Merge(&serverConfig, configFromFile); err != nil {
-			return nil, fmt.Errorf("cannot merge configs: %w", err)
+	})
+
+	dst := reflect.ValueOf(&conf).Elem()
+	src := reflect.ValueOf(&flagConf).Elem()
+	for name := range explicit {
+		fieldName := flagFields[name]
+		dst.FieldByName(fieldName).Set(src.FieldByName(fieldName))
+		if field, _ := dst.Type().FieldByName(fieldName); field.Tag.Get("env") != "" {
+			sources[field.Tag.Get("env")] = SourceFlag
 		}
 	}
 
-	return &serverConfig, env.Parse(&serverConfig)
+	if err := conf.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return &conf, sources, nil
+}
+
+// bindFlags регистрация флагов командной строки, возвращает соответствие имени флага полю конфигурации
+func bindFlags(fs *flag.FlagSet, c *ServerConfig) map[string]string {
+	b := &flagBinder{fs: fs, conf: reflect.ValueOf(c).Elem(), fields: make(map[string]string)}
+	b.bind("a", "FlagRunAddr", "address and port to run server")
+	b.bind("s", "EnableHTTPS", "enable https")
+	b.bind("tls-cert", "TLSCertFile", "TLS certificate file path")
+	b.bind("tls-key", "TLSKeyFile", "TLS private key file path")
+	b.bind("tls-client-ca", "TLSClientCAFile", "CA bundle for verifying client certificates, enables mTLS")
+	b.bind("tls-client-auth", "TLSClientAuth", "client certificate mode: optional or require")
+	b.bind("client-cert-id", "ClientCertIDs", "identity for client certificate subject in form CN=userID (repeatable)")
+	b.bind("t", "TrustedSubnet", "trusted subnet in CIDR notation allowed to access internal routes")
+	b.bind("trusted-proxy", "TrustedProxies", "IP or CIDR of a reverse proxy whose X-Forwarded-For is trusted for the client IP, none by default (repeatable)")
+	b.bind("b", "RedirectBaseURL", "server URI prefix")
+	b.bind("short-domain", "ShortDomains", "additional base URL of short links on a branded domain, e.g. https://go.example.com (repeatable)")
+	b.bind("f", "FileStoragePath", "file storage path")
+	b.bind("d", "DatabaseDSN", "Data Source Name (DSN)")
+	b.bind("e", "Seed", "seed")
+	b.bind("jwt-kid", "JWTKeyID", "key id of the active JWT signing key")
+	b.bind("jwt-key", "JWTSigningKey", "PEM file with RSA or Ed25519 private key for signing JWT")
+	b.bind("jwt-verify-key", "JWTVerifyKeys", "additional JWT verification key in form kid=path.pem (repeatable)")
+	b.bind("jwt-prev-seed", "JWTPrevSeeds", "previous JWT seed still accepted for verification in form kid=secret (repeatable)")
+	b.bind("l", "LogLevel", "log level: debug, info, warn or error")
+	b.bind("dev", "DevMode", "development mode, allows the built-in default seed")
+	b.bind("cookie-secure", "CookieSecure", "set Secure attribute on cookies (always on with https)")
+	b.bind("cookie-samesite", "CookieSameSite", "SameSite attribute of cookies: lax, strict or none")
+	b.bind("cookie-domain", "CookieDomain", "Domain attribute of cookies")
+	b.bind("cookie-path", "CookiePath", "Path attribute of cookies")
+	b.bind("csrf", "EnableCSRF", "require double-submit CSRF token for cookie-authenticated state-changing requests")
+	b.bind("api-key", "APIKeys", "API key in form key=userID (repeatable)")
+	b.bind("redirect-status", "RedirectStatus", "default redirect status: 301, 302, 307 or 308")
+	b.bind("redirect-cache-control", "RedirectCacheControl", "Cache-Control header of redirect responses, empty omits the header")
+	b.bind("referrer-policy", "ReferrerPolicy", "Referrer-Policy header of redirect responses, empty omits the header")
+	b.bind("robots-tag", "RobotsTag", "X-Robots-Tag header of redirect responses, empty omits the header")
+	b.bind("domain-list", "DomainListFile", "file with domain allow and block rules, reloaded on change")
+	b.bind("check-reachability", "CheckReachability", "verify that original URLs respond before shortening, private addresses are refused")
+	b.bind("sort-query", "SortQueryParams", "sort query parameters of original URLs so that equivalent URLs are deduplicated")
+	b.bind("rate-limit-redirect", "RateLimitRedirect", "rate limit for redirects per client in form N/s, N/m or N/h, empty disables")
+	b.bind("rate-limit-shorten", "RateLimitShorten", "rate limit for shortening single URLs per client in form N/s, N/m or N/h, empty disables")
+	b.bind("rate-limit-batch", "RateLimitBatch", "rate limit for batch shortening per client in form N/s, N/m or N/h, empty disables")
+	b.bind("rate-limit-password", "RateLimitPassword", "rate limit for password attempts per client IP and link in form N/s, N/m or N/h, empty disables")
+	b.bind("rate-limit-shared", "RateLimitShared", "keep rate limit buckets in the database to share them between instances")
+	b.bind("webhook-allow-private", "WebhookAllowPrivate", "allow webhook deliveries to private and loopback addresses, e.g. to a local receiver")
+	b.bind("c", "Config", "Config file path (.json, .yaml, .yml or .toml)")
+	b.bind("print-config", "PrintConfig", "print the effective configuration with secrets masked and exit")
+	return b.fields
+}
+
+// flagBinder регистрирует флаги, привязанные к полям конфигурации
+type flagBinder struct {
+	fs     *flag.FlagSet
+	conf   reflect.Value
+	fields map[string]string
+}
+
+// bind регистрация флага для поля; значение поля становится значением флага по умолчанию.
+// Флаги для списков можно указывать несколько раз.
+func (b *flagBinder) bind(name string, fieldName string, usage string) {
+	field := b.conf.FieldByName(fieldName)
+	switch p := field.Addr().Interface().(type) {
+	case *string:
+		b.fs.StringVar(p, name, *p, usage)
+	case *bool:
+		b.fs.BoolVar(p, name, *p, usage)
+	case *int:
+		b.fs.IntVar(p, name, *p, usage)
+	case *[]string:
+		b.fs.Func(name, usage, func(v string) error {
+			*p = append(*p, v)
+			return nil
+		})
+	default:
+		panic(fmt.Sprintf("unsupported flag type for field %s", fieldName))
+	}
+	b.fields[name] = fieldName
+}
+
+// forEachField обход полей конфигурации
+func forEachField(c *ServerConfig, fn func(field reflect.StructField, value reflect.Value)) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fn(v.Type().Field(i), v.Field(i))
+	}
 }
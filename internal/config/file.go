@@ -0,0 +1,56 @@
+// Модуль чтения конфигурации из файлов JSON, YAML и TOML.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ReadFile чтение конфигурации из файла поверх значений dst, формат выбирается по расширению.
+// Неизвестные ключи считаются ошибкой. Возвращает множество ключей, заданных в файле.
+func ReadFile(path string, dst *ServerConfig) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %w", err)
+	}
+	ext := filepath.Ext(path)
+	if err := decode(ext, data, dst); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	raw := make(map[string]interface{})
+	if err := decode(ext, data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	keys := make(map[string]bool, len(raw))
+	for key := range raw {
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+// decode строгое декодирование конфигурации в формате, заданном расширением файла
+func decode(ext string, data []byte, dst interface{}) error {
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		return dec.Decode(dst)
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(dst)
+	case ".json", "":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(dst)
+	default:
+		return fmt.Errorf("unsupported config file format %q", ext)
+	}
+}
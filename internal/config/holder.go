@@ -0,0 +1,53 @@
+// Модуль хранения действующей конфигурации с возможностью перезагрузки.
+package config
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Holder хранит действующую конфигурацию и атомарно подменяет ее при перезагрузке.
+// Перезагружаются только поля с тегом reload:"true", остальные требуют перезапуска.
+type Holder struct {
+	current atomic.Pointer[ServerConfig]
+}
+
+// NewHolder конструктор
+func NewHolder(c *ServerConfig) *Holder {
+	h := &Holder{}
+	h.current.Store(c)
+	return h
+}
+
+// Get текущая конфигурация. Возвращаемое значение нельзя изменять.
+func (h *Holder) Get() *ServerConfig {
+	return h.current.Load()
+}
+
+// Reload применение новой конфигурации. Возвращает имена измененных параметров
+// и параметров, изменение которых отклонено, так как требует перезапуска.
+func (h *Holder) Reload(next *ServerConfig) (changed []string, rejected []string) {
+	current := h.current.Load()
+	merged := *current
+
+	dst := reflect.ValueOf(&merged).Elem()
+	forEachField(next, func(field reflect.StructField, value reflect.Value) {
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			return
+		}
+		old := dst.FieldByName(field.Name)
+		if reflect.DeepEqual(old.Interface(), value.Interface()) {
+			return
+		}
+		if field.Tag.Get("reload") != "true" {
+			rejected = append(rejected, name)
+			return
+		}
+		old.Set(value)
+		changed = append(changed, name)
+	})
+
+	h.current.Store(&merged)
+	return changed, rejected
+}
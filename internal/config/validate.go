@@ -0,0 +1,202 @@
+// Модуль проверки и вывода конфигурации.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/EvgeniyBudaev/shortener/internal/ratelimit"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// secretMask значение, которым заменяются секреты при выводе конфигурации
+const secretMask = "******"
+
+// Validate проверка конфигурации, возвращает все найденные ошибки сразу
+func (c *ServerConfig) Validate() error {
+	var errs []error
+
+	if _, port, err := net.SplitHostPort(c.FlagRunAddr); err != nil {
+		errs = append(errs, fmt.Errorf("server address %q: %w", c.FlagRunAddr, err))
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("server address %q: invalid port", c.FlagRunAddr))
+	}
+
+	if u, err := url.Parse(c.RedirectBaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("base URL %q: %w", c.RedirectBaseURL, err))
+	} else if !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		errs = append(errs, fmt.Errorf("base URL %q must be an absolute http(s) URL", c.RedirectBaseURL))
+	}
+
+	hosts := make(map[string]bool, len(c.ShortDomains))
+	for _, domain := range c.ShortDomains {
+		u, err := url.Parse(domain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("short domain %q: %w", domain, err))
+			continue
+		}
+		if !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("short domain %q must be an absolute http(s) URL", domain))
+			continue
+		}
+		if hosts[strings.ToLower(u.Host)] {
+			errs = append(errs, fmt.Errorf("short domain %q is configured twice", domain))
+		}
+		hosts[strings.ToLower(u.Host)] = true
+	}
+
+	if c.DatabaseDSN != "" {
+		if _, err := pgx.ParseConfig(c.DatabaseDSN); err != nil {
+			// текст ошибки pgx может содержать пароль, поэтому не выводим его
+			errs = append(errs, errors.New("database DSN is malformed"))
+		}
+	}
+
+	if c.FileStoragePath != "" {
+		if err := checkWritable(c.FileStoragePath); err != nil {
+			errs = append(errs, fmt.Errorf("file storage path %q is not writable: %w", c.FileStoragePath, err))
+		}
+	}
+
+	if c.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("trusted subnet: %w", err))
+		}
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("trusted proxy %q must be an IP address or CIDR", proxy))
+			}
+		}
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log level: %w", err))
+	}
+
+	switch strings.ToLower(c.CookieSameSite) {
+	case "", "lax", "strict", "none":
+	default:
+		errs = append(errs, fmt.Errorf("unknown cookie SameSite mode %q", c.CookieSameSite))
+	}
+
+	switch c.TLSClientAuth {
+	case "", "optional", "require":
+	default:
+		errs = append(errs, fmt.Errorf("unknown TLS client auth mode %q", c.TLSClientAuth))
+	}
+
+	if err := ValidateRedirectOptions(c.RedirectStatus, c.RedirectCacheControl, c.ReferrerPolicy, c.RobotsTag); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.DomainListFile != "" {
+		if _, err := os.Stat(c.DomainListFile); err != nil {
+			errs = append(errs, fmt.Errorf("domain list file: %w", err))
+		}
+	}
+
+	for _, limit := range []string{c.RateLimitRedirect, c.RateLimitShorten, c.RateLimitBatch, c.RateLimitPassword} {
+		if _, err := ratelimit.ParseLimit(limit); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.RateLimitShared && c.DatabaseDSN == "" {
+		errs = append(errs, errors.New("shared rate limiter requires database DSN"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// referrerPolicies допустимые значения заголовка Referrer-Policy
+var referrerPolicies = map[string]bool{
+	"no-referrer":                     true,
+	"no-referrer-when-downgrade":      true,
+	"origin":                          true,
+	"origin-when-cross-origin":        true,
+	"same-origin":                     true,
+	"strict-origin":                   true,
+	"strict-origin-when-cross-origin": true,
+	"unsafe-url":                      true,
+}
+
+// ValidateRedirectOptions проверка параметров ответа с перенаправлением.
+// Используется и для настроек сервиса, и для настроек отдельных ссылок; пустые значения не проверяются.
+func ValidateRedirectOptions(status int, cacheControl, referrerPolicy, robotsTag string) error {
+	var errs []error
+	switch status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		errs = append(errs, fmt.Errorf("redirect status %d must be 301, 302, 307 or 308", status))
+	}
+	if referrerPolicy != "" && !referrerPolicies[referrerPolicy] {
+		errs = append(errs, fmt.Errorf("unknown referrer policy %q", referrerPolicy))
+	}
+	if strings.ContainsAny(cacheControl+robotsTag, "\r\n") {
+		errs = append(errs, errors.New("cache control and robots tag must not contain line breaks"))
+	}
+	return errors.Join(errs...)
+}
+
+// checkWritable проверка, что файл можно создать или дописать
+func checkWritable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".write-check-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// Print вывод действующей конфигурации в виде ИМЯ_ПЕРЕМЕННОЙ=значение с указанием источника,
+// секреты маскируются
+func (c *ServerConfig) Print(w io.Writer, sources Sources) error {
+	var err error
+	forEachField(c, func(field reflect.StructField, v reflect.Value) {
+		name, ok := field.Tag.Lookup("env")
+		if !ok || err != nil {
+			return
+		}
+		value := formatValue(v)
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = secretMask
+		}
+		source := sources[name]
+		if source == "" {
+			source = SourceDefault
+		}
+		_, err = fmt.Fprintf(w, "%s=%s # %s\n", name, value, source)
+	})
+	return err
+}
+
+// formatValue строковое представление значения поля конфигурации
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
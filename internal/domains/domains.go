@@ -0,0 +1,223 @@
+// Модуль списков разрешенных и запрещенных доменов.
+package domains
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// reloadInterval минимальный интервал между проверками файла списков на изменение
+const reloadInterval = time.Second * 10
+
+// Ошибки проверки домена
+var (
+	ErrBlocked    = errors.New("domain is blocked")
+	ErrNotAllowed = errors.New("domain is not in the allowlist")
+)
+
+// DeniedError домен отклонен правилом списка
+type DeniedError struct {
+	Host string
+	// Rule правило, по которому отклонен домен; пустое, если домена нет в списке разрешенных
+	Rule string
+	err  error
+}
+
+// Error описание ошибки
+func (e *DeniedError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("%s: %v", e.Host, e.err)
+	}
+	return fmt.Sprintf("%s: %v by rule %q", e.Host, e.err, e.Rule)
+}
+
+// Unwrap ErrBlocked или ErrNotAllowed
+func (e *DeniedError) Unwrap() error {
+	return e.err
+}
+
+// pattern правило сопоставления домена:
+// example.com - только сам домен, *.example.com - только поддомены,
+// .example.com - домен и все поддомены, остальные шаблоны с * сравниваются как glob.
+type pattern string
+
+// match проверка домена по правилу
+func (p pattern) match(host string) bool {
+	s := string(p)
+	switch {
+	case strings.HasPrefix(s, "*."):
+		return strings.HasSuffix(host, s[1:])
+	case strings.HasPrefix(s, "."):
+		return host == s[1:] || strings.HasSuffix(host, s)
+	case strings.Contains(s, "*"):
+		ok, _ := path.Match(s, host)
+		return ok
+	default:
+		return host == s
+	}
+}
+
+// List списки разрешенных и запрещенных доменов.
+// Запрет имеет приоритет; если список разрешенных не пуст, остальные домены запрещены.
+type List struct {
+	allow []pattern
+	block []pattern
+}
+
+// Parse разбор списков. Каждая строка содержит правило вида "allow <шаблон>" или "block <шаблон>",
+// пустые строки и строки, начинающиеся с #, пропускаются.
+func Parse(r io.Reader) (*List, error) {
+	list := &List{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: rule must be in form \"allow|block pattern\"", n)
+		}
+		p, err := parsePattern(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		switch fields[0] {
+		case "allow":
+			list.allow = append(list.allow, p)
+		case "block":
+			list.block = append(list.block, p)
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", n, fields[0])
+		}
+	}
+	return list, scanner.Err()
+}
+
+// parsePattern приведение шаблона к виду, в котором хранятся нормализованные хосты
+func parsePattern(s string) (pattern, error) {
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	if strings.Contains(s, "*") && !strings.HasPrefix(s, "*.") {
+		return pattern(s), nil
+	}
+	prefix := ""
+	for _, p := range []string{"*.", "."} {
+		if strings.HasPrefix(s, p) {
+			prefix, s = p, s[len(p):]
+			break
+		}
+	}
+	ascii, err := idna.Lookup.ToASCII(s)
+	if err != nil || ascii == "" {
+		return "", fmt.Errorf("invalid domain pattern %q", prefix+s)
+	}
+	return pattern(prefix + ascii), nil
+}
+
+// Check проверка хоста по спискам, возвращает *DeniedError, если домен запрещен
+func (l *List) Check(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range l.block {
+		if p.match(host) {
+			return &DeniedError{Host: host, Rule: string(p), err: ErrBlocked}
+		}
+	}
+	if len(l.allow) == 0 {
+		return nil
+	}
+	for _, p := range l.allow {
+		if p.match(host) {
+			return nil
+		}
+	}
+	return &DeniedError{Host: host, err: ErrNotAllowed}
+}
+
+// FileList списки доменов из файла, перечитываемые после его изменения
+type FileList struct {
+	path string
+
+	mux       sync.RWMutex
+	list      *List
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+// NewFileList конструктор, файл загружается сразу
+func NewFileList(path string) (*FileList, error) {
+	f := &FileList{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load загрузка списков из файла
+func (f *FileList) load() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	list, err := Parse(file)
+	if err != nil {
+		return fmt.Errorf("error parsing domain list %s: %w", f.path, err)
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.list = list
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+// maybeReload перечитывает файл, если изменились время его изменения или размер.
+// Время сравнивается на равенство: при атомарной замене файл может получить более раннее время.
+// При ошибке продолжают использоваться предыдущие списки.
+func (f *FileList) maybeReload() {
+	f.mux.Lock()
+	if time.Since(f.checkedAt) < reloadInterval {
+		f.mux.Unlock()
+		return
+	}
+	f.checkedAt = time.Now()
+	modTime, size := f.modTime, f.size
+	f.mux.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Printf("Error checking domain list file: %v", err)
+		return
+	}
+	if info.ModTime().Equal(modTime) && info.Size() == size {
+		return
+	}
+	if err := f.load(); err != nil {
+		log.Printf("Error reloading domain list, keeping the previous one: %v", err)
+		return
+	}
+	log.Printf("Domain list %s reloaded", f.path)
+}
+
+// Check проверка хоста по актуальным спискам
+func (f *FileList) Check(host string) error {
+	f.maybeReload()
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return f.list.Check(host)
+}
@@ -0,0 +1,66 @@
+package domains
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCheck(t *testing.T) {
+	list, err := Parse(strings.NewReader(`
+# phishing
+block .evil.com
+block *.example.org
+block login-*.net
+block пример.рф
+`))
+	require.NoError(t, err)
+
+	for _, host := range []string{"evil.com", "a.b.evil.com", "sub.example.org", "login-bank.net", "xn--e1afmkfd.xn--p1ai"} {
+		assert.ErrorIs(t, list.Check(host), ErrBlocked, host)
+	}
+	for _, host := range []string{"notevil.com", "example.org", "login.net"} {
+		assert.NoError(t, list.Check(host), host)
+	}
+
+	allow, err := Parse(strings.NewReader("allow .example.com\nblock bad.example.com\n"))
+	require.NoError(t, err)
+	assert.NoError(t, allow.Check("www.example.com"))
+	assert.ErrorIs(t, allow.Check("bad.example.com"), ErrBlocked)
+	assert.ErrorIs(t, allow.Check("other.com"), ErrNotAllowed)
+
+	_, err = Parse(strings.NewReader("deny evil.com\n"))
+	assert.Error(t, err)
+}
+
+func TestFileListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	require.NoError(t, os.WriteFile(path, []byte("block evil.com\n"), 0600))
+
+	list, err := NewFileList(path)
+	require.NoError(t, err)
+	assert.NoError(t, list.Check("phish.net"))
+
+	require.NoError(t, os.WriteFile(path, []byte("block evil.com\nblock phish.net\n"), 0600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	list.checkedAt = time.Time{}
+
+	assert.ErrorIs(t, list.Check("phish.net"), ErrBlocked)
+
+	// атомарная замена файлом того же размера с более ранним временем изменения тоже перечитывается
+	replacement := filepath.Join(t.TempDir(), "domains.txt")
+	require.NoError(t, os.WriteFile(replacement, []byte("block evil.com\nblock phish.org\n"), 0600))
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(replacement, past, past))
+	require.NoError(t, os.Rename(replacement, path))
+	list.checkedAt = time.Time{}
+
+	assert.NoError(t, list.Check("phish.net"))
+	assert.ErrorIs(t, list.Check("phish.org"), ErrBlocked)
+}
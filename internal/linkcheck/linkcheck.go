@@ -0,0 +1,169 @@
+// Модуль проверки доступности исходных URL с защитой от SSRF.
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Параметры проверки по умолчанию
+const (
+	// DefaultTimeout общее время на проверку URL, включая перенаправления
+	DefaultTimeout = time.Second * 5
+	// DefaultMaxRedirects максимальное число перенаправлений
+	DefaultMaxRedirects = 5
+)
+
+// Ошибки проверки
+var (
+	ErrForbiddenAddress = errors.New("destination resolves to a forbidden address")
+	ErrUnreachable      = errors.New("destination is unreachable")
+)
+
+// forbiddenPrefixes диапазоны, не покрытые методами netip.Addr: CGNAT, "this network" и бенчмарки
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// Диапазоны IPv6 со встроенным адресом IPv4: NAT64 (RFC 6052) и 6to4 (RFC 3056)
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// embeddedIPv4 адрес IPv4, к которому ведет адрес NAT64 или 6to4
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	}
+	return netip.Addr{}, false
+}
+
+// isPublic адрес не является приватным, локальным, link-local или служебным.
+// Для адресов NAT64 и 6to4 проверяется встроенный в них адрес IPv4.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if v4, ok := embeddedIPv4(addr); ok {
+		return isPublic(v4)
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker проверка доступности URL запросом HEAD.
+// Адрес проверяется непосредственно перед подключением, после разрешения имени,
+// поэтому перенаправления и повторное разрешение DNS не позволяют обратиться во внутреннюю сеть.
+type Checker struct {
+	client *http.Client
+	// allowed проверка адреса перед подключением
+	allowed func(addr netip.Addr) bool
+}
+
+// NewChecker конструктор
+func NewChecker(timeout time.Duration, maxRedirects int) *Checker {
+	c := &Checker{allowed: isPublic}
+	c.client = &http.Client{
+		Timeout:   timeout,
+		Transport: newTransport(timeout, func(addr netip.Addr) bool { return c.allowed(addr) }),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return c
+}
+
+// NewClient HTTP-клиент для запросов на адреса, заданные пользователями: подключается
+// только к публичным адресам и не следует перенаправлениям
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: newTransport(timeout, isPublic),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// newTransport транспорт, проверяющий адрес функцией allowed непосредственно перед подключением
+func newTransport(timeout time.Duration, allowed func(addr netip.Addr) bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allowed(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		// прокси из окружения не используется, иначе проверка адреса теряет смысл
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		DisableKeepAlives:     true,
+	}
+}
+
+// Validate проверка, что URL доступен и отвечает статусом ниже 400.
+// Серверам, не поддерживающим HEAD, отправляется GET без чтения тела.
+func (c *Checker) Validate(ctx context.Context, originalURL string) error {
+	status, err := c.do(ctx, http.MethodHead, originalURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.do(ctx, http.MethodGet, originalURL)
+	}
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) {
+			return ErrForbiddenAddress
+		}
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	if status >= http.StatusBadRequest {
+		return fmt.Errorf("%w: status %d", ErrUnreachable, status)
+	}
+	return nil
+}
+
+// do выполнение запроса, возвращает статус ответа
+func (c *Checker) do(ctx context.Context, method string, originalURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, originalURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "shortener-linkcheck")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,57 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublic(t *testing.T) {
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "192.168.1.1", "169.254.169.254", "100.64.0.1", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "0.0.0.0"} {
+		assert.False(t, isPublic(netip.MustParseAddr(s)), s)
+	}
+	// NAT64 и 6to4 проверяются по встроенному адресу IPv4
+	for _, s := range []string{"64:ff9b::10.0.0.1", "64:ff9b::a9fe:a9fe", "64:ff9b::7f00:1", "2002:a00:1::1", "2002:a9fe:a9fe::", "2002:7f00:1::1"} {
+		assert.False(t, isPublic(netip.MustParseAddr(s)), s)
+	}
+	for _, s := range []string{"8.8.8.8", "2a00:1450:4010::64", "64:ff9b::808:808", "2002:808:808::1"} {
+		assert.True(t, isPublic(netip.MustParseAddr(s)), s)
+	}
+}
+
+func TestCheckerValidate(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	t.Run("loopback is refused", func(t *testing.T) {
+		checker := NewChecker(time.Second, DefaultMaxRedirects)
+		assert.ErrorIs(t, checker.Validate(ctx, srv.URL+"/ok"), ErrForbiddenAddress)
+	})
+
+	checker := NewChecker(time.Second, DefaultMaxRedirects)
+	checker.allowed = func(addr netip.Addr) bool { return addr.IsLoopback() }
+
+	assert.NoError(t, checker.Validate(ctx, srv.URL+"/ok"))
+	assert.NoError(t, checker.Validate(ctx, srv.URL+"/get-only"))
+	assert.ErrorIs(t, checker.Validate(ctx, srv.URL+"/missing"), ErrUnreachable)
+	assert.ErrorIs(t, checker.Validate(ctx, srv.URL+"/loop"), ErrUnreachable)
+}
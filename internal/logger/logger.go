@@ -11,9 +11,24 @@ import (
 	"go.uber.org/zap"
 )
 
+// level общий уровень логирования, может изменяться во время работы
+var level = zap.NewAtomicLevelAt(zap.DebugLevel)
+
+// SetLevel изменение уровня логирования всех созданных логгеров
+func SetLevel(text string) error {
+	return level.UnmarshalText([]byte(text))
+}
+
+// newZap создание логгера с общим уровнем логирования
+func newZap() (*zap.Logger, error) {
+	conf := zap.NewDevelopmentConfig()
+	conf.Level = level
+	return conf.Build()
+}
+
 // Logger Получение middleware функции, которая будет логгировать входящие запросы.
 func Logger() (gin.HandlerFunc, error) {
-	logger, err := zap.NewDevelopment()
+	logger, err := newZap()
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +61,7 @@ func Logger() (gin.HandlerFunc, error) {
 
 // NewLogger конструктор
 func NewLogger() (*zap.SugaredLogger, error) {
-	logger, err := zap.NewDevelopment()
+	logger, err := newZap()
 	if err != nil {
 		return nil, fmt.Errorf("error creating logger: %w", err)
 	}
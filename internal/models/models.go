@@ -1,29 +1,220 @@
 // Модуль декларирует модели объектов.
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrURLConflict URL уже сокращен, хранилище вернуло существующую запись.
+var ErrURLConflict = errors.New("url already exists, returned stored value")
+
+// ErrNotFound запись не найдена или принадлежит другому пользователю.
+var ErrNotFound = errors.New("record not found")
+
+// ErrIDConflict ошибка - ID ссылки уже занят другой ссылкой
+var ErrIDConflict = errors.New("link id is already taken")
+
+// ErrCursorExpired события после курсора уже удалены из журнала изменений.
+var ErrCursorExpired = errors.New("cursor is older than the oldest retained change event")
+
+// ErrCollectionExists коллекция с таким названием у пользователя уже есть.
+var ErrCollectionExists = errors.New("collection already exists")
+
+// ErrForbidden роли пользователя в рабочем пространстве недостаточно для действия.
+var ErrForbidden = errors.New("insufficient workspace role")
+
+// ErrLastOwner действие оставило бы рабочее пространство без владельца.
+var ErrLastOwner = errors.New("workspace must keep at least one owner")
+
+// Role роль участника рабочего пространства.
+type Role string
+
+// Роли участников рабочего пространства
+const (
+	// RoleOwner управляет участниками и приглашениями, изменяет ссылки
+	RoleOwner Role = "owner"
+	// RoleEditor создает, изменяет и удаляет ссылки
+	RoleEditor Role = "editor"
+	// RoleViewer только просматривает ссылки
+	RoleViewer Role = "viewer"
+)
+
+// Valid роль известна.
+func (r Role) Valid() bool {
+	return r == RoleOwner || r == RoleEditor || r == RoleViewer
+}
+
+// CanEdit роль позволяет изменять ссылки рабочего пространства.
+func (r Role) CanEdit() bool {
+	return r == RoleOwner || r == RoleEditor
+}
+
+// AccessError ошибка изменения ссылки по правам пользователя на нее:
+// недоступная ссылка не раскрывается, а доступная только для просмотра не изменяется.
+func AccessError(view bool, edit bool) error {
+	switch {
+	case !view:
+		return ErrNotFound
+	case !edit:
+		return ErrForbidden
+	}
+	return nil
+}
+
+// LinkOptions настройки ссылки, переопределяющие настройки сервиса. Пустые значения не переопределяют.
+type LinkOptions struct {
+	RedirectStatus int    `json:"redirect_status,omitempty"`
+	CacheControl   string `json:"cache_control,omitempty"`
+	ReferrerPolicy string `json:"referrer_policy,omitempty"`
+	RobotsTag      string `json:"robots_tag,omitempty"`
+	// Rules правила выбора адреса перехода по устройству, языку и параметрам запроса
+	Rules []RedirectRule `json:"rules,omitempty"`
+	// Destinations варианты адреса перехода с весами, если правила не выбрали адрес
+	Destinations []Destination `json:"destinations,omitempty"`
+	// Sticky посетитель получает один и тот же вариант, пока он не приостановлен
+	Sticky bool `json:"sticky,omitempty"`
+	// PassQuery перенос параметров запроса короткой ссылки в адрес перехода:
+	// merge - одноименные параметры адреса перехода сохраняются, override - заменяются, пусто - не переносятся
+	PassQuery string `json:"pass_query,omitempty"`
+	// PassPath перенос части пути после ID ссылки в адрес перехода
+	PassPath bool `json:"pass_path,omitempty"`
+	// UTM метки, добавляемые к адресу перехода, если в нем нет меток с теми же названиями
+	UTM *UTM `json:"utm,omitempty"`
+	// ExpiresAt срок действия ссылки, после него переход по ссылке невозможен
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired срок действия ссылки истек к моменту now
+func (o LinkOptions) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)
+}
+
+// Equal настройки совпадают с other, пустые списки равны отсутствующим, сроки действия сравниваются как моменты времени
+func (o LinkOptions) Equal(other LinkOptions) bool {
+	a, errA := json.Marshal(o.utc())
+	b, errB := json.Marshal(other.utc())
+	return errA == nil && errB == nil && string(a) == string(b)
+}
+
+// utc копия настроек со сроком действия в UTC
+func (o LinkOptions) utc() LinkOptions {
+	if o.ExpiresAt != nil {
+		expiresAt := o.ExpiresAt.UTC()
+		o.ExpiresAt = &expiresAt
+	}
+	return o
+}
+
+// UTM метки кампании, пустые метки не добавляются.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// Destination вариант адреса перехода ссылки с разделением трафика.
+type Destination struct {
+	// ID название варианта для учета переходов, по умолчанию - номер варианта
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Weight относительная доля переходов, 0 - вариант приостановлен
+	Weight int `json:"weight"`
+}
+
+// DestinationsReq структура запроса на замену вариантов адреса перехода.
+type DestinationsReq struct {
+	Destinations []Destination `json:"destinations"`
+	Sticky       bool          `json:"sticky,omitempty"`
+}
+
+// DestinationStats вариант адреса перехода с количеством переходов на него.
+type DestinationStats struct {
+	Destination
+	Clicks int64 `json:"clicks"`
+}
+
+// RedirectRule правило выбора адреса перехода. Должны выполняться все заданные условия,
+// правила проверяются по порядку, без подходящего правила переход выполняется на исходный URL.
+type RedirectRule struct {
+	// Platform платформа клиента по User-Agent: ios, android, windows, macos, linux, mobile или desktop
+	Platform string `json:"platform,omitempty"`
+	// Language предпочитаемый язык клиента из Accept-Language, "pt" подходит и для "pt-BR"
+	Language string `json:"language,omitempty"`
+	// Query значения параметров запроса короткой ссылки, пустое значение требует только наличия параметра
+	Query map[string]string `json:"query,omitempty"`
+	URL   string            `json:"url"`
+}
+
+// Link сокращенная ссылка.
+type Link struct {
+	ID          string
+	OriginalURL string
+	UserID      string
+	Options     LinkOptions
+	// CreatedAt время создания, пустое для ссылок, созданных до появления поля
+	CreatedAt time.Time
+	Deleted   bool
+	Clicks    int64
+	// PasswordHash bcrypt-хэш пароля, пустой для ссылок без пароля
+	PasswordHash string
+	// WorkspaceID рабочее пространство, которому принадлежит ссылка, пустое для личных ссылок
+	WorkspaceID string
+}
+
 // URLRecordFS структура URL записей при работе с файловой системой.
 type URLRecordFS struct {
 	URLRecord
-	UUID   string `json:"uuid"`
-	UserID string `json:"user_id"`
+	UUID      string      `json:"uuid"`
+	UserID    string      `json:"user_id"`
+	Options   LinkOptions `json:"options"`
+	CreatedAt time.Time   `json:"created_at,omitempty"`
+	Deleted   bool        `json:"is_deleted,omitempty"`
+	// PasswordHash bcrypt-хэш пароля ссылки
+	PasswordHash string `json:"password_hash,omitempty"`
+	WorkspaceID  string `json:"workspace_id,omitempty"`
 }
 
 // URLRecordMemory структура URL записей при работе с памятью.
 type URLRecordMemory struct {
 	OriginalURL string
 	UserID      string
+	Options     LinkOptions
+	CreatedAt   time.Time
+	Deleted     bool
+	// PasswordHash bcrypt-хэш пароля ссылки
+	PasswordHash string
+	WorkspaceID  string
 }
 
 // URLRecord ожидаемое тело запроса на сохранение записи URL.
 type URLRecord struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string   `json:"short_url"`
+	OriginalURL string   `json:"original_url"`
+	Tags        []string `json:"tags,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+	WorkspaceID string   `json:"workspace_id,omitempty"`
+}
+
+// URLFilter отбор записей пользователя. Ссылка должна иметь все метки Tags
+// и входить в коллекцию Collection, пустые поля не ограничивают выборку.
+// Если задан WorkspaceID, вместо личных ссылок выбираются ссылки рабочего пространства.
+type URLFilter struct {
+	Tags        []string
+	Collection  string
+	WorkspaceID string
 }
 
 // URLBatchReq структура запроса на сохранение батча.
 type URLBatchReq struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	// Domain домен короткой ссылки, пустой - основной
+	Domain string `json:"domain,omitempty"`
+	LinkOptions
 }
 
 // URLBatchRes структура ответа на сохранение батча.
@@ -38,9 +229,255 @@ type DeleteUserURLsReq []string
 // ShortenReq структура запроса на сохранение одного URL.
 type ShortenReq struct {
 	URL string `json:"url"`
+	// Password пароль для перехода по ссылке, пустой - без пароля
+	Password    string   `json:"password,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+	// Workspace рабочее пространство, которому будет принадлежать ссылка
+	Workspace string `json:"workspace,omitempty"`
+	// Domain домен короткой ссылки из настроенных, пустой - основной
+	Domain string `json:"domain,omitempty"`
+	LinkOptions
 }
 
 // ShortenRes структура ответа на сохранение одного URL.
 type ShortenRes struct {
 	Result string `json:"result"`
 }
+
+// StatsRes структура ответа со статистикой сервиса.
+type StatsRes struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// ErrorRes структура ответа с описанием ошибки.
+type ErrorRes struct {
+	Error string `json:"error"`
+}
+
+// UpdateURLReq структура запроса на изменение исходного URL ссылки.
+type UpdateURLReq struct {
+	URL string `json:"url"`
+}
+
+// URLVersion предыдущий исходный URL ссылки.
+type URLVersion struct {
+	Version     int       `json:"version"`
+	OriginalURL string    `json:"original_url"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+// LinkInfoRes структура ответа с информацией о ссылке.
+type LinkInfoRes struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url,omitempty"`
+	CreatedAt   *time.Time `json:"created_at"`
+	// ExpiresAt срок действия ссылки, ссылки без срока действия не истекают
+	ExpiresAt *time.Time `json:"expires_at"`
+	Deleted   bool       `json:"is_deleted"`
+	// Protected для перехода требуется пароль, исходный URL видит только владелец
+	Protected bool `json:"is_protected"`
+	// Clicks количество переходов, только для владельца ссылки
+	Clicks *int64 `json:"clicks,omitempty"`
+	// Destinations варианты адреса перехода с количеством переходов, только для владельца ссылки
+	Destinations []DestinationStats `json:"destinations,omitempty"`
+}
+
+// LabelRes метка или коллекция пользователя с количеством ссылок в ней.
+type LabelRes struct {
+	Name string `json:"name"`
+	URLs int    `json:"urls"`
+}
+
+// CollectionReq структура запроса на создание коллекции.
+type CollectionReq struct {
+	Name string `json:"name"`
+}
+
+// CollectionUpdateReq структура запроса на изменение состава коллекции.
+type CollectionUpdateReq struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// Workspace рабочее пространство с ролью в нем текущего пользователя.
+type Workspace struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role Role   `json:"role,omitempty"`
+}
+
+// WorkspaceReq структура запроса на создание рабочего пространства.
+type WorkspaceReq struct {
+	Name string `json:"name"`
+}
+
+// WorkspaceMember участник рабочего пространства.
+type WorkspaceMember struct {
+	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
+}
+
+// RoleReq структура запроса с ролью участника или приглашения.
+type RoleReq struct {
+	Role Role `json:"role"`
+}
+
+// WorkspaceInvite приглашение в рабочее пространство. Хранится только хэш токена.
+type WorkspaceInvite struct {
+	TokenHash   string    `json:"-"`
+	WorkspaceID string    `json:"workspace_id"`
+	Role        Role      `json:"role"`
+	CreatedBy   string    `json:"created_by"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// InviteRes структура ответа с токеном приглашения.
+type InviteRes struct {
+	Token     string    `json:"token"`
+	Role      Role      `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// События ссылок для подписок и журнала изменений
+const (
+	EventLinkCreated        = "link.created"
+	EventLinkDeleted        = "link.deleted"
+	EventLinkClickThreshold = "link.click_threshold"
+	EventLinkExpired        = "link.expired"
+	// EventLinkUpdated изменение ссылки, только в журнале изменений
+	EventLinkUpdated = "link.updated"
+)
+
+// Изменившиеся поля ссылки в событии link.updated
+const (
+	FieldOriginalURL  = "original_url"
+	FieldRules        = "rules"
+	FieldDestinations = "destinations"
+	FieldTags         = "tags"
+)
+
+// Состояния доставки события подписке
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead попытки доставки исчерпаны, событие больше не отправляется
+	DeliveryDead = "dead"
+)
+
+// Webhook подписка пользователя на события его ссылок.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// ClickThreshold количество переходов, при достижении которого отправляется link.click_threshold
+	ClickThreshold int64     `json:"click_threshold,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WebhookReq структура запроса на создание подписки.
+type WebhookReq struct {
+	URL            string   `json:"url"`
+	Events         []string `json:"events"`
+	ClickThreshold int64    `json:"click_threshold,omitempty"`
+}
+
+// WebhookRes структура ответа на создание подписки. Секрет подписи возвращается только здесь.
+type WebhookRes struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookEvent событие ссылки для отправки подпискам ее владельца. Событие хранит ключ ссылки,
+// адрес короткой ссылки подставляется в тело запроса при отправке.
+type WebhookEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// LinkID ключ ссылки в хранилище: ID, для дополнительного домена - с префиксом домена
+	LinkID      string `json:"link_id"`
+	OriginalURL string `json:"original_url,omitempty"`
+	// Clicks количество переходов для сравнения с порогом подписки
+	Clicks    int64     `json:"clicks,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Payload тело запроса с событием для короткой ссылки shortURL
+func (e WebhookEvent) Payload(shortURL string) WebhookPayload {
+	return WebhookPayload{
+		ID:        e.ID,
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Data:      WebhookLink{ShortURL: shortURL, OriginalURL: e.OriginalURL, Clicks: e.Clicks},
+	}
+}
+
+// WebhookPayload тело запроса с событием.
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      WebhookLink `json:"data"`
+}
+
+// WebhookLink ссылка в событии.
+type WebhookLink struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url,omitempty"`
+	Clicks      int64  `json:"clicks,omitempty"`
+}
+
+// WebhookDelivery доставка события подписке.
+type WebhookDelivery struct {
+	ID        int64           `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// LastStatus код ответа последней попытки, 0 - ответ не получен
+	LastStatus    int       `json:"last_status,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Data событие доставки, тело запроса Payload строится по нему при отправке и в журнале доставок
+	Data WebhookEvent `json:"-"`
+	// URL и Secret подписки заполняются при выборке доставок к отправке
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// Matches подписка получает событие: для link.click_threshold количество переходов должно совпасть с порогом.
+func (w Webhook) Matches(event WebhookEvent) bool {
+	for _, name := range w.Events {
+		if name == event.Type {
+			return event.Type != EventLinkClickThreshold || w.ClickThreshold == event.Clicks
+		}
+	}
+	return false
+}
+
+// ChangesRetention количество последних событий, которые хранит журнал изменений ссылок.
+// Более старые события удаляются, чтение с курсора до них завершается ошибкой ErrCursorExpired.
+const ChangesRetention = 100000
+
+// ChangeEvent событие журнала изменений ссылок. ID возрастают в порядке записи и служат курсором чтения.
+type ChangeEvent struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+	// LinkID ключ ссылки в хранилище: ID, для дополнительного домена - с префиксом домена
+	LinkID string `json:"link_id"`
+	// UserID пользователь, выполнивший изменение
+	UserID      string `json:"user_id,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	// Field изменившееся поле для link.updated
+	Field     string    `json:"field,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChangesRes структура ответа с событиями журнала изменений.
+type ChangesRes struct {
+	Events []ChangeEvent `json:"events"`
+	// Next курсор для следующего запроса: ID последнего события или исходный курсор
+	Next int64 `json:"next"`
+}
@@ -0,0 +1,147 @@
+// Модуль генерации QR-кодов в форматах PNG и SVG.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Форматы изображения
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Ограничения и значения параметров по умолчанию
+const (
+	DefaultSize   = 256
+	DefaultMargin = 4
+	DefaultLevel  = "M"
+	MinSize       = 64
+	MaxSize       = 2048
+	MaxMargin     = 16
+)
+
+// levels уровни коррекции ошибок
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options параметры изображения
+type Options struct {
+	Format string
+	// Size сторона изображения в пикселях
+	Size int
+	// Level уровень коррекции ошибок: L, M, Q или H
+	Level string
+	// Margin ширина пустой рамки в модулях
+	Margin int
+}
+
+// DefaultOptions параметры по умолчанию
+func DefaultOptions() Options {
+	return Options{Format: FormatPNG, Size: DefaultSize, Level: DefaultLevel, Margin: DefaultMargin}
+}
+
+// Validate проверка параметров
+func (o Options) Validate() error {
+	var errs []error
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		errs = append(errs, fmt.Errorf("format %q must be png or svg", o.Format))
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		errs = append(errs, fmt.Errorf("size must be between %d and %d", MinSize, MaxSize))
+	}
+	if _, ok := levels[o.Level]; !ok {
+		errs = append(errs, fmt.Errorf("level %q must be L, M, Q or H", o.Level))
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		errs = append(errs, fmt.Errorf("margin must be between 0 and %d", MaxMargin))
+	}
+	return errors.Join(errs...)
+}
+
+// ContentType MIME-тип изображения
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Render генерация QR-кода для content
+func Render(content string, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	code, err := qrcode.New(content, levels[opts.Level])
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+
+	if opts.Format == FormatSVG {
+		return renderSVG(bitmap, opts), nil
+	}
+	return renderPNG(bitmap, opts)
+}
+
+// renderPNG изображение PNG; модуль занимает целое число пикселей,
+// поэтому сторона изображения может быть немного меньше запрошенной
+func renderPNG(bitmap [][]bool, opts Options) ([]byte, error) {
+	modules := len(bitmap) + 2*opts.Margin
+	scale := opts.Size / modules
+	if scale < 1 {
+		scale = 1
+	}
+	side := modules * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+opts.Margin)*scale+dx, (y+opts.Margin)*scale+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderSVG векторное изображение, каждый темный модуль - квадрат единичного размера
+func renderSVG(bitmap [][]bool, opts Options) []byte {
+	modules := len(bitmap) + 2*opts.Margin
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				path.WriteString("M" + strconv.Itoa(x+opts.Margin) + "," + strconv.Itoa(y+opts.Margin) + "h1v1h-1z")
+			}
+		}
+	}
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#fff"/>
+<path fill="#000" d="%s"/>
+</svg>
+`, opts.Size, opts.Size, modules, modules, path.String()))
+}
@@ -0,0 +1,49 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderPNG(t *testing.T) {
+	opts := DefaultOptions()
+	data, err := Render("http://localhost:8080/abc123", opts)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	side := img.Bounds().Dx()
+	assert.Equal(t, side, img.Bounds().Dy())
+	assert.LessOrEqual(t, side, opts.Size)
+	assert.Greater(t, side, opts.Size/2)
+
+	// рамка остается пустой
+	r, g, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r&g&b)
+}
+
+func TestRenderSVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Format = FormatSVG
+	opts.Margin = 0
+	data, err := Render("http://localhost:8080/abc123", opts)
+	require.NoError(t, err)
+	svg := string(data)
+	assert.Contains(t, svg, `width="256"`)
+	assert.Regexp(t, `viewBox="0 0 \d+ \d+"`, svg)
+	// без рамки поисковый узор начинается в углу
+	assert.Contains(t, svg, "M0,0h1v1h-1z")
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultOptions().Validate())
+	err := Options{Format: "gif", Size: 10, Level: "X", Margin: -1}.Validate()
+	require.Error(t, err)
+	for _, part := range []string{"format", "size", "level", "margin"} {
+		assert.Contains(t, err.Error(), part)
+	}
+}
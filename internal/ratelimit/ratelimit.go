@@ -0,0 +1,184 @@
+// Модуль ограничения частоты запросов по алгоритму token bucket.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sweepInterval минимальный интервал между удалениями неиспользуемых корзин
+const sweepInterval = time.Minute
+
+// Limit ограничение: не более Count запросов за Period, допускается всплеск до Count запросов
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+// ParseLimit разбор ограничения в форме N/s, N/m или N/h. Пустая строка означает отсутствие ограничения.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" {
+		return Limit{}, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must be in form N/s, N/m or N/h", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q must start with a positive number", s)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[unit]
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q has unknown period %q", s, unit)
+	}
+	return Limit{Count: n, Period: period}, nil
+}
+
+// IsZero ограничение не задано
+func (l Limit) IsZero() bool {
+	return l.Count == 0
+}
+
+// interval время пополнения одного токена
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Count)
+}
+
+// Result результат проверки запроса
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset время до полного восстановления корзины
+	Reset time.Duration
+	// RetryAfter время до появления токена, если запрос отклонен
+	RetryAfter time.Duration
+}
+
+// Take списание токена из корзины, в которой на момент updated было tokens токенов.
+// Возвращает новое количество токенов и результат проверки.
+func Take(tokens float64, updated time.Time, now time.Time, limit Limit) (float64, Result) {
+	elapsed := now.Sub(updated)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens = math.Min(float64(limit.Count), tokens+float64(elapsed)/float64(limit.interval()))
+
+	res := Result{Limit: limit.Count}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(limit.interval()))
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration((float64(limit.Count) - tokens) * float64(limit.interval()))
+	return tokens, res
+}
+
+// Limiter хранилище корзин
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket корзина токенов
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full момент, когда корзина снова станет полной и ее можно забыть
+	full time.Time
+}
+
+// MemoryLimiter ограничитель в памяти процесса
+type MemoryLimiter struct {
+	mux     sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewMemoryLimiter конструктор
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow проверка и списание токена для ключа
+func (m *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Count), updated: now}
+		m.buckets[key] = b
+	}
+	tokens, res := Take(b.tokens, b.updated, now, limit)
+	b.tokens = tokens
+	b.updated = now
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep удаление восстановившихся корзин, чтобы память не росла с числом клиентов
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	m.swept = now
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Middleware ограничение частоты запросов группы маршрутов.
+// Ограничение запрашивается на каждый запрос, поэтому его можно менять без перезапуска;
+// нулевое ограничение отключает проверку. Ключ клиента определяет keyFunc.
+// При ошибке хранилища запрос пропускается.
+func Middleware(limiter Limiter, group string, limit func() Limit, keyFunc func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := limit()
+		if l.IsZero() {
+			c.Next()
+			return
+		}
+		res, err := limiter.Allow(c.Request.Context(), group+":"+keyFunc(c), l)
+		if err != nil {
+			log.Printf("Error checking rate limit: %v", err)
+			c.Next()
+			return
+		}
+		c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// seconds округление длительности вверх до целых секунд
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("30/m")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Count: 30, Period: time.Minute}, limit)
+
+	limit, err = ParseLimit("")
+	require.NoError(t, err)
+	assert.True(t, limit.IsZero())
+
+	for _, s := range []string{"30", "0/s", "-1/s", "10/d", "x/m"} {
+		_, err := ParseLimit(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Count: 2, Period: time.Second}
+
+	for i := 0; i < 2; i++ {
+		res, err := limiter.Allow(context.Background(), "client", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, _ := limiter.Allow(context.Background(), "client", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+	// другие клиенты не затрагиваются
+	res, _ = limiter.Allow(context.Background(), "other", limit)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	res, _ = limiter.Allow(context.Background(), "client", limit)
+	assert.True(t, res.Allowed)
+
+	// восстановившиеся корзины удаляются
+	now = now.Add(time.Hour)
+	_, _ = limiter.Allow(context.Background(), "client", limit)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limit := Limit{Count: 1, Period: time.Minute}
+	r := gin.New()
+	r.GET("/:id", Middleware(NewMemoryLimiter(), "redirect", func() Limit { return limit }, func(c *gin.Context) string {
+		return c.ClientIP()
+	}), func(c *gin.Context) {
+		c.Status(http.StatusTemporaryRedirect)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abd", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// ограничение можно снять без перезапуска
+	limit = Limit{}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abe", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
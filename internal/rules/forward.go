@@ -0,0 +1,103 @@
+// Модуль переноса пути и параметров запроса короткой ссылки в адрес перехода.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+)
+
+// Режимы переноса параметров запроса
+const (
+	// QueryMerge параметры запроса добавляются, если их нет в адресе перехода
+	QueryMerge = "merge"
+	// QueryOverride параметры запроса заменяют одноименные параметры адреса перехода
+	QueryOverride = "override"
+)
+
+// maxUTMLen максимальная длина UTM-метки
+const maxUTMLen = 256
+
+// Ошибки переноса пути
+var (
+	ErrPathNotAllowed = errors.New("link does not forward paths")
+	ErrPathTraversal  = errors.New("path must not contain . or .. segments")
+)
+
+// ValidateForward проверка настроек переноса пути, параметров запроса и UTM-меток
+func ValidateForward(options models.LinkOptions) error {
+	switch options.PassQuery {
+	case "", QueryMerge, QueryOverride:
+	default:
+		return fmt.Errorf("pass_query must be %s or %s", QueryMerge, QueryOverride)
+	}
+	if options.UTM == nil {
+		return nil
+	}
+	for name, value := range utmParams(*options.UTM) {
+		if len(value) > maxUTMLen {
+			return fmt.Errorf("%s is longer than %d characters", name, maxUTMLen)
+		}
+	}
+	return nil
+}
+
+// utmParams UTM-метки под названиями параметров запроса
+func utmParams(utm models.UTM) map[string]string {
+	return map[string]string{
+		"utm_source":   utm.Source,
+		"utm_medium":   utm.Medium,
+		"utm_campaign": utm.Campaign,
+		"utm_term":     utm.Term,
+		"utm_content":  utm.Content,
+	}
+}
+
+// Forward адрес перехода с UTM-метками ссылки, частью пути suffix после ID ссылки и
+// параметрами запроса query по настройкам ссылки. UTM-метки и параметры в режиме merge
+// не заменяют параметры адреса перехода; в режиме override параметры запроса заменяют и UTM-метки.
+func Forward(location string, options models.LinkOptions, suffix string, query url.Values) (string, error) {
+	suffix = strings.TrimLeft(suffix, "/")
+	if suffix != "" && !options.PassPath {
+		return "", ErrPathNotAllowed
+	}
+	if suffix == "" && options.UTM == nil && (options.PassQuery == "" || len(query) == 0) {
+		return location, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	if suffix != "" {
+		for _, segment := range strings.Split(suffix, "/") {
+			if segment == "." || segment == ".." {
+				return "", ErrPathTraversal
+			}
+		}
+		u = u.JoinPath(suffix)
+	}
+
+	params := u.Query()
+	changed := false
+	if options.UTM != nil {
+		for name, value := range utmParams(*options.UTM) {
+			if value != "" && !params.Has(name) {
+				params.Set(name, value)
+				changed = true
+			}
+		}
+	}
+	for name, values := range query {
+		if options.PassQuery == QueryOverride || options.PassQuery == QueryMerge && !params.Has(name) {
+			params[name] = values
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = params.Encode()
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,189 @@
+// Модуль выбора адреса перехода по правилам ссылки.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+)
+
+// MaxRules максимальное количество правил ссылки
+const MaxRules = 20
+
+// Платформы клиента
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWindows = "windows"
+	PlatformMacOS   = "macos"
+	PlatformLinux   = "linux"
+	PlatformMobile  = "mobile"
+	PlatformDesktop = "desktop"
+)
+
+// Ошибки проверки правил
+var (
+	ErrNoConditions = errors.New("rule has no conditions")
+	ErrNoURL        = errors.New("rule url is empty")
+)
+
+// platforms признаки платформ в User-Agent
+var platforms = map[string]func(ua string) bool{
+	PlatformIOS:     isIOS,
+	PlatformAndroid: isAndroid,
+	PlatformWindows: func(ua string) bool { return strings.Contains(ua, "Windows") },
+	PlatformMacOS: func(ua string) bool {
+		return strings.Contains(ua, "Macintosh") && !isIOS(ua)
+	},
+	PlatformLinux: func(ua string) bool {
+		return strings.Contains(ua, "Linux") && !isAndroid(ua)
+	},
+	PlatformMobile: isMobile,
+	PlatformDesktop: func(ua string) bool {
+		return ua != "" && !isMobile(ua)
+	},
+}
+
+func isIOS(ua string) bool {
+	return strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad") || strings.Contains(ua, "iPod")
+}
+
+func isAndroid(ua string) bool {
+	return strings.Contains(ua, "Android")
+}
+
+func isMobile(ua string) bool {
+	return isIOS(ua) || isAndroid(ua) || strings.Contains(ua, "Mobile")
+}
+
+// Validate проверка правил без адресов перехода, их проверяет вызывающий
+func Validate(rules []models.RedirectRule) error {
+	if len(rules) > MaxRules {
+		return fmt.Errorf("at most %d rules are allowed", MaxRules)
+	}
+	for i, rule := range rules {
+		if err := validateRule(rule); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validateRule проверка условий одного правила
+func validateRule(rule models.RedirectRule) error {
+	if rule.URL == "" {
+		return ErrNoURL
+	}
+	if rule.Platform == "" && rule.Language == "" && len(rule.Query) == 0 {
+		return ErrNoConditions
+	}
+	if _, ok := platforms[rule.Platform]; rule.Platform != "" && !ok {
+		return fmt.Errorf("unknown platform %q", rule.Platform)
+	}
+	if rule.Language != "" && !validLanguage(rule.Language) {
+		return fmt.Errorf("invalid language tag %q", rule.Language)
+	}
+	for name := range rule.Query {
+		if name == "" {
+			return errors.New("query parameter name is empty")
+		}
+	}
+	return nil
+}
+
+// validLanguage тег языка из подтегов латинских букв и цифр через дефис, первый - только буквы
+func validLanguage(tag string) bool {
+	for i, sub := range strings.Split(tag, "-") {
+		if sub == "" || len(sub) > 8 {
+			return false
+		}
+		for _, r := range sub {
+			letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+			if !letter && (i == 0 || r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Select адрес перехода по первому подходящему правилу, false - ни одно правило не подошло
+func Select(rules []models.RedirectRule, r *http.Request) (string, bool) {
+	if len(rules) == 0 {
+		return "", false
+	}
+	ua := r.UserAgent()
+	language := PreferredLanguage(r.Header.Get("Accept-Language"))
+	query := r.URL.Query()
+	for _, rule := range rules {
+		if rule.Platform != "" && !platforms[rule.Platform](ua) {
+			continue
+		}
+		if rule.Language != "" && !matchLanguage(rule.Language, language) {
+			continue
+		}
+		if !matchQuery(rule.Query, query) {
+			continue
+		}
+		return rule.URL, true
+	}
+	return "", false
+}
+
+// matchLanguage язык клиента совпадает с тегом правила или уточняет его
+func matchLanguage(rule string, language string) bool {
+	rule = strings.ToLower(rule)
+	return language == rule || strings.HasPrefix(language, rule+"-")
+}
+
+// matchQuery все параметры правила есть в запросе с нужными значениями
+func matchQuery(rule map[string]string, query map[string][]string) bool {
+	for name, want := range rule {
+		values, ok := query[name]
+		if !ok {
+			return false
+		}
+		if want != "" && (len(values) == 0 || values[0] != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// PreferredLanguage самый предпочтительный язык из заголовка Accept-Language в нижнем регистре.
+// Языки с q=0 и "*" не учитываются, при равном весе выбирается указанный раньше.
+func PreferredLanguage(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var languages []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			languages = append(languages, weighted{tag: tag, q: q})
+		}
+	}
+	if len(languages) == 0 {
+		return ""
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].q > languages[j].q })
+	return languages[0].tag
+}
@@ -0,0 +1,167 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	uaIPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	uaAndroid = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36"
+	uaMac     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15"
+	uaLinux   = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+)
+
+func TestSelect(t *testing.T) {
+	rules := []models.RedirectRule{
+		{Query: map[string]string{"src": "qr"}, URL: "https://example.com/qr"},
+		{Platform: PlatformIOS, URL: "https://apps.apple.com/app/id1"},
+		{Platform: PlatformAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+		{Platform: PlatformDesktop, Language: "de", URL: "https://example.de"},
+		{Query: map[string]string{"debug": ""}, URL: "https://example.com/debug"},
+	}
+	tests := []struct {
+		name     string
+		target   string
+		ua       string
+		language string
+		want     string
+	}{
+		{name: "ios", target: "/x", ua: uaIPhone, want: "https://apps.apple.com/app/id1"},
+		{name: "android", target: "/x", ua: uaAndroid, want: "https://play.google.com/store/apps/details?id=app"},
+		{name: "query wins by order", target: "/x?src=qr", ua: uaIPhone, want: "https://example.com/qr"},
+		{name: "query value mismatch", target: "/x?src=mail", ua: uaLinux},
+		{name: "language subtag", target: "/x", ua: uaMac, language: "en;q=0.5, de-AT", want: "https://example.de"},
+		{name: "language on mobile", target: "/x", ua: "Mozilla/5.0 (Mobile; rv:48.0) Firefox/48.0", language: "de"},
+		{name: "language not preferred", target: "/x", ua: uaLinux, language: "en, de;q=0.8"},
+		{name: "query presence", target: "/x?debug", ua: uaLinux, want: "https://example.com/debug"},
+		{name: "no user agent", target: "/x", language: "de"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Header.Set("User-Agent", tt.ua)
+			req.Header.Set("Accept-Language", tt.language)
+			got, ok := Select(rules, req)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	assert.Equal(t, "", PreferredLanguage(""))
+	assert.Equal(t, "fr-ch", PreferredLanguage("fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5"))
+	assert.Equal(t, "en", PreferredLanguage("de;q=0.7, en;q=0.9, *"))
+	assert.Equal(t, "en", PreferredLanguage("de;q=0, en;q=0.1"))
+	assert.Equal(t, "", PreferredLanguage("*"))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate([]models.RedirectRule{{Platform: PlatformIOS, Language: "pt-BR", URL: "https://example.com"}}))
+	assert.ErrorIs(t, Validate([]models.RedirectRule{{URL: "https://example.com"}}), ErrNoConditions)
+	assert.ErrorIs(t, Validate([]models.RedirectRule{{Platform: PlatformIOS}}), ErrNoURL)
+	assert.Error(t, Validate([]models.RedirectRule{{Platform: "symbian", URL: "https://example.com"}}))
+	assert.Error(t, Validate([]models.RedirectRule{{Language: "1en", URL: "https://example.com"}}))
+	assert.Error(t, Validate([]models.RedirectRule{{Query: map[string]string{"": "x"}, URL: "https://example.com"}}))
+	assert.Error(t, Validate(make([]models.RedirectRule, MaxRules+1)))
+}
+
+func TestPick(t *testing.T) {
+	destinations := []models.Destination{
+		{ID: "a", URL: "https://example.com/a", Weight: 3},
+		{ID: "paused", URL: "https://example.com/p", Weight: 0},
+		{ID: "b", URL: "https://example.com/b", Weight: 1},
+	}
+	require.Equal(t, 4, TotalWeight(destinations))
+	counts := make(map[string]int)
+	for n := 0; n < TotalWeight(destinations); n++ {
+		d, ok := Pick(destinations, n)
+		require.True(t, ok)
+		counts[d.ID]++
+	}
+	assert.Equal(t, map[string]int{"a": 3, "b": 1}, counts)
+	_, ok := Pick(destinations, 4)
+	assert.False(t, ok)
+
+	_, ok = Find(destinations, "paused")
+	assert.False(t, ok)
+	d, ok := Find(destinations, "b")
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/b", d.URL)
+}
+
+func TestNormalizeDestinations(t *testing.T) {
+	destinations := []models.Destination{{URL: "https://example.com/a", Weight: 1}, {ID: "b", URL: "https://example.com/b"}}
+	require.NoError(t, NormalizeDestinations(destinations))
+	assert.Equal(t, "1", destinations[0].ID)
+
+	assert.ErrorIs(t, NormalizeDestinations([]models.Destination{{URL: "https://example.com", Weight: 0}}), ErrNoWeight)
+	assert.Error(t, NormalizeDestinations([]models.Destination{{URL: "https://example.com", Weight: -1}}))
+	assert.Error(t, NormalizeDestinations([]models.Destination{{ID: "a b", URL: "https://example.com", Weight: 1}}))
+	assert.Error(t, NormalizeDestinations([]models.Destination{
+		{ID: "a", URL: "https://example.com/1", Weight: 1},
+		{ID: "a", URL: "https://example.com/2", Weight: 1},
+	}))
+}
+
+func TestForward(t *testing.T) {
+	query := url.Values{"ref": {"tw"}, "lang": {"en"}}
+	tests := []struct {
+		name     string
+		location string
+		options  models.LinkOptions
+		suffix   string
+		query    url.Values
+		want     string
+		err      error
+	}{
+		{name: "disabled", location: "https://example.com/a?lang=de", query: query, want: "https://example.com/a?lang=de"},
+		{name: "merge keeps destination", location: "https://example.com/a?lang=de", options: models.LinkOptions{PassQuery: QueryMerge}, query: query, want: "https://example.com/a?lang=de&ref=tw"},
+		{name: "override", location: "https://example.com/a?lang=de", options: models.LinkOptions{PassQuery: QueryOverride}, query: query, want: "https://example.com/a?lang=en&ref=tw"},
+		{name: "path", location: "https://example.com/base/?x=1#top", options: models.LinkOptions{PassPath: true}, suffix: "/docs/page", want: "https://example.com/base/docs/page?x=1#top"},
+		{name: "path trailing slash", location: "https://example.com", options: models.LinkOptions{PassPath: true}, suffix: "/docs/", want: "https://example.com/docs/"},
+		{name: "path escaped", location: "https://example.com/base", options: models.LinkOptions{PassPath: true}, suffix: "/a b", want: "https://example.com/base/a%20b"},
+		{name: "path not allowed", location: "https://example.com", suffix: "/docs", err: ErrPathNotAllowed},
+		{name: "path traversal", location: "https://example.com/base", options: models.LinkOptions{PassPath: true}, suffix: "/../admin", err: ErrPathTraversal},
+		{name: "empty path", location: "https://example.com/a", suffix: "/", want: "https://example.com/a"},
+		{
+			name:     "utm keeps destination",
+			location: "https://example.com/a?utm_source=site",
+			options:  models.LinkOptions{UTM: &models.UTM{Source: "mail", Campaign: "spring"}},
+			want:     "https://example.com/a?utm_campaign=spring&utm_source=site",
+		},
+		{
+			name:     "override replaces utm",
+			location: "https://example.com/a",
+			options:  models.LinkOptions{PassQuery: QueryOverride, UTM: &models.UTM{Source: "mail"}},
+			query:    url.Values{"utm_source": {"qr"}},
+			want:     "https://example.com/a?utm_source=qr",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Forward(tt.location, tt.options, tt.suffix, tt.query)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateForward(t *testing.T) {
+	assert.NoError(t, ValidateForward(models.LinkOptions{PassQuery: QueryMerge, UTM: &models.UTM{Source: "mail"}}))
+	assert.Error(t, ValidateForward(models.LinkOptions{PassQuery: "append"}))
+	assert.Error(t, ValidateForward(models.LinkOptions{UTM: &models.UTM{Term: strings.Repeat("x", maxUTMLen+1)}}))
+}
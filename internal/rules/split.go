@@ -0,0 +1,101 @@
+// Модуль разделения трафика ссылки между вариантами адреса перехода.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+)
+
+const (
+	// MaxDestinations максимальное количество вариантов адреса перехода
+	MaxDestinations = 20
+	// MaxWeight максимальный вес варианта
+	MaxWeight = 10000
+	// maxDestinationIDLen максимальная длина названия варианта
+	maxDestinationIDLen = 32
+)
+
+// ErrNoWeight все варианты приостановлены
+var ErrNoWeight = errors.New("at least one destination must have a positive weight")
+
+// NormalizeDestinations проверка вариантов без адресов перехода, их проверяет вызывающий.
+// Вариантам без названия назначается их номер, начиная с 1.
+func NormalizeDestinations(destinations []models.Destination) error {
+	if len(destinations) == 0 {
+		return nil
+	}
+	if len(destinations) > MaxDestinations {
+		return fmt.Errorf("at most %d destinations are allowed", MaxDestinations)
+	}
+	seen := make(map[string]bool, len(destinations))
+	for i := range destinations {
+		d := &destinations[i]
+		if d.ID == "" {
+			d.ID = strconv.Itoa(i + 1)
+		}
+		if err := validateDestination(*d); err != nil {
+			return fmt.Errorf("destination %d: %w", i+1, err)
+		}
+		if seen[d.ID] {
+			return fmt.Errorf("destination %d: duplicate id %q", i+1, d.ID)
+		}
+		seen[d.ID] = true
+	}
+	if TotalWeight(destinations) == 0 {
+		return ErrNoWeight
+	}
+	return nil
+}
+
+// validateDestination проверка одного варианта
+func validateDestination(d models.Destination) error {
+	if d.URL == "" {
+		return errors.New("url is empty")
+	}
+	if d.Weight < 0 || d.Weight > MaxWeight {
+		return fmt.Errorf("weight must be between 0 and %d", MaxWeight)
+	}
+	if len(d.ID) > maxDestinationIDLen {
+		return fmt.Errorf("id is longer than %d characters", maxDestinationIDLen)
+	}
+	for _, r := range d.ID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("id %q may contain only latin letters, digits, - and _", d.ID)
+		}
+	}
+	return nil
+}
+
+// TotalWeight сумма весов вариантов
+func TotalWeight(destinations []models.Destination) int {
+	total := 0
+	for _, d := range destinations {
+		total += d.Weight
+	}
+	return total
+}
+
+// Pick выбор варианта по числу n из [0, TotalWeight): каждому варианту соответствует
+// отрезок длиной в его вес, поэтому при равномерном n варианты выбираются пропорционально весам
+func Pick(destinations []models.Destination, n int) (models.Destination, bool) {
+	for _, d := range destinations {
+		if n < d.Weight {
+			return d, true
+		}
+		n -= d.Weight
+	}
+	return models.Destination{}, false
+}
+
+// Find активный вариант по названию, например сохраненный у посетителя
+func Find(destinations []models.Destination, id string) (models.Destination, bool) {
+	for _, d := range destinations {
+		if d.ID == id && d.Weight > 0 {
+			return d, true
+		}
+	}
+	return models.Destination{}, false
+}
@@ -0,0 +1,112 @@
+// Модуль журнала изменений ссылок в файловом хранилище.
+// События дописываются в отдельный файл построчно в формате JSON в порядке их ID.
+// Когда строк в файле становится вдвое больше хранимых событий, файл перезаписывается только ими.
+package fs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+)
+
+// changesFileSuffix суффикс файла журнала изменений
+const changesFileSuffix = ".events"
+
+// readChanges чтение последних models.ChangesRetention событий журнала изменений и количества строк в файле,
+// отсутствующий файл означает пустой журнал
+func readChanges(path string) ([]models.ChangeEvent, int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var events []models.ChangeEvent
+	lines := 0
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var event models.ChangeEvent
+		if err := decoder.Decode(&event); err != nil {
+			return nil, 0, err
+		}
+		lines++
+		events = append(events, event)
+		if len(events) > 2*models.ChangesRetention {
+			events = append([]models.ChangeEvent(nil), events[len(events)-models.ChangesRetention:]...)
+		}
+	}
+	if len(events) > models.ChangesRetention {
+		events = events[len(events)-models.ChangesRetention:]
+	}
+	return events, lines, nil
+}
+
+// appendChanges дописывание в файл событий, записанных хранилищем в памяти после последнего сохраненного
+func (s *FSStorage) appendChanges() error {
+	s.changesMutex.Lock()
+	defer s.changesMutex.Unlock()
+	events, err := s.MemoryStorage.GetChanges(context.Background(), s.savedChange, math.MaxInt)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	encoder := json.NewEncoder(s.changes)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		s.savedChange = event.ID
+		s.changesLines++
+	}
+	if s.changesLines > 2*models.ChangesRetention {
+		return s.compactChanges()
+	}
+	return nil
+}
+
+// compactChanges перезапись файла журнала изменений событиями, которые хранит хранилище в памяти.
+// Файл заменяется через временный, чтобы не оставить его поврежденным. Вызывается под changesMutex.
+func (s *FSStorage) compactChanges() error {
+	events, err := s.MemoryStorage.GetChanges(context.Background(), 0, math.MaxInt)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + changesFileSuffix + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path+changesFileSuffix); err != nil {
+		return err
+	}
+	changes, err := os.OpenFile(s.path+changesFileSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.changes.Close()
+	s.changes = changes
+	s.changesLines = len(events)
+	return nil
+}
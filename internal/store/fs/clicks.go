@@ -0,0 +1,71 @@
+// Модуль учета переходов в файловом хранилище.
+// Каждый переход дописывается в отдельный файл строкой JSON с текущими значениями счетчиков,
+// поэтому счетчики переживают аварийное завершение без перезаписи вспомогательных данных
+// на каждый переход. Файл очищается при сохранении вспомогательных данных, в которые входят счетчики.
+package fs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/EvgeniyBudaev/shortener/internal/store/memory"
+)
+
+// clicksFileSuffix суффикс файла журнала переходов
+const clicksFileSuffix = ".clicks"
+
+// clickRecord строка журнала переходов: значения счетчиков ссылки после перехода
+type clickRecord struct {
+	ID                string `json:"id"`
+	Clicks            int64  `json:"clicks"`
+	Destination       string `json:"destination,omitempty"`
+	DestinationClicks int64  `json:"destination_clicks,omitempty"`
+}
+
+// replayClicks восстановление счетчиков из журнала переходов. В журнале записаны значения,
+// а не приращения, поэтому строки, уже учтенные во вспомогательных данных, ничего не меняют.
+func replayClicks(path string, storage *memory.MemoryStorage) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var record clickRecord
+		if err := decoder.Decode(&record); err != nil {
+			return err
+		}
+		storage.RestoreClicks(record.ID, record.Clicks, record.Destination, record.DestinationClicks)
+	}
+	return nil
+}
+
+// RecordClick метод учета перехода с записью значений счетчиков в журнал переходов.
+// Если переход поставил в очередь событие, вспомогательные данные с доставками сохраняются сразу.
+func (s *FSStorage) RecordClick(ctx context.Context, id string, destination string) (int64, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.clicksMutex.Lock()
+	defer s.clicksMutex.Unlock()
+	lastDelivery := s.MemoryStorage.LastDeliveryID()
+	if _, err := s.MemoryStorage.RecordClick(ctx, id, destination); err != nil {
+		return 0, err
+	}
+	record := clickRecord{ID: id, Destination: destination}
+	record.Clicks, record.DestinationClicks = s.MemoryStorage.ClickCounts(id, destination)
+	if s.MemoryStorage.LastDeliveryID() != lastDelivery {
+		return record.Clicks, s.writeState()
+	}
+	if err := json.NewEncoder(s.clicks).Encode(record); err != nil {
+		return 0, err
+	}
+	return record.Clicks, nil
+}
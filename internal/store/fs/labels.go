@@ -0,0 +1,44 @@
+// Модуль сохранения меток и коллекций ссылок в файловом хранилище.
+// Они хранятся во вспомогательных данных, которые сохраняются после каждого изменения.
+package fs
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// SetTags метод замены меток ссылки
+func (s *FSStorage) SetTags(ctx *gin.Context, id string, userID string, tags []string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if err := s.MemoryStorage.SetTags(ctx, id, userID, tags); err != nil {
+		return err
+	}
+	if err := s.appendChanges(); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// CreateCollection метод создания коллекции
+func (s *FSStorage) CreateCollection(ctx *gin.Context, userID string, name string) error {
+	if err := s.MemoryStorage.CreateCollection(ctx, userID, name); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// UpdateCollection метод изменения состава коллекции
+func (s *FSStorage) UpdateCollection(ctx *gin.Context, userID string, name string, add []string, remove []string) error {
+	if err := s.MemoryStorage.UpdateCollection(ctx, userID, name, add, remove); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// DeleteCollection метод удаления коллекции
+func (s *FSStorage) DeleteCollection(ctx *gin.Context, userID string, name string) error {
+	if err := s.MemoryStorage.DeleteCollection(ctx, userID, name); err != nil {
+		return err
+	}
+	return s.saveState()
+}
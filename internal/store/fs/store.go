@@ -2,24 +2,45 @@
 package fs
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/EvgeniyBudaev/shortener/internal/models"
 	"github.com/EvgeniyBudaev/shortener/internal/store/memory"
 	"github.com/gin-gonic/gin"
 	"io"
+	"log"
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
+// stateFileSuffix суффикс файла со вспомогательными данными хранилища
+const stateFileSuffix = ".state"
+
 // FSStorage описывает структуру файлового хранилища
 type FSStorage struct {
 	countMutex sync.Mutex
+	stateMutex sync.Mutex
+	// writeMutex упорядочивает изменения ссылок: изменение в памяти и запись в файлы выполняются
+	// под ним вместе, поэтому версии записи дописываются в файл в порядке изменений.
+	// События изменения записываются раньше версии записи, поэтому после сбоя между ними
+	// в файле не остается изменения без события.
+	writeMutex sync.Mutex
 	path       string
 	*memory.MemoryStorage
 	sr *StorageReader
 	sw *StorageWriter
+	// changes файл журнала изменений, savedChange - ID последнего записанного в него события,
+	// changesLines - количество строк в файле
+	changes      *os.File
+	changesMutex sync.Mutex
+	savedChange  int64
+	changesLines int
+	// clicks файл журнала переходов, clicksMutex упорядочивает запись в него и его очистку
+	clicks      *os.File
+	clicksMutex sync.Mutex
 }
 
 // NewFileStorage функция-констукртор
@@ -39,6 +60,38 @@ func NewFileStorage(filename string) (*FSStorage, error) {
 		return nil, err
 	}
 
+	state, err := os.ReadFile(filename + stateFileSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(state) > 0 {
+		if err := storage.UnmarshalState(state); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := replayClicks(filename+clicksFileSuffix, storage); err != nil {
+		return nil, err
+	}
+	clicks, err := os.OpenFile(filename+clicksFileSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	events, changesLines, err := readChanges(filename + changesFileSuffix)
+	if err != nil {
+		return nil, err
+	}
+	storage.LoadChanges(events)
+	var savedChange int64
+	if len(events) > 0 {
+		savedChange = events[len(events)-1].ID
+	}
+	changes, err := os.OpenFile(filename+changesFileSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
 	sw, err := NewStorageWriter(filename)
 	if err != nil {
 		return nil, err
@@ -49,24 +102,50 @@ func NewFileStorage(filename string) (*FSStorage, error) {
 		MemoryStorage: storage,
 		sr:            sr,
 		sw:            sw,
+		changes:       changes,
+		savedChange:   savedChange,
+		changesLines:  changesLines,
+		clicks:        clicks,
 	}, nil
 }
 
-// PutBatch метод обновления батча
+// PutBatch метод обновления батча с записью в файл. Батч сохраняет хранилище в памяти целиком
+// или не сохраняет ничего, в файл записываются только ссылки, созданные этим вызовом.
 func (s *FSStorage) PutBatch(ctx *gin.Context, urls []models.URLBatchReq, userID string) ([]models.URLBatchRes, error) {
-	result := make([]models.URLBatchRes, 0)
-
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	existed := make(map[string]bool, len(urls))
 	for _, url := range urls {
-		id, err := s.Put(ctx, url.CorrelationID, url.OriginalURL, userID)
+		link, err := s.MemoryStorage.Get(ctx, url.CorrelationID)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, models.URLBatchRes{
-			CorrelationID: url.CorrelationID,
-			ShortURL:      id,
-		})
+		existed[url.CorrelationID] = link.OriginalURL != ""
+	}
+	lastDelivery := s.MemoryStorage.LastDeliveryID()
+	result, err := s.MemoryStorage.PutBatch(ctx, urls, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendChanges(); err != nil {
+		return nil, err
+	}
+	if err := s.saveDeliveries(lastDelivery); err != nil {
+		return nil, err
+	}
+	for _, url := range result {
+		if url.ShortURL != url.CorrelationID || existed[url.ShortURL] {
+			continue
+		}
+		existed[url.ShortURL] = true
+		link, err := s.MemoryStorage.Get(ctx, url.ShortURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.appendLink(link); err != nil {
+			return nil, err
+		}
 	}
-
 	return result, nil
 }
 
@@ -75,16 +154,185 @@ func (s *FSStorage) Ping() error {
 	return nil
 }
 
-// Close метод закрытия соединения
+// Close метод закрытия соединения, вспомогательные данные сохраняются вместе со счетчиками
+// переходов, после чего журнал переходов очищается
 func (s *FSStorage) Close() {
+	if err := s.saveState(); err != nil {
+		log.Printf("Error saving storage state: %v", err)
+	}
 	s.sw.file.Close()
+	s.changes.Close()
+	s.clicks.Close()
 }
 
 // DeleteStorageFile метод удаления файла в файловом хранилище
 func (s *FSStorage) DeleteStorageFile() error {
+	for _, suffix := range []string{stateFileSuffix, changesFileSuffix, clicksFileSuffix} {
+		if err := os.Remove(s.path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 	return os.Remove(s.path)
 }
 
+// saveState метод сохранения вспомогательных данных хранилища.
+// Файл перезаписывается целиком через временный файл, чтобы не оставить его поврежденным.
+// Сохраненные данные включают счетчики переходов, поэтому журнал переходов после этого очищается;
+// переходы на время сохранения приостанавливаются, чтобы не потерять записанные между ними.
+func (s *FSStorage) saveState() error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.clicksMutex.Lock()
+	defer s.clicksMutex.Unlock()
+	return s.writeState()
+}
+
+// writeState запись вспомогательных данных, вызывается под stateMutex и clicksMutex
+func (s *FSStorage) writeState() error {
+	data, err := s.MemoryStorage.MarshalState()
+	if err != nil {
+		return err
+	}
+	tmp := s.path + stateFileSuffix + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path+stateFileSuffix); err != nil {
+		return err
+	}
+	return s.clicks.Truncate(0)
+}
+
+// RevokeToken метод отзыва токена
+func (s *FSStorage) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if err := s.MemoryStorage.RevokeToken(ctx, tokenID, expiresAt); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// UpdateURL метод изменения исходного URL ссылки. Новая версия записи дописывается в файл,
+// история сохраняется во вспомогательных данных.
+func (s *FSStorage) UpdateURL(ctx *gin.Context, id string, userID string, originalURL string) (string, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	link, err := s.MemoryStorage.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	updated, err := s.MemoryStorage.UpdateURL(ctx, id, userID, originalURL)
+	if err != nil || link.OriginalURL == originalURL {
+		return updated, err
+	}
+	link.OriginalURL = originalURL
+	if err := s.appendChanges(); err != nil {
+		return "", err
+	}
+	if err := s.appendLink(link); err != nil {
+		return "", err
+	}
+	return id, s.saveState()
+}
+
+// SetRules метод замены правил перехода ссылки, новая версия записи дописывается в файл
+func (s *FSStorage) SetRules(ctx *gin.Context, id string, userID string, rules []models.RedirectRule) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if err := s.MemoryStorage.SetRules(ctx, id, userID, rules); err != nil {
+		return err
+	}
+	link, err := s.MemoryStorage.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.appendChanges(); err != nil {
+		return err
+	}
+	return s.appendLink(link)
+}
+
+// SetDestinations метод замены вариантов адреса перехода, новая версия записи дописывается в файл
+func (s *FSStorage) SetDestinations(ctx *gin.Context, id string, userID string, destinations []models.Destination, sticky bool) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if err := s.MemoryStorage.SetDestinations(ctx, id, userID, destinations, sticky); err != nil {
+		return err
+	}
+	link, err := s.MemoryStorage.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.appendChanges(); err != nil {
+		return err
+	}
+	return s.appendLink(link)
+}
+
+// DeleteMany метод пометки ссылок удаленными с записью в файл.
+// Права проверяет хранилище в памяти, в файл записываются только ссылки, удаленные этим вызовом.
+func (s *FSStorage) DeleteMany(ctx *gin.Context, ids models.DeleteUserURLsReq, userID string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	alive := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		link, err := s.MemoryStorage.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		alive[id] = link.OriginalURL != "" && !link.Deleted
+	}
+	lastDelivery := s.MemoryStorage.LastDeliveryID()
+	if err := s.MemoryStorage.DeleteMany(ctx, ids, userID); err != nil {
+		return err
+	}
+	if err := s.appendChanges(); err != nil {
+		return err
+	}
+	if err := s.saveDeliveries(lastDelivery); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if !alive[id] {
+			continue
+		}
+		link, err := s.MemoryStorage.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if link.Deleted {
+			alive[id] = false
+			if err := s.appendLink(link); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveDeliveries сохранение вспомогательных данных, если после доставки lastDelivery
+// хранилище в памяти поставило в очередь новые доставки событий
+func (s *FSStorage) saveDeliveries(lastDelivery int64) error {
+	if s.MemoryStorage.LastDeliveryID() == lastDelivery {
+		return nil
+	}
+	return s.saveState()
+}
+
+// ExpireLinks метод отметки ссылок с истекшим сроком действия, события дописываются в журнал изменений,
+// отметки и доставки событий сохраняются
+func (s *FSStorage) ExpireLinks(ctx context.Context, now time.Time) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	count, err := s.MemoryStorage.ExpireLinks(ctx, now)
+	if err != nil || count == 0 {
+		return count, err
+	}
+	if err := s.appendChanges(); err != nil {
+		return count, err
+	}
+	return count, s.saveState()
+}
+
 // StorageReader структура хранилища на чтение
 type StorageReader struct {
 	file    *os.File
@@ -115,7 +363,15 @@ func (sr *StorageReader) ReadFromFile() (map[string]models.URLRecordMemory, erro
 		if err != nil {
 			return nil, err
 		}
-		records[r.ShortURL] = models.URLRecordMemory{OriginalURL: r.OriginalURL, UserID: r.UserID}
+		records[r.ShortURL] = models.URLRecordMemory{
+			OriginalURL:  r.OriginalURL,
+			UserID:       r.UserID,
+			Options:      r.Options,
+			CreatedAt:    r.CreatedAt,
+			Deleted:      r.Deleted,
+			PasswordHash: r.PasswordHash,
+			WorkspaceID:  r.WorkspaceID,
+		}
 	}
 
 	return records, nil
@@ -155,17 +411,41 @@ func (sw *StorageWriter) AppendToFile(r *models.URLRecordFS) error {
 	return sw.encoder.Encode(&r)
 }
 
-// Put метод обновления
-func (s *FSStorage) Put(ctx *gin.Context, id string, url string, userID string) (string, error) {
-	id, err := s.MemoryStorage.Put(ctx, id, url, userID)
+// Put метод сохранения ссылки с записью в файл
+func (s *FSStorage) Put(ctx *gin.Context, link models.Link) (string, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now().UTC()
+	}
+	lastDelivery := s.MemoryStorage.LastDeliveryID()
+	id, err := s.MemoryStorage.Put(ctx, link)
 	if err != nil {
-		return "", err
+		return id, err
+	}
+	link.ID = id
+	if err := s.appendChanges(); err != nil {
+		return id, err
 	}
+	if err := s.saveDeliveries(lastDelivery); err != nil {
+		return id, err
+	}
+	return id, s.appendLink(link)
+}
+
+// appendLink запись текущей версии ссылки в конец файла, при чтении она заменяет предыдущие
+func (s *FSStorage) appendLink(link models.Link) error {
 	s.countMutex.Lock()
 	currentCount := s.UrlsCount
 	s.countMutex.Unlock()
-	return id, s.sw.AppendToFile(
-		&models.URLRecordFS{UUID: strconv.Itoa(currentCount), UserID: userID, URLRecord: models.URLRecord{
-			OriginalURL: url, ShortURL: id,
-		}})
+	return s.sw.AppendToFile(&models.URLRecordFS{
+		UUID:         strconv.Itoa(currentCount),
+		UserID:       link.UserID,
+		Options:      link.Options,
+		CreatedAt:    link.CreatedAt,
+		Deleted:      link.Deleted,
+		PasswordHash: link.PasswordHash,
+		WorkspaceID:  link.WorkspaceID,
+		URLRecord:    models.URLRecord{OriginalURL: link.OriginalURL, ShortURL: link.ID},
+	})
 }
@@ -0,0 +1,35 @@
+// Модуль сохранения подписок на события в файловом хранилище.
+// Подписки и очередь доставок хранятся во вспомогательных данных, поэтому события переживают перезапуск.
+package fs
+
+import (
+	"context"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateWebhook метод создания подписки
+func (s *FSStorage) CreateWebhook(ctx *gin.Context, webhook models.Webhook, userID string, secret string) error {
+	if err := s.MemoryStorage.CreateWebhook(ctx, webhook, userID, secret); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// DeleteWebhook метод удаления подписки
+func (s *FSStorage) DeleteWebhook(ctx *gin.Context, id string, userID string) error {
+	if err := s.MemoryStorage.DeleteWebhook(ctx, id, userID); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// UpdateDelivery метод сохранения результата попытки доставки. Отсрочка выбранных доставок
+// не сохраняется: после перезапуска они будут отправлены повторно.
+func (s *FSStorage) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	if err := s.MemoryStorage.UpdateDelivery(ctx, delivery); err != nil {
+		return err
+	}
+	return s.saveState()
+}
@@ -0,0 +1,41 @@
+// Модуль сохранения рабочих пространств в файловом хранилище.
+// Рабочие пространства и приглашения хранятся во вспомогательных данных.
+package fs
+
+import (
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateWorkspace метод создания рабочего пространства
+func (s *FSStorage) CreateWorkspace(ctx *gin.Context, workspace models.Workspace, ownerID string) error {
+	if err := s.MemoryStorage.CreateWorkspace(ctx, workspace, ownerID); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// SetWorkspaceMember метод изменения роли участника
+func (s *FSStorage) SetWorkspaceMember(ctx *gin.Context, workspaceID string, memberID string, role models.Role) error {
+	if err := s.MemoryStorage.SetWorkspaceMember(ctx, workspaceID, memberID, role); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// CreateInvite метод сохранения приглашения
+func (s *FSStorage) CreateInvite(ctx *gin.Context, invite models.WorkspaceInvite) error {
+	if err := s.MemoryStorage.CreateInvite(ctx, invite); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// AcceptInvite метод принятия приглашения
+func (s *FSStorage) AcceptInvite(ctx *gin.Context, tokenHash string, userID string) (models.Workspace, error) {
+	workspace, err := s.MemoryStorage.AcceptInvite(ctx, tokenHash, userID)
+	if err != nil {
+		return workspace, err
+	}
+	return workspace, s.saveState()
+}
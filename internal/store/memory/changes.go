@@ -0,0 +1,62 @@
+// Модуль журнала изменений ссылок в памяти
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+)
+
+// recordChange запись события в журнал изменений, вызывается под блокировкой вместе с изменением
+func (s *MemoryStorage) recordChange(event models.ChangeEvent) {
+	event.ID = 1
+	if len(s.changes) > 0 {
+		event.ID = s.changes[len(s.changes)-1].ID + 1
+	}
+	event.CreatedAt = time.Now().UTC()
+	s.changes = append(s.changes, event)
+	// старые события удаляются с запасом, чтобы не копировать журнал при каждой записи
+	if len(s.changes) > models.ChangesRetention+models.ChangesRetention/10 {
+		s.trimChanges()
+	}
+}
+
+// trimChanges удаление событий журнала изменений сверх models.ChangesRetention последних
+func (s *MemoryStorage) trimChanges() {
+	if len(s.changes) > models.ChangesRetention {
+		s.changes = append([]models.ChangeEvent(nil), s.changes[len(s.changes)-models.ChangesRetention:]...)
+	}
+}
+
+// LoadChanges восстановление журнала изменений, события должны быть упорядочены по ID
+func (s *MemoryStorage) LoadChanges(events []models.ChangeEvent) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.changes = events
+	s.trimChanges()
+}
+
+// GetChanges метод получения не более limit событий журнала изменений с ID больше since в порядке записи.
+// Если события сразу после since уже удалены или since больше ID последнего события (журнал начат заново,
+// например после перезапуска), возвращает ErrCursorExpired, since = 0 - чтение с самого старого события.
+func (s *MemoryStorage) GetChanges(ctx context.Context, since int64, limit int) ([]models.ChangeEvent, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var first, last int64
+	if len(s.changes) > 0 {
+		first, last = s.changes[0].ID, s.changes[len(s.changes)-1].ID
+	}
+	if since > 0 && (since < first-1 || since > last) {
+		return nil, models.ErrCursorExpired
+	}
+	start := sort.Search(len(s.changes), func(i int) bool { return s.changes[i].ID > since })
+	end := len(s.changes)
+	if end-start > limit {
+		end = start + limit
+	}
+	result := make([]models.ChangeEvent, end-start)
+	copy(result, s.changes[start:end])
+	return result, nil
+}
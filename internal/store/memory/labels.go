@@ -0,0 +1,158 @@
+// Модуль меток и коллекций ссылок в памяти
+package memory
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// linkCollections названия коллекций пользователя по ID ссылки
+func (s *MemoryStorage) linkCollections(userID string) map[string][]string {
+	result := make(map[string][]string)
+	for name, ids := range s.state.Collections[userID] {
+		for _, id := range ids {
+			result[id] = append(result[id], name)
+		}
+	}
+	for _, names := range result {
+		sort.Strings(names)
+	}
+	return result
+}
+
+// matchFilter запись подходит под фильтр
+func matchFilter(record models.URLRecord, filter models.URLFilter) bool {
+	for _, tag := range filter.Tags {
+		if !slices.Contains(record.Tags, tag) {
+			return false
+		}
+	}
+	return filter.Collection == "" || slices.Contains(record.Collections, filter.Collection)
+}
+
+// owned ссылка существует, не удалена и принадлежит пользователю
+func (s *MemoryStorage) owned(id string, userID string) bool {
+	record, ok := s.urls[id]
+	return ok && record.UserID == userID && !record.Deleted
+}
+
+// SetTags метод замены меток ссылки владельцем или редактором ее рабочего пространства
+func (s *MemoryStorage) SetTags(ctx *gin.Context, id string, userID string, tags []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := models.AccessError(s.access(id, userID)); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		delete(s.state.Tags, id)
+	} else {
+		tags = slices.Clone(tags)
+		sort.Strings(tags)
+		s.state.Tags[id] = tags
+	}
+	s.recordChange(models.ChangeEvent{Type: models.EventLinkUpdated, LinkID: id, UserID: userID, Field: models.FieldTags})
+	return nil
+}
+
+// GetTags метод получения меток пользователя с количеством ссылок
+func (s *MemoryStorage) GetTags(ctx *gin.Context, userID string) ([]models.LabelRes, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	counts := make(map[string]int)
+	for id, tags := range s.state.Tags {
+		if !s.owned(id, userID) {
+			continue
+		}
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+	return labels(counts), nil
+}
+
+// CreateCollection метод создания пустой коллекции
+func (s *MemoryStorage) CreateCollection(ctx *gin.Context, userID string, name string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	collections, ok := s.state.Collections[userID]
+	if !ok {
+		collections = make(map[string][]string)
+		s.state.Collections[userID] = collections
+	}
+	if _, ok := collections[name]; ok {
+		return models.ErrCollectionExists
+	}
+	collections[name] = []string{}
+	return nil
+}
+
+// UpdateCollection метод добавления и удаления ссылок коллекции.
+// Добавлять можно только доступные пользователю неудаленные ссылки, иначе изменения не применяются.
+func (s *MemoryStorage) UpdateCollection(ctx *gin.Context, userID string, name string, add []string, remove []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ids, ok := s.state.Collections[userID][name]
+	if !ok {
+		return models.ErrNotFound
+	}
+	for _, id := range add {
+		if view, _ := s.access(id, userID); !view {
+			return models.ErrNotFound
+		}
+	}
+	for _, id := range add {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	ids = slices.DeleteFunc(ids, func(id string) bool {
+		return slices.Contains(remove, id)
+	})
+	s.state.Collections[userID][name] = ids
+	return nil
+}
+
+// DeleteCollection метод удаления коллекции, ссылки при этом не удаляются
+func (s *MemoryStorage) DeleteCollection(ctx *gin.Context, userID string, name string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.state.Collections[userID][name]; !ok {
+		return models.ErrNotFound
+	}
+	delete(s.state.Collections[userID], name)
+	if len(s.state.Collections[userID]) == 0 {
+		delete(s.state.Collections, userID)
+	}
+	return nil
+}
+
+// GetCollections метод получения коллекций пользователя с количеством неудаленных ссылок
+func (s *MemoryStorage) GetCollections(ctx *gin.Context, userID string) ([]models.LabelRes, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	counts := make(map[string]int)
+	for name, ids := range s.state.Collections[userID] {
+		counts[name] = 0
+		for _, id := range ids {
+			if view, _ := s.access(id, userID); view {
+				counts[name]++
+			}
+		}
+	}
+	return labels(counts), nil
+}
+
+// labels список названий с количеством ссылок, упорядоченный по названию
+func labels(counts map[string]int) []models.LabelRes {
+	result := make([]models.LabelRes, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, models.LabelRes{Name: name, URLs: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -2,89 +2,450 @@
 package memory
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/EvgeniyBudaev/shortener/internal/models"
 	"github.com/gin-gonic/gin"
+	"strings"
 	"sync"
+	"time"
 )
 
 // MemoryStorage стукртура хранилища в памяти
 type MemoryStorage struct {
-	mux       *sync.Mutex
-	urls      map[string]models.URLRecordMemory
+	mux  *sync.Mutex
+	urls map[string]models.URLRecordMemory
+	// ids идентификаторы неудаленных записей по ключу urlKey
+	ids       map[string]string
+	state     State
 	UrlsCount int
+	// changes журнал изменений ссылок. Он не входит в State: файловое хранилище дописывает его в отдельный файл.
+	changes []models.ChangeEvent
+}
+
+// State вспомогательные данные хранилища помимо текущих записей URL
+type State struct {
+	RevokedTokens map[string]time.Time `json:"revoked_tokens"`
+	// History предыдущие исходные URL по ID ссылки
+	History map[string][]models.URLVersion `json:"history"`
+	// Clicks количество переходов по ID ссылки
+	Clicks map[string]int64 `json:"clicks"`
+	// DestinationClicks количество переходов по ID ссылки и названию варианта адреса перехода
+	DestinationClicks map[string]map[string]int64 `json:"destination_clicks"`
+	// Tags метки по ID ссылки
+	Tags map[string][]string `json:"tags"`
+	// Collections коллекции по ID пользователя: название - ID входящих в нее ссылок
+	Collections map[string]map[string][]string `json:"collections"`
+	// Workspaces рабочие пространства по ID
+	Workspaces map[string]Workspace `json:"workspaces"`
+	// Invites действующие приглашения по хэшу токена
+	Invites map[string]models.WorkspaceInvite `json:"invites"`
+	// Webhooks подписки на события ссылок по ID
+	Webhooks map[string]Webhook `json:"webhooks"`
+	// Deliveries доставки событий подпискам по ID
+	Deliveries map[int64]Delivery `json:"deliveries"`
+	// NextDeliveryID последний выданный ID доставки
+	NextDeliveryID int64 `json:"next_delivery_id"`
+	// Expired ID ссылок с истекшим сроком действия, о которых отправлено событие
+	Expired map[string]bool `json:"expired"`
+}
+
+// init инициализация незаполненных коллекций состояния
+func (st *State) init() {
+	if st.RevokedTokens == nil {
+		st.RevokedTokens = make(map[string]time.Time)
+	}
+	if st.History == nil {
+		st.History = make(map[string][]models.URLVersion)
+	}
+	if st.Clicks == nil {
+		st.Clicks = make(map[string]int64)
+	}
+	if st.DestinationClicks == nil {
+		st.DestinationClicks = make(map[string]map[string]int64)
+	}
+	if st.Tags == nil {
+		st.Tags = make(map[string][]string)
+	}
+	if st.Collections == nil {
+		st.Collections = make(map[string]map[string][]string)
+	}
+	if st.Workspaces == nil {
+		st.Workspaces = make(map[string]Workspace)
+	}
+	if st.Invites == nil {
+		st.Invites = make(map[string]models.WorkspaceInvite)
+	}
+	if st.Webhooks == nil {
+		st.Webhooks = make(map[string]Webhook)
+	}
+	if st.Deliveries == nil {
+		st.Deliveries = make(map[int64]Delivery)
+	}
+	if st.Expired == nil {
+		st.Expired = make(map[string]bool)
+	}
+}
+
+// urlKey ключ поиска уже сокращенного URL: ссылки на один исходный URL не повторяются
+// в пределах домена коротких ссылок, домен входит в ID ссылки префиксом "домен/"
+func urlKey(id string, originalURL string) string {
+	if domain, _, ok := strings.Cut(id, "/"); ok {
+		return domain + " " + originalURL
+	}
+	return originalURL
 }
 
 // NewMemoryStorage функция-конструктор
 func NewMemoryStorage(records map[string]models.URLRecordMemory) (*MemoryStorage, error) {
-	return &MemoryStorage{
+	storage := &MemoryStorage{
 		mux:       &sync.Mutex{},
 		urls:      records,
+		ids:       make(map[string]string, len(records)),
 		UrlsCount: len(records),
-	}, nil
+	}
+	for id, record := range records {
+		if !record.Deleted {
+			storage.ids[urlKey(id, record.OriginalURL)] = id
+		}
+	}
+	storage.state.init()
+	return storage, nil
+}
+
+// MarshalState сериализация вспомогательных данных хранилища
+func (s *MemoryStorage) MarshalState() ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return json.Marshal(s.state)
 }
 
-// Put метод обновления счетчика URL
-func (s *MemoryStorage) Put(ctx *gin.Context, id string, url string, userID string) (string, error) {
+// UnmarshalState восстановление вспомогательных данных хранилища
+func (s *MemoryStorage) UnmarshalState(data []byte) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.urls[id] = models.URLRecordMemory{
-		OriginalURL: url,
-		UserID:      userID,
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return err
 	}
+	s.state.init()
+	return nil
+}
+
+// Put метод сохранения ссылки, для уже сокращенного на том же домене URL возвращает существующий ID.
+// Занятый другой ссылкой ID не перезаписывается.
+func (s *MemoryStorage) Put(ctx *gin.Context, link models.Link) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if existing, ok := s.ids[urlKey(link.ID, link.OriginalURL)]; ok {
+		return existing, models.ErrURLConflict
+	}
+	if _, ok := s.urls[link.ID]; ok {
+		return "", models.ErrIDConflict
+	}
+	s.put(link)
+	return link.ID, nil
+}
+
+// put сохранение новой ссылки, вызывается под блокировкой после проверки конфликтов
+func (s *MemoryStorage) put(link models.Link) {
+	createdAt := link.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	s.urls[link.ID] = models.URLRecordMemory{
+		OriginalURL:  link.OriginalURL,
+		UserID:       link.UserID,
+		Options:      link.Options,
+		CreatedAt:    createdAt,
+		PasswordHash: link.PasswordHash,
+		WorkspaceID:  link.WorkspaceID,
+	}
+	s.ids[urlKey(link.ID, link.OriginalURL)] = link.ID
 	s.UrlsCount += 1
-	return id, nil
+	s.recordChange(models.ChangeEvent{Type: models.EventLinkCreated, LinkID: link.ID, UserID: link.UserID, OriginalURL: link.OriginalURL})
+	s.enqueueEvent(link.UserID, models.WebhookEvent{Type: models.EventLinkCreated, LinkID: link.ID, OriginalURL: link.OriginalURL})
 }
 
-// Get метод для получения URL
-func (s *MemoryStorage) Get(ctx *gin.Context, id string) (string, error) {
+// Get метод для получения ссылки, для неизвестного ID возвращает пустую ссылку
+func (s *MemoryStorage) Get(ctx *gin.Context, id string) (models.Link, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	originalURL := s.urls[id]
-	return originalURL.OriginalURL, nil
+	record, ok := s.urls[id]
+	if !ok {
+		return models.Link{}, nil
+	}
+	return models.Link{
+		ID:           id,
+		OriginalURL:  record.OriginalURL,
+		UserID:       record.UserID,
+		Options:      record.Options,
+		CreatedAt:    record.CreatedAt,
+		Deleted:      record.Deleted,
+		Clicks:       s.state.Clicks[id],
+		PasswordHash: record.PasswordHash,
+		WorkspaceID:  record.WorkspaceID,
+	}, nil
 }
 
-// GetAllByUserID метод получения всех записей по ID пользователя
-func (s *MemoryStorage) GetAllByUserID(ctx *gin.Context, userID string) ([]models.URLRecord, error) {
+// RecordClick метод учета перехода по ссылке, непустой destination учитывает переход на вариант адреса.
+// Возвращает количество переходов с учетом этого. Переход, на котором достигнут порог подписки
+// владельца ссылки, ставит в очередь событие link.click_threshold.
+func (s *MemoryStorage) RecordClick(ctx context.Context, id string, destination string) (int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.state.Clicks[id]++
+	if destination != "" {
+		if s.state.DestinationClicks[id] == nil {
+			s.state.DestinationClicks[id] = make(map[string]int64)
+		}
+		s.state.DestinationClicks[id][destination]++
+	}
+	clicks := s.state.Clicks[id]
+	if record, ok := s.urls[id]; ok {
+		s.enqueueEvent(record.UserID, models.WebhookEvent{
+			Type: models.EventLinkClickThreshold, LinkID: id, OriginalURL: record.OriginalURL, Clicks: clicks,
+		})
+	}
+	return clicks, nil
+}
+
+// ClickCounts количество переходов по ссылке и по ее варианту адреса destination
+func (s *MemoryStorage) ClickCounts(id string, destination string) (int64, int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.state.Clicks[id], s.state.DestinationClicks[id][destination]
+}
+
+// RestoreClicks восстановление счетчиков переходов, меньшие значения текущих не применяются
+func (s *MemoryStorage) RestoreClicks(id string, clicks int64, destination string, destinationClicks int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if clicks > s.state.Clicks[id] {
+		s.state.Clicks[id] = clicks
+	}
+	if destination == "" || destinationClicks <= s.state.DestinationClicks[id][destination] {
+		return
+	}
+	if s.state.DestinationClicks[id] == nil {
+		s.state.DestinationClicks[id] = make(map[string]int64)
+	}
+	s.state.DestinationClicks[id][destination] = destinationClicks
+}
+
+// GetDestinationClicks метод получения количества переходов по названию варианта адреса
+func (s *MemoryStorage) GetDestinationClicks(ctx *gin.Context, id string) (map[string]int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	result := make(map[string]int64, len(s.state.DestinationClicks[id]))
+	for destination, clicks := range s.state.DestinationClicks[id] {
+		result[destination] = clicks
+	}
+	return result, nil
+}
+
+// GetAllByUserID метод получения записей пользователя или его рабочего пространства
+// с метками и коллекциями, подходящих под фильтр
+func (s *MemoryStorage) GetAllByUserID(ctx *gin.Context, userID string, filter models.URLFilter) ([]models.URLRecord, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if filter.WorkspaceID != "" && s.role(filter.WorkspaceID, userID) == "" {
+		return nil, models.ErrNotFound
+	}
+	collections := s.linkCollections(userID)
 	result := make([]models.URLRecord, 0)
 	for id, url := range s.urls {
-		if url.UserID == userID {
-			s.mux.Lock()
-			defer s.mux.Unlock()
-
-			result = append(result, models.URLRecord{
-				ShortURL:    id,
-				OriginalURL: url.OriginalURL,
-			})
+		if url.Deleted {
+			continue
+		}
+		if filter.WorkspaceID != "" && url.WorkspaceID != filter.WorkspaceID ||
+			filter.WorkspaceID == "" && url.UserID != userID {
+			continue
+		}
+		record := models.URLRecord{
+			ShortURL:    id,
+			OriginalURL: url.OriginalURL,
+			Tags:        append([]string(nil), s.state.Tags[id]...),
+			Collections: collections[id],
+			WorkspaceID: url.WorkspaceID,
 		}
+		if matchFilter(record, filter) {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+// GetStats метод получения количества URL и пользователей
+func (s *MemoryStorage) GetStats(ctx *gin.Context) (models.StatsRes, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	users := make(map[string]struct{})
+	urls := 0
+	for _, url := range s.urls {
+		if url.Deleted {
+			continue
+		}
+		urls++
+		users[url.UserID] = struct{}{}
+	}
+	return models.StatsRes{URLs: urls, Users: len(users)}, nil
+}
+
+// UpdateURL метод изменения исходного URL ссылки владельцем или редактором ее рабочего пространства,
+// предыдущий URL сохраняется в истории. Если новый URL уже сокращен, возвращает ID существующей ссылки и ErrURLConflict.
+func (s *MemoryStorage) UpdateURL(ctx *gin.Context, id string, userID string, originalURL string) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := models.AccessError(s.access(id, userID)); err != nil {
+		return "", err
+	}
+	record := s.urls[id]
+	if record.OriginalURL == originalURL {
+		return id, nil
+	}
+	if existing, ok := s.ids[urlKey(id, originalURL)]; ok {
+		return existing, models.ErrURLConflict
+	}
+
+	history := s.state.History[id]
+	s.state.History[id] = append(history, models.URLVersion{
+		Version:     len(history) + 1,
+		OriginalURL: record.OriginalURL,
+		ReplacedAt:  time.Now().UTC(),
+	})
+	delete(s.ids, urlKey(id, record.OriginalURL))
+	s.ids[urlKey(id, originalURL)] = id
+	record.OriginalURL = originalURL
+	s.urls[id] = record
+	s.recordChange(models.ChangeEvent{
+		Type: models.EventLinkUpdated, LinkID: id, UserID: userID, OriginalURL: originalURL, Field: models.FieldOriginalURL,
+	})
+	return id, nil
+}
+
+// SetRules метод замены правил перехода ссылки владельцем или редактором ее рабочего пространства
+func (s *MemoryStorage) SetRules(ctx *gin.Context, id string, userID string, rules []models.RedirectRule) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := models.AccessError(s.access(id, userID)); err != nil {
+		return err
+	}
+	record := s.urls[id]
+	record.Options.Rules = rules
+	s.urls[id] = record
+	s.recordChange(models.ChangeEvent{Type: models.EventLinkUpdated, LinkID: id, UserID: userID, Field: models.FieldRules})
+	return nil
+}
+
+// SetDestinations метод замены вариантов адреса перехода владельцем или редактором
+// рабочего пространства ссылки. Счетчики переходов на варианты сохраняются.
+func (s *MemoryStorage) SetDestinations(ctx *gin.Context, id string, userID string, destinations []models.Destination, sticky bool) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := models.AccessError(s.access(id, userID)); err != nil {
+		return err
+	}
+	record := s.urls[id]
+	record.Options.Destinations = destinations
+	record.Options.Sticky = sticky && len(destinations) > 0
+	s.urls[id] = record
+	s.recordChange(models.ChangeEvent{Type: models.EventLinkUpdated, LinkID: id, UserID: userID, Field: models.FieldDestinations})
+	return nil
+}
+
+// GetHistory метод получения предыдущих исходных URL ссылки, от новых к старым
+func (s *MemoryStorage) GetHistory(ctx *gin.Context, id string, userID string) ([]models.URLVersion, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if view, _ := s.access(id, userID); !view {
+		return nil, models.ErrNotFound
+	}
+	history := s.state.History[id]
+	result := make([]models.URLVersion, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		result = append(result, history[i])
 	}
 	return result, nil
 }
 
-// DeleteMany метод по удалению URL по ID пользователя
+// DeleteMany метод по удалению URL владельцем или редактором их рабочего пространства.
+// Ссылки помечаются удаленными, а исходный URL освобождается для повторного сокращения.
 func (s *MemoryStorage) DeleteMany(ctx *gin.Context, ids models.DeleteUserURLsReq, userID string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	for _, id := range ids {
-		if url, ok := s.urls[id]; ok && url.UserID == userID {
-			delete(s.urls, id)
+		if _, edit := s.access(id, userID); edit {
+			url := s.urls[id]
+			url.Deleted = true
+			s.urls[id] = url
+			if key := urlKey(id, url.OriginalURL); s.ids[key] == id {
+				delete(s.ids, key)
+			}
+			s.recordChange(models.ChangeEvent{Type: models.EventLinkDeleted, LinkID: id, UserID: userID, OriginalURL: url.OriginalURL})
+			s.enqueueEvent(url.UserID, models.WebhookEvent{Type: models.EventLinkDeleted, LinkID: id, OriginalURL: url.OriginalURL})
 		}
 	}
 	return nil
 }
 
-// PutBatch метод по обновлению батча по ID пользователя
+// ExpireLinks метод отметки ссылок, срок действия которых истек к моменту now, с записью в журнал изменений
+// и постановкой в очередь события link.expired. Событие по каждой ссылке ставится один раз, возвращает количество ссылок.
+func (s *MemoryStorage) ExpireLinks(ctx context.Context, now time.Time) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	count := 0
+	for id, url := range s.urls {
+		if url.Deleted || s.state.Expired[id] || !url.Options.Expired(now) {
+			continue
+		}
+		s.state.Expired[id] = true
+		s.recordChange(models.ChangeEvent{Type: models.EventLinkExpired, LinkID: id, OriginalURL: url.OriginalURL})
+		s.enqueueEvent(url.UserID, models.WebhookEvent{Type: models.EventLinkExpired, LinkID: id, OriginalURL: url.OriginalURL})
+		count++
+	}
+	return count, nil
+}
+
+// PutBatch метод по обновлению батча по ID пользователя. Для уже сокращенного URL возвращается
+// существующий ID. Если ID хотя бы одной новой ссылки занят, возвращает ErrIDConflict и ничего не сохраняет.
 func (s *MemoryStorage) PutBatch(ctx *gin.Context, urls []models.URLBatchReq, userID string) ([]models.URLBatchRes, error) {
-	result := make([]models.URLBatchRes, 0)
+	s.mux.Lock()
+	defer s.mux.Unlock()
 
+	result := make([]models.URLBatchRes, 0, len(urls))
+	// created ID новых ссылок по ключу urlKey, batchIDs - занятые ими ID
+	created := make(map[string]string, len(urls))
+	batchIDs := make(map[string]bool, len(urls))
 	for _, url := range urls {
-		id, err := s.Put(ctx, url.CorrelationID, url.OriginalURL, userID)
-		if err != nil {
-			return nil, err
+		key := urlKey(url.CorrelationID, url.OriginalURL)
+		id, ok := s.ids[key]
+		if !ok {
+			id, ok = created[key]
 		}
-		result = append(result, models.URLBatchRes{
-			CorrelationID: id,
-			ShortURL:      id,
-		})
+		if !ok {
+			id = url.CorrelationID
+			if _, taken := s.urls[id]; taken || batchIDs[id] {
+				return nil, models.ErrIDConflict
+			}
+			created[key] = id
+			batchIDs[id] = true
+		}
+		result = append(result, models.URLBatchRes{CorrelationID: url.CorrelationID, ShortURL: id})
 	}
 
+	for _, url := range urls {
+		key := urlKey(url.CorrelationID, url.OriginalURL)
+		if id, ok := created[key]; !ok || id != url.CorrelationID {
+			continue
+		}
+		delete(created, key)
+		s.put(models.Link{
+			ID:          url.CorrelationID,
+			OriginalURL: url.OriginalURL,
+			UserID:      userID,
+			Options:     url.LinkOptions,
+		})
+	}
 	return result, nil
 }
 
@@ -96,3 +457,25 @@ func (s *MemoryStorage) Ping() error {
 // Close метод закрытия соединения с БД
 func (s *MemoryStorage) Close() {
 }
+
+// RevokeToken метод отзыва токена до момента expiresAt
+func (s *MemoryStorage) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	for id, exp := range s.state.RevokedTokens {
+		if exp.Before(now) {
+			delete(s.state.RevokedTokens, id)
+		}
+	}
+	s.state.RevokedTokens[tokenID] = expiresAt
+	return nil
+}
+
+// IsTokenRevoked метод проверки, отозван ли токен
+func (s *MemoryStorage) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	exp, ok := s.state.RevokedTokens[tokenID]
+	return ok && exp.After(time.Now()), nil
+}
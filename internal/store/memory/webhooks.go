@@ -0,0 +1,179 @@
+// Модуль подписок на события ссылок и очереди их доставки в памяти
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxDeliveryLog количество последних доставок в журнале подписки
+const maxDeliveryLog = 100
+
+// Webhook подписка с владельцем и секретом подписи
+type Webhook struct {
+	models.Webhook
+	UserID string `json:"user_id"`
+	Secret string `json:"secret"`
+}
+
+// Delivery доставка с событием. Событие не входит в JSON доставки, отдаваемый пользователю,
+// поэтому для сохранения состояния хранится отдельно.
+type Delivery struct {
+	models.WebhookDelivery
+	Data models.WebhookEvent `json:"data"`
+}
+
+// delivery доставка с заполненным событием
+func (d Delivery) delivery() models.WebhookDelivery {
+	delivery := d.WebhookDelivery
+	delivery.Data = d.Data
+	return delivery
+}
+
+// CreateWebhook метод создания подписки
+func (s *MemoryStorage) CreateWebhook(ctx *gin.Context, webhook models.Webhook, userID string, secret string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.state.Webhooks[webhook.ID] = Webhook{Webhook: webhook, UserID: userID, Secret: secret}
+	return nil
+}
+
+// GetWebhooks метод получения подписок пользователя в порядке создания
+func (s *MemoryStorage) GetWebhooks(ctx *gin.Context, userID string) ([]models.Webhook, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	result := make([]models.Webhook, 0)
+	for _, webhook := range s.state.Webhooks {
+		if webhook.UserID == userID {
+			result = append(result, webhook.Webhook)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// DeleteWebhook метод удаления подписки вместе с ее доставками
+func (s *MemoryStorage) DeleteWebhook(ctx *gin.Context, id string, userID string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if webhook, ok := s.state.Webhooks[id]; !ok || webhook.UserID != userID {
+		return models.ErrNotFound
+	}
+	delete(s.state.Webhooks, id)
+	for deliveryID, delivery := range s.state.Deliveries {
+		if delivery.WebhookID == id {
+			delete(s.state.Deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+// enqueueEvent постановка события в очередь доставки подпискам пользователя userID,
+// вызывается под блокировкой вместе с изменением, вызвавшим событие
+func (s *MemoryStorage) enqueueEvent(userID string, event models.WebhookEvent) {
+	if userID == "" {
+		return
+	}
+	event.ID = uuid.NewString()
+	event.CreatedAt = time.Now().UTC()
+	for id, webhook := range s.state.Webhooks {
+		if webhook.UserID != userID || !webhook.Matches(event) {
+			continue
+		}
+		s.state.NextDeliveryID++
+		s.state.Deliveries[s.state.NextDeliveryID] = Delivery{
+			WebhookDelivery: models.WebhookDelivery{
+				ID:            s.state.NextDeliveryID,
+				WebhookID:     id,
+				Event:         event.Type,
+				Status:        models.DeliveryPending,
+				NextAttemptAt: event.CreatedAt,
+				CreatedAt:     event.CreatedAt,
+			},
+			Data: event,
+		}
+	}
+}
+
+// LastDeliveryID ID последней созданной доставки, по нему файловое хранилище определяет,
+// появились ли доставки, которые нужно сохранить
+func (s *MemoryStorage) LastDeliveryID() int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.state.NextDeliveryID
+}
+
+// ClaimDeliveries метод выборки не более limit доставок, время попытки которых наступило, в порядке создания.
+// Следующая попытка выбранных доставок откладывается на lease, чтобы они не были выбраны повторно
+// до сохранения результата.
+func (s *MemoryStorage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	result := make([]models.WebhookDelivery, 0)
+	for _, delivery := range s.state.Deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			result = append(result, delivery.delivery())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	for i := range result {
+		stored := s.state.Deliveries[result[i].ID]
+		stored.NextAttemptAt = now.Add(lease)
+		s.state.Deliveries[stored.ID] = stored
+		webhook := s.state.Webhooks[stored.WebhookID]
+		result[i].NextAttemptAt = stored.NextAttemptAt
+		result[i].URL, result[i].Secret = webhook.URL, webhook.Secret
+	}
+	return result, nil
+}
+
+// UpdateDelivery метод сохранения результата попытки доставки
+func (s *MemoryStorage) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	stored, ok := s.state.Deliveries[delivery.ID]
+	if !ok {
+		// подписка удалена во время отправки
+		return nil
+	}
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.LastStatus = delivery.LastStatus
+	stored.LastError = delivery.LastError
+	stored.NextAttemptAt = delivery.NextAttemptAt
+	s.state.Deliveries[delivery.ID] = stored
+	return nil
+}
+
+// GetDeliveries метод получения последних доставок подписки пользователя, от новых к старым
+func (s *MemoryStorage) GetDeliveries(ctx *gin.Context, webhookID string, userID string) ([]models.WebhookDelivery, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if webhook, ok := s.state.Webhooks[webhookID]; !ok || webhook.UserID != userID {
+		return nil, models.ErrNotFound
+	}
+	result := make([]models.WebhookDelivery, 0)
+	for _, delivery := range s.state.Deliveries {
+		if delivery.WebhookID == webhookID {
+			result = append(result, delivery.delivery())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID > result[j].ID })
+	if len(result) > maxDeliveryLog {
+		result = result[:maxDeliveryLog]
+	}
+	return result, nil
+}
@@ -0,0 +1,164 @@
+// Модуль рабочих пространств в памяти
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Workspace рабочее пространство: название и роли участников по ID пользователя
+type Workspace struct {
+	Name    string                 `json:"name"`
+	Members map[string]models.Role `json:"members"`
+}
+
+// role роль пользователя в рабочем пространстве, пустая для посторонних
+func (s *MemoryStorage) role(workspaceID string, userID string) models.Role {
+	return s.state.Workspaces[workspaceID].Members[userID]
+}
+
+// access права пользователя на неудаленную ссылку: автор ссылки и редакторы ее рабочего пространства
+// могут изменять ее, остальные участники - только просматривать
+func (s *MemoryStorage) access(id string, userID string) (view bool, edit bool) {
+	record, ok := s.urls[id]
+	if !ok || record.Deleted {
+		return false, false
+	}
+	if record.UserID == userID {
+		return true, true
+	}
+	if record.WorkspaceID == "" {
+		return false, false
+	}
+	role := s.role(record.WorkspaceID, userID)
+	return role != "", role.CanEdit()
+}
+
+// CreateWorkspace метод создания рабочего пространства, создатель становится его владельцем
+func (s *MemoryStorage) CreateWorkspace(ctx *gin.Context, workspace models.Workspace, ownerID string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.state.Workspaces[workspace.ID] = Workspace{
+		Name:    workspace.Name,
+		Members: map[string]models.Role{ownerID: models.RoleOwner},
+	}
+	return nil
+}
+
+// GetWorkspaces метод получения рабочих пространств пользователя с его ролями
+func (s *MemoryStorage) GetWorkspaces(ctx *gin.Context, userID string) ([]models.Workspace, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	result := make([]models.Workspace, 0)
+	for id, workspace := range s.state.Workspaces {
+		if role, ok := workspace.Members[userID]; ok {
+			result = append(result, models.Workspace{ID: id, Name: workspace.Name, Role: role})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// GetWorkspaceRole метод получения роли пользователя, пустая роль для посторонних
+func (s *MemoryStorage) GetWorkspaceRole(ctx *gin.Context, workspaceID string, userID string) (models.Role, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.role(workspaceID, userID), nil
+}
+
+// GetWorkspaceMembers метод получения участников рабочего пространства
+func (s *MemoryStorage) GetWorkspaceMembers(ctx *gin.Context, workspaceID string) ([]models.WorkspaceMember, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	workspace, ok := s.state.Workspaces[workspaceID]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	result := make([]models.WorkspaceMember, 0, len(workspace.Members))
+	for userID, role := range workspace.Members {
+		result = append(result, models.WorkspaceMember{UserID: userID, Role: role})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UserID < result[j].UserID
+	})
+	return result, nil
+}
+
+// SetWorkspaceMember метод изменения роли участника, пустая роль исключает его.
+// Последнего владельца нельзя понизить или исключить.
+func (s *MemoryStorage) SetWorkspaceMember(ctx *gin.Context, workspaceID string, memberID string, role models.Role) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	workspace, ok := s.state.Workspaces[workspaceID]
+	if !ok {
+		return models.ErrNotFound
+	}
+	current, ok := workspace.Members[memberID]
+	if !ok {
+		return models.ErrNotFound
+	}
+	if current == models.RoleOwner && role != models.RoleOwner {
+		owners := 0
+		for _, r := range workspace.Members {
+			if r == models.RoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			return models.ErrLastOwner
+		}
+	}
+	if role == "" {
+		delete(workspace.Members, memberID)
+	} else {
+		workspace.Members[memberID] = role
+	}
+	return nil
+}
+
+// CreateInvite метод сохранения приглашения, заодно удаляет просроченные
+func (s *MemoryStorage) CreateInvite(ctx *gin.Context, invite models.WorkspaceInvite) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	for hash, existing := range s.state.Invites {
+		if existing.ExpiresAt.Before(now) {
+			delete(s.state.Invites, hash)
+		}
+	}
+	if _, ok := s.state.Workspaces[invite.WorkspaceID]; !ok {
+		return models.ErrNotFound
+	}
+	s.state.Invites[invite.TokenHash] = invite
+	return nil
+}
+
+// AcceptInvite метод принятия приглашения. Приглашение одноразовое; роль уже состоящего
+// в рабочем пространстве пользователя не меняется.
+func (s *MemoryStorage) AcceptInvite(ctx *gin.Context, tokenHash string, userID string) (models.Workspace, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	invite, ok := s.state.Invites[tokenHash]
+	if !ok || invite.ExpiresAt.Before(time.Now()) {
+		return models.Workspace{}, models.ErrNotFound
+	}
+	workspace, ok := s.state.Workspaces[invite.WorkspaceID]
+	if !ok {
+		return models.Workspace{}, models.ErrNotFound
+	}
+	delete(s.state.Invites, tokenHash)
+	role, ok := workspace.Members[userID]
+	if !ok {
+		role = invite.Role
+		workspace.Members[userID] = role
+	}
+	return models.Workspace{ID: invite.WorkspaceID, Name: workspace.Name, Role: role}, nil
+}
@@ -0,0 +1,73 @@
+// Модуль журнала изменений ссылок в БД Postgres
+package postgres
+
+import (
+	"context"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// changesLockKey ключ рекомендательной блокировки записи в журнал изменений
+const changesLockKey = 0x6368616e676573
+
+// recordChanges запись событий в журнал изменений в транзакции изменения, непосредственно перед ее
+// фиксацией. Транзакции, пишущие в журнал, упорядочиваются блокировкой до фиксации, поэтому события
+// становятся видны читателям в порядке своих ID и чтение по курсору не пропускает событий.
+// События старше models.ChangesRetention последних удаляются в той же транзакции.
+func recordChanges(ctx context.Context, tx pgx.Tx, events ...models.ChangeEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, changesLockKey); err != nil {
+		return err
+	}
+	var lastID int64
+	for _, event := range events {
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO change_events (type, link_id, user_id, original_url, field) VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, event.Type, event.LinkID, event.UserID, event.OriginalURL, event.Field).Scan(&lastID); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM change_events WHERE id <= $1`, lastID-models.ChangesRetention)
+	return err
+}
+
+// GetChanges метод получения не более limit событий журнала изменений с ID больше since в порядке записи.
+// Если события после since могли быть удалены или since больше ID последнего события, возвращает ErrCursorExpired,
+// since = 0 - чтение с самого старого события.
+func (db *DBStore) GetChanges(ctx context.Context, since int64, limit int) ([]models.ChangeEvent, error) {
+	if since > 0 {
+		var expired bool
+		if err := db.conn.QueryRow(ctx, `
+			SELECT $1 < COALESCE(MAX(id), 0) - $2 OR $1 > COALESCE(MAX(id), 0) FROM change_events
+		`, since, models.ChangesRetention).Scan(&expired); err != nil {
+			return nil, err
+		}
+		if expired {
+			return nil, models.ErrCursorExpired
+		}
+	}
+	rows, err := db.conn.Query(ctx, `
+		SELECT id, type, link_id, user_id, original_url, field, created_at
+		FROM change_events WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.ChangeEvent, 0)
+	for rows.Next() {
+		var event models.ChangeEvent
+		if err := rows.Scan(&event.ID, &event.Type, &event.LinkID, &event.UserID, &event.OriginalURL, &event.Field, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
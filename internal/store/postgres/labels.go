@@ -0,0 +1,178 @@
+// Модуль меток и коллекций ссылок в БД Postgres
+package postgres
+
+import (
+	"errors"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// SetTags метод замены меток ссылки владельцем или редактором ее рабочего пространства.
+// Метки хранятся у автора ссылки; метки без ссылок не удаляются и не попадают в список меток пользователя.
+func (db *DBStore) SetTags(ctx *gin.Context, id string, userID string, tags []string) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	view, edit, ownerID, err := linkAccess(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if err := models.AccessError(view, edit); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM link_tags lt USING tags t
+		WHERE lt.tag_id = t.id AND lt.slug = $1 AND t.user_id = $2
+	`, id, ownerID); err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO tags (user_id, name) SELECT $1, unnest($2::text[])
+			ON CONFLICT (user_id, name) DO NOTHING
+		`, ownerID, tags); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO link_tags (tag_id, slug)
+			SELECT id, $3 FROM tags WHERE user_id = $1 AND name = ANY($2)
+		`, ownerID, tags, id); err != nil {
+			return err
+		}
+	}
+	if err := recordChanges(ctx, tx, models.ChangeEvent{
+		Type: models.EventLinkUpdated, LinkID: id, UserID: userID, Field: models.FieldTags,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetTags метод получения меток пользователя с количеством неудаленных ссылок
+func (db *DBStore) GetTags(ctx *gin.Context, userID string) ([]models.LabelRes, error) {
+	return db.queryLabels(ctx, `
+		SELECT t.name, COUNT(*)
+		FROM tags t
+		JOIN link_tags lt ON lt.tag_id = t.id
+		JOIN shortener s ON s.slug = lt.slug AND s.user_id = t.user_id
+		WHERE t.user_id = $1 AND s.deleted_flag = FALSE
+		GROUP BY t.name
+		ORDER BY t.name
+	`, userID)
+}
+
+// CreateCollection метод создания пустой коллекции
+func (db *DBStore) CreateCollection(ctx *gin.Context, userID string, name string) error {
+	tag, err := db.conn.Exec(ctx, `
+		INSERT INTO collections (user_id, name) VALUES ($1, $2)
+		ON CONFLICT (user_id, name) DO NOTHING
+	`, userID, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrCollectionExists
+	}
+	return nil
+}
+
+// UpdateCollection метод добавления и удаления ссылок коллекции.
+// Добавлять можно только доступные пользователю неудаленные ссылки, иначе изменения не применяются.
+func (db *DBStore) UpdateCollection(ctx *gin.Context, userID string, name string, add []string, remove []string) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var collectionID int64
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM collections WHERE user_id = $1 AND name = $2 FOR UPDATE
+	`, userID, name).Scan(&collectionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(add) > 0 {
+		var missing int
+		if err := tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM unnest($1::text[]) AS a(slug)
+			WHERE NOT EXISTS (
+				SELECT 1 FROM shortener s
+				WHERE s.slug = a.slug AND s.deleted_flag = FALSE AND `+visibleTo("s", "$2")+`
+			)
+		`, add, userID).Scan(&missing); err != nil {
+			return err
+		}
+		if missing > 0 {
+			return models.ErrNotFound
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO collection_links (collection_id, slug) SELECT $1, unnest($2::text[])
+			ON CONFLICT DO NOTHING
+		`, collectionID, add); err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM collection_links WHERE collection_id = $1 AND slug = ANY($2)
+		`, collectionID, remove); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteCollection метод удаления коллекции, ссылки при этом не удаляются
+func (db *DBStore) DeleteCollection(ctx *gin.Context, userID string, name string) error {
+	tag, err := db.conn.Exec(ctx, `DELETE FROM collections WHERE user_id = $1 AND name = $2`, userID, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// GetCollections метод получения коллекций пользователя с количеством неудаленных ссылок
+func (db *DBStore) GetCollections(ctx *gin.Context, userID string) ([]models.LabelRes, error) {
+	return db.queryLabels(ctx, `
+		SELECT c.name, COUNT(s.slug)
+		FROM collections c
+		LEFT JOIN collection_links cl ON cl.collection_id = c.id
+		LEFT JOIN shortener s ON s.slug = cl.slug AND s.deleted_flag = FALSE AND `+visibleTo("s", "c.user_id")+`
+		WHERE c.user_id = $1
+		GROUP BY c.name
+		ORDER BY c.name
+	`, userID)
+}
+
+// queryLabels выполнение запроса, возвращающего названия с количеством ссылок
+func (db *DBStore) queryLabels(ctx *gin.Context, query string, userID string) ([]models.LabelRes, error) {
+	rows, err := db.conn.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.LabelRes, 0)
+	for rows.Next() {
+		var label models.LabelRes
+		if err := rows.Scan(&label.Name, &label.URLs); err != nil {
+			return nil, err
+		}
+		result = append(result, label)
+	}
+	return result, rows.Err()
+}
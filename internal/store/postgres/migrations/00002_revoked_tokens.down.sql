@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP TABLE revoked_tokens;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE revoked_tokens(
+    token_id VARCHAR(255) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+COMMIT;
\ No newline at end of file
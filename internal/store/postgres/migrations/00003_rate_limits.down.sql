@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP TABLE rate_limits;
+
+COMMIT;
\ No newline at end of file
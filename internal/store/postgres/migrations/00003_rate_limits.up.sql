@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE rate_limits(
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener ALTER COLUMN original_url TYPE VARCHAR(255);
+
+COMMIT;
\ No newline at end of file
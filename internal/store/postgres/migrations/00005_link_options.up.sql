@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener ADD COLUMN options JSONB NOT NULL DEFAULT '{}';
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP TABLE shortener_history;
+
+COMMIT;
\ No newline at end of file
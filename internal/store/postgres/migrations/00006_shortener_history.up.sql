@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE shortener_history(
+    slug VARCHAR(255) NOT NULL,
+    version INTEGER NOT NULL,
+    original_url TEXT NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (slug, version)
+);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener DROP COLUMN clicks;
+ALTER TABLE shortener DROP COLUMN created_at;
+
+COMMIT;
\ No newline at end of file
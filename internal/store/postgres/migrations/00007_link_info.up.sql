@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener ADD COLUMN created_at TIMESTAMPTZ;
+ALTER TABLE shortener ALTER COLUMN created_at SET DEFAULT now();
+ALTER TABLE shortener ADD COLUMN clicks BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener DROP COLUMN password_hash;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener ADD COLUMN password_hash TEXT;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+DROP TABLE collection_links;
+DROP TABLE collections;
+DROP TABLE link_tags;
+DROP TABLE tags;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,32 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE tags(
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE link_tags(
+    tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    slug VARCHAR(255) NOT NULL,
+    PRIMARY KEY (tag_id, slug)
+);
+CREATE INDEX link_tags_slug_idx ON link_tags(slug);
+
+CREATE TABLE collections(
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE collection_links(
+    collection_id BIGINT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+    slug VARCHAR(255) NOT NULL,
+    PRIMARY KEY (collection_id, slug)
+);
+CREATE INDEX collection_links_slug_idx ON collection_links(slug);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+DROP INDEX shortener_workspace_idx;
+ALTER TABLE shortener DROP COLUMN workspace_id;
+DROP TABLE workspace_invites;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
+
+COMMIT;
\ No newline at end of file
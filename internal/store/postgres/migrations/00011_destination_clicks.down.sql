@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP TABLE destination_clicks;
+
+COMMIT;
\ No newline at end of file
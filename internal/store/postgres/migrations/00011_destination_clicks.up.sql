@@ -0,0 +1,10 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE destination_clicks(
+    slug VARCHAR(255) NOT NULL,
+    destination_id VARCHAR(32) NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (slug, destination_id)
+);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
+
+COMMIT;
\ No newline at end of file
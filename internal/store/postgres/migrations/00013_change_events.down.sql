@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP TABLE change_events;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,13 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE change_events(
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(32) NOT NULL,
+    link_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL DEFAULT '',
+    original_url TEXT NOT NULL DEFAULT '',
+    field VARCHAR(32) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP INDEX shortener_slug_key;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+CREATE UNIQUE INDEX shortener_slug_key ON shortener(slug);
+
+COMMIT;
\ No newline at end of file
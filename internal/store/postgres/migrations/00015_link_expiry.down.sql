@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX shortener_expires_idx;
+ALTER TABLE shortener DROP COLUMN expire_notified;
+ALTER TABLE shortener DROP COLUMN expires_at;
+
+COMMIT;
\ No newline at end of file
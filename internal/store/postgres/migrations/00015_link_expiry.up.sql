@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener ADD COLUMN expires_at TIMESTAMPTZ;
+ALTER TABLE shortener ADD COLUMN expire_notified BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX shortener_expires_idx ON shortener(expires_at) WHERE expire_notified = FALSE;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+DROP INDEX shortener_original_url_key;
+ALTER TABLE shortener ADD PRIMARY KEY (original_url);
+
+COMMIT;
\ No newline at end of file
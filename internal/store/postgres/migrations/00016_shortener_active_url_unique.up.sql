@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener DROP CONSTRAINT shortener_pkey;
+CREATE UNIQUE INDEX shortener_original_url_key ON shortener(original_url) WHERE deleted_flag = FALSE;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX shortener_domain_url_key;
+ALTER TABLE shortener DROP COLUMN domain;
+CREATE UNIQUE INDEX shortener_original_url_key ON shortener(original_url) WHERE deleted_flag = FALSE;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE shortener ADD COLUMN domain TEXT GENERATED ALWAYS AS (
+    CASE WHEN strpos(slug, '/') > 0 THEN split_part(slug, '/', 1) ELSE '' END
+) STORED;
+DROP INDEX shortener_original_url_key;
+CREATE UNIQUE INDEX shortener_domain_url_key ON shortener(domain, original_url) WHERE deleted_flag = FALSE;
+
+COMMIT;
\ No newline at end of file
@@ -7,14 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/ratelimit"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"log"
 	"runtime"
+	"time"
 )
 
 // DBStore - Интерфейс работы с пулом соединений.
@@ -23,10 +26,30 @@ type DBStore struct {
 }
 
 // ErrDBInsertConflict Обнаружен конфликт в БД, необходимо его обработать.
-var ErrDBInsertConflict = errors.New("conflict insert into table, returned stored value")
+var ErrDBInsertConflict = models.ErrURLConflict
 
-// ErrURLDeleted Запрашиваемый URL удален.
-var ErrURLDeleted = errors.New("url is deleted")
+// slugConstraint уникальный индекс ID ссылок, urlConstraint - исходных URL неудаленных ссылок домена
+const (
+	slugConstraint = "shortener_slug_key"
+	urlConstraint  = "shortener_domain_url_key"
+)
+
+// uniqueViolation код ошибки Postgres о нарушении уникальности
+const uniqueViolation = "23505"
+
+// isUniqueViolation ошибка - нарушение уникального индекса constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == constraint
+}
+
+// insertError ошибка вставки ссылки: нарушение уникальности ID заменяется на ErrIDConflict
+func insertError(err error) error {
+	if isUniqueViolation(err, slugConstraint) {
+		return models.ErrIDConflict
+	}
+	return err
+}
 
 // NewPostgresStore Функция получения экземпляра DBStore.
 func NewPostgresStore(ctx context.Context, dsn string) (*DBStore, error) {
@@ -78,31 +101,124 @@ func (db *DBStore) Close() {
 	db.conn.Close()
 }
 
-// Get метод получения записи по ID
-func (db *DBStore) Get(ctx *gin.Context, id string) (string, error) {
-	row := db.conn.QueryRow(ctx,
-		"SELECT original_url, deleted_flag FROM shortener WHERE slug = $1", id)
-	var result string
-	var deleted bool
-	err := row.Scan(&result, &deleted)
+// Get метод получения ссылки по ID, для неизвестного ID возвращает пустую ссылку
+func (db *DBStore) Get(ctx *gin.Context, id string) (models.Link, error) {
+	row := db.conn.QueryRow(ctx, `
+		SELECT original_url, user_id, deleted_flag, options, created_at, clicks,
+			COALESCE(password_hash, ''), COALESCE(workspace_id, '')
+		FROM shortener WHERE slug = $1
+	`, id)
+	link := models.Link{ID: id}
+	var userID *string
+	var createdAt *time.Time
+	err := row.Scan(&link.OriginalURL, &userID, &link.Deleted, &link.Options, &createdAt, &link.Clicks, &link.PasswordHash, &link.WorkspaceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Link{}, nil
+	}
 	if err != nil {
-		return "", err
+		return models.Link{}, err
+	}
+	if userID != nil {
+		link.UserID = *userID
 	}
-	if deleted {
-		return "", ErrURLDeleted
+	if createdAt != nil {
+		link.CreatedAt = *createdAt
 	}
-	return result, nil
+	return link, nil
 }
 
-// GetAllByUserID метод получения всех записей по ID пользователя
-func (db *DBStore) GetAllByUserID(ctx *gin.Context, userID string) ([]models.URLRecord, error) {
+// RecordClick метод учета перехода по ссылке, непустой destination учитывает переход на вариант адреса.
+// Возвращает количество переходов с учетом этого. Переход, на котором достигнут порог подписки
+// владельца ссылки, в том же запросе ставит в очередь событие link.click_threshold.
+func (db *DBStore) RecordClick(ctx context.Context, id string, destination string) (int64, error) {
+	var clicks int64
+	err := db.conn.QueryRow(ctx, `
+		WITH link AS (
+			UPDATE shortener SET clicks = clicks + 1 WHERE slug = $1 RETURNING user_id, original_url, clicks
+		), queued AS (
+			INSERT INTO webhook_deliveries (webhook_id, event, payload)
+			SELECT w.id, $2, jsonb_build_object(
+				'id', $3::text, 'type', $2::text, 'link_id', $1::text,
+				'original_url', link.original_url, 'clicks', link.clicks, 'created_at', now()
+			)
+			FROM link JOIN webhooks w ON w.user_id = link.user_id
+			WHERE $2 = ANY(w.events) AND w.click_threshold = link.clicks
+		)
+		SELECT clicks FROM link
+	`, id, models.EventLinkClickThreshold, uuid.NewString()).Scan(&clicks)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil || destination == "" {
+		return clicks, err
+	}
+	_, err = db.conn.Exec(ctx, `
+		INSERT INTO destination_clicks (slug, destination_id, clicks) VALUES ($1, $2, 1)
+		ON CONFLICT (slug, destination_id) DO UPDATE SET clicks = destination_clicks.clicks + 1
+	`, id, destination)
+	return clicks, err
+}
+
+// GetDestinationClicks метод получения количества переходов по названию варианта адреса
+func (db *DBStore) GetDestinationClicks(ctx *gin.Context, id string) (map[string]int64, error) {
+	rows, err := db.conn.Query(ctx, `SELECT destination_id, clicks FROM destination_clicks WHERE slug = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+	for rows.Next() {
+		var destination string
+		var clicks int64
+		if err := rows.Scan(&destination, &clicks); err != nil {
+			return nil, err
+		}
+		result[destination] = clicks
+	}
+	return result, rows.Err()
+}
+
+// GetAllByUserID метод получения записей пользователя или его рабочего пространства
+// с метками и коллекциями, подходящих под фильтр
+func (db *DBStore) GetAllByUserID(ctx *gin.Context, userID string, filter models.URLFilter) ([]models.URLRecord, error) {
 	result := make([]models.URLRecord, 0)
 
+	if filter.WorkspaceID != "" {
+		role, err := db.GetWorkspaceRole(ctx, filter.WorkspaceID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			return nil, models.ErrNotFound
+		}
+	}
+
 	rows, err := db.conn.Query(ctx, `
-		SELECT slug, original_url
-		FROM shortener
-		WHERE user_id = $1 AND deleted_flag = FALSE
-	`, userID)
+		SELECT s.slug, s.original_url, COALESCE(s.workspace_id, ''),
+			ARRAY(
+				SELECT t.name FROM link_tags lt JOIN tags t ON t.id = lt.tag_id
+				WHERE lt.slug = s.slug AND t.user_id = s.user_id ORDER BY t.name
+			),
+			ARRAY(
+				SELECT c.name FROM collection_links cl JOIN collections c ON c.id = cl.collection_id
+				WHERE cl.slug = s.slug AND c.user_id = $1 ORDER BY c.name
+			)
+		FROM shortener s
+		WHERE s.deleted_flag = FALSE
+			AND CASE WHEN $4 = '' THEN s.user_id = $1 ELSE s.workspace_id = $4 END
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($2::text[]) AS f(name)
+				WHERE NOT EXISTS (
+					SELECT 1 FROM link_tags lt JOIN tags t ON t.id = lt.tag_id
+					WHERE lt.slug = s.slug AND t.user_id = s.user_id AND t.name = f.name
+				)
+			)
+			AND ($3 = '' OR EXISTS (
+				SELECT 1 FROM collection_links cl JOIN collections c ON c.id = cl.collection_id
+				WHERE cl.slug = s.slug AND c.user_id = $1 AND c.name = $3
+			))
+	`, userID, filter.Tags, filter.Collection, filter.WorkspaceID)
 	if err != nil {
 		return nil, err
 	}
@@ -110,95 +226,455 @@ func (db *DBStore) GetAllByUserID(ctx *gin.Context, userID string) ([]models.URL
 
 	for rows.Next() {
 		record := models.URLRecord{}
-		if err := rows.Scan(&record.ShortURL, &record.OriginalURL); err != nil {
+		if err := rows.Scan(&record.ShortURL, &record.OriginalURL, &record.WorkspaceID, &record.Tags, &record.Collections); err != nil {
 			return nil, err
 		}
+		if len(record.Tags) == 0 {
+			record.Tags = nil
+		}
+		if len(record.Collections) == 0 {
+			record.Collections = nil
+		}
 
 		result = append(result, record)
 	}
 
-	return result, nil
+	return result, rows.Err()
+}
+
+// GetStats метод получения количества URL и пользователей
+func (db *DBStore) GetStats(ctx *gin.Context) (models.StatsRes, error) {
+	var stats models.StatsRes
+	err := db.conn.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(DISTINCT user_id)
+		FROM shortener
+		WHERE deleted_flag = FALSE
+	`).Scan(&stats.URLs, &stats.Users)
+	return stats, err
 }
 
-// DeleteMany метод удаления записей по ID пользователя
+// DeleteMany метод удаления записей их владельцем или редактором их рабочего пространства,
+// недоступные пользователю и уже удаленные записи пропускаются
 func (db *DBStore) DeleteMany(ctx *gin.Context, ids models.DeleteUserURLsReq, userID string) error {
-	query := `
-		UPDATE shortener SET deleted_flag = TRUE
-		WHERE shortener.slug = $1 AND shortener.user_id = $2`
-	batch := &pgx.Batch{}
-	for _, url := range ids {
-		batch.Queue(query, url, userID)
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
 	}
-	batchResults := db.conn.SendBatch(ctx, batch)
-	defer batchResults.Close()
+	defer tx.Rollback(ctx)
 
-	for range ids {
-		_, err := batchResults.Exec()
-		if err != nil {
-			log.Printf("error executing: %v", err)
+	rows, err := tx.Query(ctx, `
+		UPDATE shortener SET deleted_flag = TRUE
+		WHERE shortener.slug = ANY($1) AND shortener.deleted_flag = FALSE AND `+editableBy("shortener", "$2")+`
+		RETURNING slug, original_url, COALESCE(user_id, '')
+	`, []string(ids), userID)
+	if err != nil {
+		return err
+	}
+	var events []models.ChangeEvent
+	var queued []queuedEvent
+	for rows.Next() {
+		event := models.ChangeEvent{Type: models.EventLinkDeleted, UserID: userID}
+		var ownerID string
+		if err := rows.Scan(&event.LinkID, &event.OriginalURL, &ownerID); err != nil {
+			rows.Close()
 			return err
 		}
+		events = append(events, event)
+		queued = append(queued, queuedEvent{userID: ownerID, event: models.WebhookEvent{
+			Type: models.EventLinkDeleted, LinkID: event.LinkID, OriginalURL: event.OriginalURL,
+		}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return nil
+	if err := recordChanges(ctx, tx, events...); err != nil {
+		return err
+	}
+	if err := enqueueEvents(ctx, tx, queued...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-// Put метод обновления записи по ID пользователя
-func (db *DBStore) Put(ctx *gin.Context, id string, url string, userID string) (string, error) {
-	var err error
+// ExpireLinks метод отметки ссылок, срок действия которых истек к моменту now, с записью в журнал
+// изменений и постановкой в очередь события link.expired. Событие по каждой ссылке ставится один раз, в том числе при
+// одновременной проверке несколькими экземплярами сервиса. Возвращает количество ссылок.
+func (db *DBStore) ExpireLinks(ctx context.Context, now time.Time) (int, error) {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
 
-	row := db.conn.QueryRow(ctx, `
-		INSERT INTO shortener VALUES ($1, $2, $3)
-		ON CONFLICT (original_url)
+	rows, err := tx.Query(ctx, `
+		UPDATE shortener SET expire_notified = TRUE
+		WHERE expires_at <= $1 AND expire_notified = FALSE AND deleted_flag = FALSE
+		RETURNING slug, original_url, COALESCE(user_id, '')
+	`, now)
+	if err != nil {
+		return 0, err
+	}
+	var events []models.ChangeEvent
+	var queued []queuedEvent
+	for rows.Next() {
+		event := models.WebhookEvent{Type: models.EventLinkExpired}
+		var ownerID string
+		if err := rows.Scan(&event.LinkID, &event.OriginalURL, &ownerID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, models.ChangeEvent{Type: models.EventLinkExpired, LinkID: event.LinkID, OriginalURL: event.OriginalURL})
+		queued = append(queued, queuedEvent{userID: ownerID, event: event})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := recordChanges(ctx, tx, events...); err != nil {
+		return 0, err
+	}
+	if err := enqueueEvents(ctx, tx, queued...); err != nil {
+		return 0, err
+	}
+	return len(queued), tx.Commit(ctx)
+}
+
+// Put метод сохранения ссылки, для уже сокращенного на том же домене URL возвращает существующий ID.
+// Удаленные ссылки не учитываются, их исходный URL можно сократить повторно.
+// Для занятого другой ссылкой ID возвращает ErrIDConflict.
+func (db *DBStore) Put(ctx *gin.Context, link models.Link) (string, error) {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var result string
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO shortener (slug, original_url, user_id, options, password_hash, workspace_id, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+		ON CONFLICT (domain, original_url) WHERE deleted_flag = FALSE
 		DO UPDATE SET
 			original_url=EXCLUDED.original_url
 		RETURNING slug
-	`, id, url, userID)
-	var result string
-	if err := row.Scan(&result); err != nil {
-		return "", err
+	`, link.ID, link.OriginalURL, link.UserID, link.Options, link.PasswordHash, link.WorkspaceID, link.Options.ExpiresAt).Scan(&result); err != nil {
+		return "", insertError(err)
 	}
 
-	if id != result {
-		err = ErrDBInsertConflict
+	if link.ID != result {
+		return result, ErrDBInsertConflict
 	}
-
-	return result, err
+	if err := recordChanges(ctx, tx, models.ChangeEvent{
+		Type: models.EventLinkCreated, LinkID: result, UserID: link.UserID, OriginalURL: link.OriginalURL,
+	}); err != nil {
+		return "", err
+	}
+	if err := enqueueEvents(ctx, tx, queuedEvent{userID: link.UserID, event: models.WebhookEvent{
+		Type: models.EventLinkCreated, LinkID: result, OriginalURL: link.OriginalURL,
+	}}); err != nil {
+		return "", err
+	}
+	return result, tx.Commit(ctx)
 }
 
 // PutBatch метод обновления батча по ID пользователя
 func (db *DBStore) PutBatch(ctx *gin.Context, urls []models.URLBatchReq, userID string) ([]models.URLBatchRes, error) {
 	query := `
-		INSERT INTO shortener VALUES (@slug, @originalUrl, @userID)
-		ON CONFLICT (original_url)
+		INSERT INTO shortener (slug, original_url, user_id, options, expires_at)
+		VALUES (@slug, @originalUrl, @userID, @options, @expiresAt)
+		ON CONFLICT (domain, original_url) WHERE deleted_flag = FALSE
 		DO UPDATE SET
 			original_url=EXCLUDED.original_url
 		RETURNING slug	
 	`
 	result := make([]models.URLBatchRes, 0)
 
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	batch := &pgx.Batch{}
 	for _, url := range urls {
 		args := pgx.NamedArgs{
 			"slug":        url.CorrelationID,
 			"originalUrl": url.OriginalURL,
 			"userID":      userID,
+			"options":     url.LinkOptions,
+			"expiresAt":   url.LinkOptions.ExpiresAt,
 		}
 		batch.Queue(query, args)
 	}
-	results := db.conn.SendBatch(ctx, batch)
-	defer results.Close()
+	results := tx.SendBatch(ctx, batch)
 
+	var events []models.ChangeEvent
+	var queued []queuedEvent
 	for _, url := range urls {
-		id, err := results.Exec()
-		if err != nil {
-			return nil, err
+		var id string
+		if err := results.QueryRow().Scan(&id); err != nil {
+			results.Close()
+			return nil, insertError(err)
 		}
 		result = append(result, models.URLBatchRes{
 			CorrelationID: url.CorrelationID,
-			ShortURL:      id.String(),
+			ShortURL:      id,
 		})
+		// для уже сокращенного URL возвращается существующий ID, такая ссылка не создается
+		if id == url.CorrelationID {
+			events = append(events, models.ChangeEvent{
+				Type: models.EventLinkCreated, LinkID: id, UserID: userID, OriginalURL: url.OriginalURL,
+			})
+			queued = append(queued, queuedEvent{userID: userID, event: models.WebhookEvent{
+				Type: models.EventLinkCreated, LinkID: id, OriginalURL: url.OriginalURL,
+			}})
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	if err := recordChanges(ctx, tx, events...); err != nil {
+		return nil, err
+	}
+	if err := enqueueEvents(ctx, tx, queued...); err != nil {
+		return nil, err
+	}
+	return result, tx.Commit(ctx)
+}
+
+// RevokeToken метод отзыва токена до момента expiresAt, заодно удаляет устаревшие записи
+func (db *DBStore) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if _, err := db.conn.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(ctx, `
+		INSERT INTO revoked_tokens (token_id, expires_at) VALUES ($1, $2)
+		ON CONFLICT (token_id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+	`, tokenID, expiresAt)
+	return err
+}
+
+// IsTokenRevoked метод проверки, отозван ли токен
+func (db *DBStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	err := db.conn.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE token_id = $1 AND expires_at > now())
+	`, tokenID).Scan(&revoked)
+	return revoked, err
+}
+
+// Allow общий для всех экземпляров сервиса ограничитель частоты запросов.
+// Корзина блокируется на время транзакции, время берется из БД, чтобы не зависеть от часов экземпляров.
+// Ограничение не превышает часа, поэтому корзины, не обновлявшиеся сутки, заведомо полные.
+func (db *DBStore) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (key) DO NOTHING
+	`, key, float64(limit.Count))
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	if tag.RowsAffected() == 1 {
+		// при появлении нового клиента удаляем давно восстановившиеся корзины
+		if _, err := tx.Exec(ctx, `DELETE FROM rate_limits WHERE updated_at < now() - interval '1 day'`); err != nil {
+			return ratelimit.Result{}, err
+		}
+	}
+	var tokens float64
+	var updated, now time.Time
+	if err := tx.QueryRow(ctx, `
+		SELECT tokens, updated_at, now() FROM rate_limits WHERE key = $1 FOR UPDATE
+	`, key).Scan(&tokens, &updated, &now); err != nil {
+		return ratelimit.Result{}, err
+	}
+	tokens, res := ratelimit.Take(tokens, updated, now, limit)
+	if _, err := tx.Exec(ctx, `
+		UPDATE rate_limits SET tokens = $2, updated_at = $3 WHERE key = $1
+	`, key, tokens, now); err != nil {
+		return ratelimit.Result{}, err
+	}
+	return res, tx.Commit(ctx)
+}
+
+// UpdateURL метод изменения исходного URL ссылки владельцем или редактором ее рабочего пространства,
+// предыдущий URL сохраняется в истории. Если новый URL уже сокращен, возвращает ID существующей ссылки и ErrURLConflict.
+func (db *DBStore) UpdateURL(ctx *gin.Context, id string, userID string, originalURL string) (string, error) {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	view, edit, _, err := linkAccess(ctx, tx, id, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := models.AccessError(view, edit); err != nil {
+		return "", err
+	}
+
+	var current string
+	err = tx.QueryRow(ctx, `
+		SELECT original_url FROM shortener
+		WHERE slug = $1 AND deleted_flag = FALSE
+		FOR UPDATE
+	`, id).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", models.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if current == originalURL {
+		return id, nil
+	}
+
+	if existing, err := urlOwner(ctx, tx, id, originalURL); err != nil || existing != "" {
+		return existing, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO shortener_history (slug, version, original_url)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2 FROM shortener_history WHERE slug = $1
+	`, id, current); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE shortener SET original_url = $2 WHERE slug = $1`, id, originalURL); err != nil {
+		// URL мог быть сокращен одновременно с проверкой выше
+		if isUniqueViolation(err, urlConstraint) {
+			tx.Rollback(ctx)
+			return urlOwner(ctx, db.conn, id, originalURL)
+		}
+		return "", err
+	}
+	if err := recordChanges(ctx, tx, models.ChangeEvent{
+		Type: models.EventLinkUpdated, LinkID: id, UserID: userID, OriginalURL: originalURL, Field: models.FieldOriginalURL,
+	}); err != nil {
+		return "", err
+	}
+	return id, tx.Commit(ctx)
+}
+
+// urlOwner ID неудаленной ссылки на originalURL на домене ссылки id с ошибкой ErrURLConflict,
+// пустая строка - URL на этом домене не сокращен
+func urlOwner(ctx context.Context, q querier, id string, originalURL string) (string, error) {
+	var existing string
+	err := q.QueryRow(ctx, `
+		SELECT slug FROM shortener
+		WHERE original_url = $1 AND deleted_flag = FALSE
+			AND domain = (SELECT domain FROM shortener WHERE slug = $2)
+	`, originalURL, id).Scan(&existing)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return existing, models.ErrURLConflict
+}
+
+// SetRules метод замены правил перехода ссылки владельцем или редактором ее рабочего пространства
+func (db *DBStore) SetRules(ctx *gin.Context, id string, userID string, rules []models.RedirectRule) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	view, edit, _, err := linkAccess(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if err := models.AccessError(view, edit); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		_, err = tx.Exec(ctx, `UPDATE shortener SET options = options - 'rules' WHERE slug = $1`, id)
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE shortener SET options = jsonb_set(options, '{rules}', $2::jsonb) WHERE slug = $1
+		`, id, rules)
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordChanges(ctx, tx, models.ChangeEvent{
+		Type: models.EventLinkUpdated, LinkID: id, UserID: userID, Field: models.FieldRules,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SetDestinations метод замены вариантов адреса перехода владельцем или редактором
+// рабочего пространства ссылки. Счетчики переходов на варианты сохраняются.
+func (db *DBStore) SetDestinations(ctx *gin.Context, id string, userID string, destinations []models.Destination, sticky bool) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	view, edit, _, err := linkAccess(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+	if err := models.AccessError(view, edit); err != nil {
+		return err
+	}
+	// пустые значения не попадают в JSON, поэтому прежние ключи удаляются и заменяются новыми
+	options := models.LinkOptions{Destinations: destinations, Sticky: sticky && len(destinations) > 0}
+	if _, err := tx.Exec(ctx, `
+		UPDATE shortener SET options = (options - 'destinations' - 'sticky') || $2::jsonb WHERE slug = $1
+	`, id, options); err != nil {
+		return err
+	}
+	if err := recordChanges(ctx, tx, models.ChangeEvent{
+		Type: models.EventLinkUpdated, LinkID: id, UserID: userID, Field: models.FieldDestinations,
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetHistory метод получения предыдущих исходных URL ссылки, от новых к старым
+func (db *DBStore) GetHistory(ctx *gin.Context, id string, userID string) ([]models.URLVersion, error) {
+	view, _, _, err := linkAccess(ctx, db.conn, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !view {
+		return nil, models.ErrNotFound
+	}
+
+	rows, err := db.conn.Query(ctx, `
+		SELECT version, original_url, replaced_at
+		FROM shortener_history
+		WHERE slug = $1
+		ORDER BY version DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.URLVersion, 0)
+	for rows.Next() {
+		var version models.URLVersion
+		if err := rows.Scan(&version.Version, &version.OriginalURL, &version.ReplacedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, version)
+	}
+	return result, rows.Err()
 }
@@ -0,0 +1,167 @@
+// Модуль подписок на события ссылок и очереди их доставки в БД Postgres
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxDeliveryLog количество последних доставок в журнале подписки
+const maxDeliveryLog = 100
+
+// CreateWebhook метод создания подписки
+func (db *DBStore) CreateWebhook(ctx *gin.Context, webhook models.Webhook, userID string, secret string) error {
+	_, err := db.conn.Exec(ctx, `
+		INSERT INTO webhooks (id, user_id, url, secret, events, click_threshold, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, webhook.ID, userID, webhook.URL, secret, webhook.Events, webhook.ClickThreshold, webhook.CreatedAt)
+	return err
+}
+
+// GetWebhooks метод получения подписок пользователя в порядке создания
+func (db *DBStore) GetWebhooks(ctx *gin.Context, userID string) ([]models.Webhook, error) {
+	rows, err := db.conn.Query(ctx, `
+		SELECT id, url, events, click_threshold, created_at FROM webhooks WHERE user_id = $1 ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Webhook, 0)
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &webhook.Events, &webhook.ClickThreshold, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, webhook)
+	}
+	return result, rows.Err()
+}
+
+// DeleteWebhook метод удаления подписки, ее доставки удаляются каскадно
+func (db *DBStore) DeleteWebhook(ctx *gin.Context, id string, userID string) error {
+	tag, err := db.conn.Exec(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// queuedEvent событие ссылки с пользователем, подпискам которого оно отправляется
+type queuedEvent struct {
+	userID string
+	event  models.WebhookEvent
+}
+
+// enqueueEvents постановка событий в очередь доставки подпискам их получателей в транзакции изменения,
+// вызвавшего события, поэтому события не теряются и не появляются без изменения
+func enqueueEvents(ctx context.Context, tx pgx.Tx, events ...queuedEvent) error {
+	batch := &pgx.Batch{}
+	now := time.Now().UTC()
+	for _, queued := range events {
+		if queued.userID == "" {
+			continue
+		}
+		event := queued.event
+		event.ID = uuid.NewString()
+		event.CreatedAt = now
+		batch.Queue(`
+			INSERT INTO webhook_deliveries (webhook_id, event, payload)
+			SELECT id, $2, $3::jsonb FROM webhooks
+			WHERE user_id = $1 AND $2 = ANY(events) AND ($2 <> $4 OR click_threshold = $5)
+		`, queued.userID, event.Type, event, models.EventLinkClickThreshold, event.Clicks)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	return tx.SendBatch(ctx, batch).Close()
+}
+
+// ClaimDeliveries метод выборки не более limit доставок, время попытки которых наступило, в порядке создания.
+// Следующая попытка выбранных доставок откладывается на lease, поэтому несколько экземпляров сервиса
+// не отправляют одну доставку одновременно.
+func (db *DBStore) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := db.conn.Query(ctx, `
+		WITH claimed AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM claimed, webhooks w
+		WHERE d.id = claimed.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.created_at, w.url, w.secret
+	`, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Data, &delivery.Status,
+			&delivery.Attempts, &delivery.CreatedAt, &delivery.URL, &delivery.Secret); err != nil {
+			return nil, err
+		}
+		delivery.NextAttemptAt = now.Add(lease)
+		result = append(result, delivery)
+	}
+	return result, rows.Err()
+}
+
+// UpdateDelivery метод сохранения результата попытки доставки
+func (db *DBStore) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	_, err := db.conn.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_status = $4, last_error = $5, next_attempt_at = $6
+		WHERE id = $1
+	`, delivery.ID, delivery.Status, delivery.Attempts, delivery.LastStatus, delivery.LastError, delivery.NextAttemptAt)
+	return err
+}
+
+// GetDeliveries метод получения последних доставок подписки пользователя, от новых к старым
+func (db *DBStore) GetDeliveries(ctx *gin.Context, webhookID string, userID string) ([]models.WebhookDelivery, error) {
+	var exists bool
+	if err := db.conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND user_id = $2)
+	`, webhookID, userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, models.ErrNotFound
+	}
+
+	rows, err := db.conn.Query(ctx, `
+		SELECT id, webhook_id, event, payload, status, attempts, last_status, last_error, next_attempt_at, created_at
+		FROM webhook_deliveries WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, webhookID, maxDeliveryLog)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Data, &delivery.Status, &delivery.Attempts,
+			&delivery.LastStatus, &delivery.LastError, &delivery.NextAttemptAt, &delivery.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, delivery)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,236 @@
+// Модуль рабочих пространств в БД Postgres
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// querier выполнение запроса в пуле соединений или в транзакции
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// visibleTo условие: ссылка с псевдонимом alias создана пользователем из параметра param
+// или принадлежит рабочему пространству, в котором он состоит
+func visibleTo(alias string, param string) string {
+	return fmt.Sprintf(`(%[1]s.user_id = %[2]s OR %[1]s.workspace_id IN (
+		SELECT workspace_id FROM workspace_members WHERE user_id = %[2]s))`, alias, param)
+}
+
+// editableBy условие: ссылку с псевдонимом alias может изменять пользователь из параметра param
+func editableBy(alias string, param string) string {
+	return fmt.Sprintf(`(%[1]s.user_id = %[2]s OR %[1]s.workspace_id IN (
+		SELECT workspace_id FROM workspace_members WHERE user_id = %[2]s AND role IN ('owner', 'editor')))`, alias, param)
+}
+
+// linkAccess права пользователя на неудаленную ссылку: просмотр, изменение и автор ссылки
+func linkAccess(ctx context.Context, q querier, id string, userID string) (view bool, edit bool, ownerID string, err error) {
+	err = q.QueryRow(ctx, `
+		SELECT COALESCE(s.user_id, ''),
+			COALESCE(s.user_id = $2, FALSE) OR m.role IS NOT NULL,
+			COALESCE(s.user_id = $2, FALSE) OR COALESCE(m.role IN ('owner', 'editor'), FALSE)
+		FROM shortener s
+		LEFT JOIN workspace_members m ON m.workspace_id = s.workspace_id AND m.user_id = $2
+		WHERE s.slug = $1 AND s.deleted_flag = FALSE
+	`, id, userID).Scan(&ownerID, &view, &edit)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, "", nil
+	}
+	return view, edit, ownerID, err
+}
+
+// CreateWorkspace метод создания рабочего пространства, создатель становится его владельцем
+func (db *DBStore) CreateWorkspace(ctx *gin.Context, workspace models.Workspace, ownerID string) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `INSERT INTO workspaces (id, name) VALUES ($1, $2)`, workspace.ID, workspace.Name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)
+	`, workspace.ID, ownerID, models.RoleOwner); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetWorkspaces метод получения рабочих пространств пользователя с его ролями
+func (db *DBStore) GetWorkspaces(ctx *gin.Context, userID string) ([]models.Workspace, error) {
+	rows, err := db.conn.Query(ctx, `
+		SELECT w.id, w.name, m.role
+		FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = $1
+		ORDER BY w.name, w.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Workspace, 0)
+	for rows.Next() {
+		var workspace models.Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role); err != nil {
+			return nil, err
+		}
+		result = append(result, workspace)
+	}
+	return result, rows.Err()
+}
+
+// GetWorkspaceRole метод получения роли пользователя, пустая роль для посторонних
+func (db *DBStore) GetWorkspaceRole(ctx *gin.Context, workspaceID string, userID string) (models.Role, error) {
+	var role models.Role
+	err := db.conn.QueryRow(ctx, `
+		SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2
+	`, workspaceID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// GetWorkspaceMembers метод получения участников рабочего пространства
+func (db *DBStore) GetWorkspaceMembers(ctx *gin.Context, workspaceID string) ([]models.WorkspaceMember, error) {
+	rows, err := db.conn.Query(ctx, `
+		SELECT user_id, role FROM workspace_members WHERE workspace_id = $1 ORDER BY user_id
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.WorkspaceMember, 0)
+	for rows.Next() {
+		var member models.WorkspaceMember
+		if err := rows.Scan(&member.UserID, &member.Role); err != nil {
+			return nil, err
+		}
+		result = append(result, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, models.ErrNotFound
+	}
+	return result, nil
+}
+
+// SetWorkspaceMember метод изменения роли участника, пустая роль исключает его.
+// Последнего владельца нельзя понизить или исключить.
+func (db *DBStore) SetWorkspaceMember(ctx *gin.Context, workspaceID string, memberID string, role models.Role) error {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// строки участников блокируются, чтобы два владельца не понизили друг друга одновременно
+	rows, err := tx.Query(ctx, `
+		SELECT user_id, role FROM workspace_members WHERE workspace_id = $1 FOR UPDATE
+	`, workspaceID)
+	if err != nil {
+		return err
+	}
+	var current models.Role
+	owners := 0
+	for rows.Next() {
+		var member models.WorkspaceMember
+		if err := rows.Scan(&member.UserID, &member.Role); err != nil {
+			rows.Close()
+			return err
+		}
+		if member.UserID == memberID {
+			current = member.Role
+		}
+		if member.Role == models.RoleOwner {
+			owners++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if current == "" {
+		return models.ErrNotFound
+	}
+	if current == models.RoleOwner && role != models.RoleOwner && owners == 1 {
+		return models.ErrLastOwner
+	}
+
+	if role == "" {
+		_, err = tx.Exec(ctx, `
+			DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2
+		`, workspaceID, memberID)
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE workspace_members SET role = $3 WHERE workspace_id = $1 AND user_id = $2
+		`, workspaceID, memberID, role)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// CreateInvite метод сохранения приглашения, заодно удаляет просроченные
+func (db *DBStore) CreateInvite(ctx *gin.Context, invite models.WorkspaceInvite) error {
+	if _, err := db.conn.Exec(ctx, `DELETE FROM workspace_invites WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(ctx, `
+		INSERT INTO workspace_invites (token_hash, workspace_id, role, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, invite.TokenHash, invite.WorkspaceID, invite.Role, invite.CreatedBy, invite.ExpiresAt)
+	return err
+}
+
+// AcceptInvite метод принятия приглашения. Приглашение одноразовое; роль уже состоящего
+// в рабочем пространстве пользователя не меняется.
+func (db *DBStore) AcceptInvite(ctx *gin.Context, tokenHash string, userID string) (models.Workspace, error) {
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return models.Workspace{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var workspace models.Workspace
+	var role models.Role
+	err = tx.QueryRow(ctx, `
+		DELETE FROM workspace_invites WHERE token_hash = $1 AND expires_at > now()
+		RETURNING workspace_id, role
+	`, tokenHash).Scan(&workspace.ID, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Workspace{}, models.ErrNotFound
+	}
+	if err != nil {
+		return models.Workspace{}, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO NOTHING
+	`, workspace.ID, userID, role); err != nil {
+		return models.Workspace{}, err
+	}
+	if err := tx.QueryRow(ctx, `
+		SELECT w.name, m.role
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE w.id = $1 AND m.user_id = $2
+	`, workspace.ID, userID).Scan(&workspace.Name, &workspace.Role); err != nil {
+		return models.Workspace{}, err
+	}
+	return workspace, tx.Commit(ctx)
+}
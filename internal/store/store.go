@@ -9,15 +9,46 @@ import (
 	"github.com/EvgeniyBudaev/shortener/internal/store/memory"
 	"github.com/EvgeniyBudaev/shortener/internal/store/postgres"
 	"github.com/gin-gonic/gin"
+	"time"
 )
 
 // Store Интерфейс содержит все необходимые методы для работы сервиса.
 type Store interface {
-	Get(ctx *gin.Context, id string) (string, error)
-	GetAllByUserID(ctx *gin.Context, userID string) ([]models.URLRecord, error)
+	Get(ctx *gin.Context, id string) (models.Link, error)
+	GetAllByUserID(ctx *gin.Context, userID string, filter models.URLFilter) ([]models.URLRecord, error)
 	DeleteMany(ctx *gin.Context, ids models.DeleteUserURLsReq, userID string) error
-	Put(ctx *gin.Context, id string, shortURL string, userID string) (string, error)
+	Put(ctx *gin.Context, link models.Link) (string, error)
 	PutBatch(ctx *gin.Context, data []models.URLBatchReq, userID string) ([]models.URLBatchRes, error)
+	UpdateURL(ctx *gin.Context, id string, userID string, originalURL string) (string, error)
+	SetRules(ctx *gin.Context, id string, userID string, rules []models.RedirectRule) error
+	SetDestinations(ctx *gin.Context, id string, userID string, destinations []models.Destination, sticky bool) error
+	GetHistory(ctx *gin.Context, id string, userID string) ([]models.URLVersion, error)
+	SetTags(ctx *gin.Context, id string, userID string, tags []string) error
+	GetTags(ctx *gin.Context, userID string) ([]models.LabelRes, error)
+	CreateCollection(ctx *gin.Context, userID string, name string) error
+	UpdateCollection(ctx *gin.Context, userID string, name string, add []string, remove []string) error
+	DeleteCollection(ctx *gin.Context, userID string, name string) error
+	GetCollections(ctx *gin.Context, userID string) ([]models.LabelRes, error)
+	CreateWorkspace(ctx *gin.Context, workspace models.Workspace, ownerID string) error
+	GetWorkspaces(ctx *gin.Context, userID string) ([]models.Workspace, error)
+	GetWorkspaceRole(ctx *gin.Context, workspaceID string, userID string) (models.Role, error)
+	GetWorkspaceMembers(ctx *gin.Context, workspaceID string) ([]models.WorkspaceMember, error)
+	SetWorkspaceMember(ctx *gin.Context, workspaceID string, memberID string, role models.Role) error
+	CreateInvite(ctx *gin.Context, invite models.WorkspaceInvite) error
+	AcceptInvite(ctx *gin.Context, tokenHash string, userID string) (models.Workspace, error)
+	CreateWebhook(ctx *gin.Context, webhook models.Webhook, userID string, secret string) error
+	GetWebhooks(ctx *gin.Context, userID string) ([]models.Webhook, error)
+	DeleteWebhook(ctx *gin.Context, id string, userID string) error
+	GetDeliveries(ctx *gin.Context, webhookID string, userID string) ([]models.WebhookDelivery, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	GetChanges(ctx context.Context, since int64, limit int) ([]models.ChangeEvent, error)
+	RecordClick(ctx context.Context, id string, destination string) (int64, error)
+	ExpireLinks(ctx context.Context, now time.Time) (int, error)
+	GetDestinationClicks(ctx *gin.Context, id string) (map[string]int64, error)
+	GetStats(ctx *gin.Context) (models.StatsRes, error)
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
 	Ping() error
 	Close()
 }
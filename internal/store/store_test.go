@@ -0,0 +1,710 @@
+package store
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/store/fs"
+	"github.com/EvgeniyBudaev/shortener/internal/store/memory"
+	"github.com/EvgeniyBudaev/shortener/internal/store/postgres"
+)
+
+// testDSNEnv переменная окружения со строкой подключения к тестовой базе Postgres.
+// Без нее общие тесты выполняются только на хранилищах в памяти и в файле.
+const testDSNEnv = "TEST_DATABASE_DSN"
+
+// forEachBackend запуск теста на каждом хранилище. reopen закрывает хранилище и открывает его
+// заново с теми же данными, для хранилища в памяти и Postgres возвращает то же хранилище.
+// Тесты используют случайные ID, URL и пользователей, поэтому не зависят от данных в тестовой базе.
+func forEachBackend(t *testing.T, test func(t *testing.T, s Store, reopen func() Store)) {
+	t.Run("memory", func(t *testing.T) {
+		s, err := memory.NewMemoryStorage(make(map[string]models.URLRecordMemory))
+		require.NoError(t, err)
+		test(t, s, func() Store { return s })
+	})
+	t.Run("fs", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "storage.json")
+		s, err := fs.NewFileStorage(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		test(t, s, func() Store {
+			s.Close()
+			s, err = fs.NewFileStorage(path)
+			require.NoError(t, err)
+			return s
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(testDSNEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", testDSNEnv)
+		}
+		s, err := postgres.NewPostgresStore(context.Background(), dsn)
+		require.NoError(t, err)
+		t.Cleanup(s.Close)
+		test(t, s, func() Store { return s })
+	})
+}
+
+// newID случайный ID ссылки
+func newID() string {
+	return uuid.NewString()[:8]
+}
+
+// newURL случайный исходный URL
+func newURL() string {
+	return "https://example.com/" + uuid.NewString()
+}
+
+func TestPutBatchConflict(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		taken, takenURL := newID(), newURL()
+		_, err := s.Put(ctx, models.Link{ID: taken, OriginalURL: takenURL, UserID: userID})
+		require.NoError(t, err)
+
+		// ссылка перед занятым ID не сохраняется
+		first, firstURL := newID(), newURL()
+		batch := []models.URLBatchReq{
+			{CorrelationID: first, OriginalURL: firstURL},
+			{CorrelationID: taken, OriginalURL: newURL()},
+		}
+		_, err = s.PutBatch(ctx, batch, userID)
+		assert.ErrorIs(t, err, models.ErrIDConflict)
+
+		s = reopen()
+		link, err := s.Get(ctx, first)
+		require.NoError(t, err)
+		assert.Empty(t, link.OriginalURL)
+		records, err := s.GetAllByUserID(ctx, userID, models.URLFilter{})
+		require.NoError(t, err)
+		assert.Len(t, records, 1)
+
+		// повторяющийся в батче ID тоже конфликт
+		dup := newID()
+		_, err = s.PutBatch(ctx, []models.URLBatchReq{
+			{CorrelationID: dup, OriginalURL: newURL()},
+			{CorrelationID: dup, OriginalURL: newURL()},
+		}, userID)
+		assert.ErrorIs(t, err, models.ErrIDConflict)
+		link, err = s.Get(ctx, dup)
+		require.NoError(t, err)
+		assert.Empty(t, link.OriginalURL)
+
+		// для уже сокращенного URL возвращается существующий ID, в том числе внутри батча
+		second := newID()
+		res, err := s.PutBatch(ctx, []models.URLBatchReq{
+			{CorrelationID: first, OriginalURL: firstURL},
+			{CorrelationID: newID(), OriginalURL: takenURL},
+			{CorrelationID: second, OriginalURL: firstURL},
+		}, userID)
+		require.NoError(t, err)
+		require.Len(t, res, 3)
+		assert.Equal(t, first, res[0].ShortURL)
+		assert.Equal(t, taken, res[1].ShortURL)
+		assert.Equal(t, first, res[2].ShortURL)
+
+		s = reopen()
+		link, err = s.Get(ctx, first)
+		require.NoError(t, err)
+		assert.Equal(t, firstURL, link.OriginalURL)
+		link, err = s.Get(ctx, second)
+		require.NoError(t, err)
+		assert.Empty(t, link.OriginalURL)
+	})
+}
+
+func TestPutAfterDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		deleted, originalURL := newID(), newURL()
+		_, err := s.Put(ctx, models.Link{ID: deleted, OriginalURL: originalURL, UserID: userID})
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteMany(ctx, models.DeleteUserURLsReq{deleted}, userID))
+
+		// исходный URL удаленной ссылки сокращается заново
+		recreated := newID()
+		id, err := s.Put(ctx, models.Link{ID: recreated, OriginalURL: originalURL, UserID: userID})
+		require.NoError(t, err)
+		assert.Equal(t, recreated, id)
+
+		s = reopen()
+		link, err := s.Get(ctx, deleted)
+		require.NoError(t, err)
+		assert.True(t, link.Deleted)
+		id, err = s.Put(ctx, models.Link{ID: newID(), OriginalURL: originalURL, UserID: userID})
+		assert.ErrorIs(t, err, models.ErrURLConflict)
+		assert.Equal(t, recreated, id)
+		res, err := s.PutBatch(ctx, []models.URLBatchReq{{CorrelationID: newID(), OriginalURL: originalURL}}, userID)
+		require.NoError(t, err)
+		assert.Equal(t, recreated, res[0].ShortURL)
+	})
+}
+
+func TestPutPerDomain(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		originalURL := newURL()
+		primary, branded := newID(), "go.example.com/"+newID()
+		for _, id := range []string{primary, branded} {
+			created, err := s.Put(ctx, models.Link{ID: id, OriginalURL: originalURL, UserID: userID})
+			require.NoError(t, err)
+			assert.Equal(t, id, created)
+		}
+
+		s = reopen()
+		id, err := s.Put(ctx, models.Link{ID: "go.example.com/" + newID(), OriginalURL: originalURL, UserID: userID})
+		assert.ErrorIs(t, err, models.ErrURLConflict)
+		assert.Equal(t, branded, id)
+		id, err = s.Put(ctx, models.Link{ID: newID(), OriginalURL: originalURL, UserID: userID})
+		assert.ErrorIs(t, err, models.ErrURLConflict)
+		assert.Equal(t, primary, id)
+
+		// смена URL проверяет конфликт только на домене ссылки
+		other := "go.example.com/" + newID()
+		_, err = s.Put(ctx, models.Link{ID: other, OriginalURL: newURL(), UserID: userID})
+		require.NoError(t, err)
+		id, err = s.UpdateURL(ctx, other, userID, originalURL)
+		assert.ErrorIs(t, err, models.ErrURLConflict)
+		assert.Equal(t, branded, id)
+		require.NoError(t, s.DeleteMany(ctx, models.DeleteUserURLsReq{branded}, userID))
+		id, err = s.UpdateURL(ctx, other, userID, originalURL)
+		require.NoError(t, err)
+		assert.Equal(t, other, id)
+	})
+}
+
+func TestUpdateURLConcurrentPut(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		for i := 0; i < 10; i++ {
+			updated, created, originalURL := newID(), newID(), newURL()
+			_, err := s.Put(ctx, models.Link{ID: updated, OriginalURL: newURL(), UserID: userID})
+			require.NoError(t, err)
+
+			// URL достается одной из ссылок, вторая получает конфликт с ее ID
+			start := make(chan struct{})
+			var updateID, putID string
+			var updateErr, putErr error
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				<-start
+				updateID, updateErr = s.UpdateURL(ctx, updated, userID, originalURL)
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				putID, putErr = s.Put(ctx, models.Link{ID: created, OriginalURL: originalURL, UserID: userID})
+			}()
+			close(start)
+			wg.Wait()
+
+			if updateErr == nil {
+				assert.ErrorIs(t, putErr, models.ErrURLConflict)
+				assert.Equal(t, updated, putID)
+			} else {
+				assert.ErrorIs(t, updateErr, models.ErrURLConflict)
+				assert.Equal(t, created, updateID)
+				assert.NoError(t, putErr)
+			}
+		}
+	})
+}
+
+// lastChange ID последнего события журнала изменений, 0 - журнал пуст
+func lastChange(t *testing.T, s Store) int64 {
+	events, err := s.GetChanges(context.Background(), 0, math.MaxInt)
+	require.NoError(t, err)
+	if len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].ID
+}
+
+func TestChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		head := lastChange(t, s)
+
+		expired := time.Now().Add(-time.Minute)
+		expiring, deleted := newID(), newID()
+		_, err := s.Put(ctx, models.Link{ID: expiring, OriginalURL: newURL(), UserID: userID, Options: models.LinkOptions{ExpiresAt: &expired}})
+		require.NoError(t, err)
+		updatedURL := newURL()
+		_, err = s.UpdateURL(ctx, expiring, userID, updatedURL)
+		require.NoError(t, err)
+		count, err := s.ExpireLinks(context.Background(), time.Now())
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, count, 1)
+		_, err = s.Put(ctx, models.Link{ID: deleted, OriginalURL: newURL(), UserID: userID})
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteMany(ctx, models.DeleteUserURLsReq{deleted}, userID))
+
+		// события пишутся в порядке изменений и сохраняются после перезапуска
+		s = reopen()
+		events, err := s.GetChanges(context.Background(), head, math.MaxInt)
+		require.NoError(t, err)
+		var got []string
+		for _, event := range events {
+			if event.LinkID == expiring || event.LinkID == deleted {
+				got = append(got, event.LinkID+" "+event.Type+" "+event.Field)
+			}
+		}
+		assert.Equal(t, []string{
+			expiring + " " + models.EventLinkCreated + " ",
+			expiring + " " + models.EventLinkUpdated + " " + models.FieldOriginalURL,
+			expiring + " " + models.EventLinkExpired + " ",
+			deleted + " " + models.EventLinkCreated + " ",
+			deleted + " " + models.EventLinkDeleted + " ",
+		}, got)
+		for i := 1; i < len(events); i++ {
+			assert.Greater(t, events[i].ID, events[i-1].ID)
+		}
+
+		// курсор новее последнего события означает, что журнал начат заново
+		last := lastChange(t, s)
+		events, err = s.GetChanges(context.Background(), last, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+		_, err = s.GetChanges(context.Background(), last+1, 10)
+		assert.ErrorIs(t, err, models.ErrCursorExpired)
+	})
+}
+
+func TestChangesRetention(t *testing.T) {
+	s, err := memory.NewMemoryStorage(make(map[string]models.URLRecordMemory))
+	require.NoError(t, err)
+	events := make([]models.ChangeEvent, models.ChangesRetention+5)
+	for i := range events {
+		events[i] = models.ChangeEvent{ID: int64(i + 1), Type: models.EventLinkCreated}
+	}
+	s.LoadChanges(events)
+
+	// хранятся только последние события, курсор до них отклоняется
+	kept, err := s.GetChanges(context.Background(), 0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), kept[0].ID)
+	_, err = s.GetChanges(context.Background(), 4, 1)
+	assert.ErrorIs(t, err, models.ErrCursorExpired)
+	kept, err = s.GetChanges(context.Background(), 5, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), kept[0].ID)
+
+	ctx := &gin.Context{}
+	_, err = s.Put(ctx, models.Link{ID: newID(), OriginalURL: newURL()})
+	require.NoError(t, err)
+	kept, err = s.GetChanges(context.Background(), 0, math.MaxInt)
+	require.NoError(t, err)
+	assert.Len(t, kept, models.ChangesRetention+1)
+	assert.Equal(t, int64(models.ChangesRetention+6), kept[len(kept)-1].ID)
+}
+
+func TestHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		id, firstURL, secondURL, thirdURL := newID(), newURL(), newURL(), newURL()
+		_, err := s.Put(ctx, models.Link{ID: id, OriginalURL: firstURL, UserID: userID})
+		require.NoError(t, err)
+		history, err := s.GetHistory(ctx, id, userID)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		_, err = s.UpdateURL(ctx, id, userID, secondURL)
+		require.NoError(t, err)
+		// тот же URL не создает новую версию
+		_, err = s.UpdateURL(ctx, id, userID, secondURL)
+		require.NoError(t, err)
+		_, err = s.UpdateURL(ctx, id, userID, thirdURL)
+		require.NoError(t, err)
+
+		// прежние URL возвращаются от новых к старым и сохраняются после перезапуска
+		s = reopen()
+		history, err = s.GetHistory(ctx, id, userID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, 2, history[0].Version)
+		assert.Equal(t, secondURL, history[0].OriginalURL)
+		assert.Equal(t, 1, history[1].Version)
+		assert.Equal(t, firstURL, history[1].OriginalURL)
+		assert.False(t, history[0].ReplacedAt.Before(history[1].ReplacedAt))
+		link, err := s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, thirdURL, link.OriginalURL)
+
+		// прежний URL освобождается, чужая ссылка не раскрывается
+		other := newID()
+		_, err = s.Put(ctx, models.Link{ID: other, OriginalURL: firstURL, UserID: userID})
+		require.NoError(t, err)
+		_, err = s.GetHistory(ctx, id, uuid.NewString())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = s.UpdateURL(ctx, id, uuid.NewString(), newURL())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+}
+
+// shortURLs ID ссылок записей
+func shortURLs(records []models.URLRecord) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ShortURL)
+	}
+	return ids
+}
+
+func TestLabels(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		first, second := newID(), newID()
+		for _, id := range []string{first, second} {
+			_, err := s.Put(ctx, models.Link{ID: id, OriginalURL: newURL(), UserID: userID})
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.SetTags(ctx, first, userID, []string{"promo", "ads"}))
+		require.NoError(t, s.SetTags(ctx, second, userID, []string{"promo"}))
+		assert.ErrorIs(t, s.SetTags(ctx, first, uuid.NewString(), []string{"x"}), models.ErrNotFound)
+
+		require.NoError(t, s.CreateCollection(ctx, userID, "spring"))
+		assert.ErrorIs(t, s.CreateCollection(ctx, userID, "spring"), models.ErrCollectionExists)
+		require.NoError(t, s.CreateCollection(ctx, userID, "empty"))
+		require.NoError(t, s.UpdateCollection(ctx, userID, "spring", []string{first, second}, nil))
+		require.NoError(t, s.UpdateCollection(ctx, userID, "spring", nil, []string{second}))
+		// недоступная ссылка не добавляется, коллекция не меняется
+		assert.ErrorIs(t, s.UpdateCollection(ctx, userID, "spring", []string{second, newID()}, nil), models.ErrNotFound)
+		assert.ErrorIs(t, s.UpdateCollection(ctx, userID, "missing", []string{first}, nil), models.ErrNotFound)
+
+		// метки и коллекции сохраняются после перезапуска
+		s = reopen()
+		tags, err := s.GetTags(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.LabelRes{{Name: "ads", URLs: 1}, {Name: "promo", URLs: 2}}, tags)
+		collections, err := s.GetCollections(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.LabelRes{{Name: "empty", URLs: 0}, {Name: "spring", URLs: 1}}, collections)
+
+		records, err := s.GetAllByUserID(ctx, userID, models.URLFilter{Tags: []string{"promo"}})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{first, second}, shortURLs(records))
+		records, err = s.GetAllByUserID(ctx, userID, models.URLFilter{Tags: []string{"promo", "ads"}, Collection: "spring"})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, first, records[0].ShortURL)
+		assert.Equal(t, []string{"ads", "promo"}, records[0].Tags)
+		assert.Equal(t, []string{"spring"}, records[0].Collections)
+
+		// удаленная ссылка не учитывается, пустые метки снимаются
+		require.NoError(t, s.DeleteMany(ctx, models.DeleteUserURLsReq{first}, userID))
+		require.NoError(t, s.SetTags(ctx, second, userID, nil))
+		tags, err = s.GetTags(ctx, userID)
+		require.NoError(t, err)
+		assert.Empty(t, tags)
+		collections, err = s.GetCollections(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.LabelRes{{Name: "empty", URLs: 0}, {Name: "spring", URLs: 0}}, collections)
+
+		require.NoError(t, s.DeleteCollection(ctx, userID, "spring"))
+		assert.ErrorIs(t, s.DeleteCollection(ctx, userID, "spring"), models.ErrNotFound)
+		s = reopen()
+		collections, err = s.GetCollections(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.LabelRes{{Name: "empty", URLs: 0}}, collections)
+	})
+}
+
+func TestWorkspaces(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		ownerID, editorID, viewerID := uuid.NewString(), uuid.NewString(), uuid.NewString()
+		workspaceID := uuid.NewString()
+		require.NoError(t, s.CreateWorkspace(ctx, models.Workspace{ID: workspaceID, Name: "team"}, ownerID))
+
+		// приглашение одноразовое, просроченное не принимается
+		editorInvite, viewerInvite, expiredInvite := uuid.NewString(), uuid.NewString(), uuid.NewString()
+		for hash, role := range map[string]models.Role{editorInvite: models.RoleEditor, viewerInvite: models.RoleViewer} {
+			require.NoError(t, s.CreateInvite(ctx, models.WorkspaceInvite{
+				TokenHash: hash, WorkspaceID: workspaceID, Role: role, CreatedBy: ownerID, ExpiresAt: time.Now().Add(time.Hour),
+			}))
+		}
+		require.NoError(t, s.CreateInvite(ctx, models.WorkspaceInvite{
+			TokenHash: expiredInvite, WorkspaceID: workspaceID, Role: models.RoleOwner, CreatedBy: ownerID, ExpiresAt: time.Now().Add(-time.Minute),
+		}))
+		workspace, err := s.AcceptInvite(ctx, editorInvite, editorID)
+		require.NoError(t, err)
+		assert.Equal(t, models.Workspace{ID: workspaceID, Name: "team", Role: models.RoleEditor}, workspace)
+		_, err = s.AcceptInvite(ctx, editorInvite, uuid.NewString())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = s.AcceptInvite(ctx, expiredInvite, uuid.NewString())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		_, err = s.AcceptInvite(ctx, viewerInvite, viewerID)
+		require.NoError(t, err)
+		// участник сохраняет свою роль при повторном приглашении
+		againInvite := uuid.NewString()
+		require.NoError(t, s.CreateInvite(ctx, models.WorkspaceInvite{
+			TokenHash: againInvite, WorkspaceID: workspaceID, Role: models.RoleEditor, CreatedBy: ownerID, ExpiresAt: time.Now().Add(time.Hour),
+		}))
+		workspace, err = s.AcceptInvite(ctx, againInvite, viewerID)
+		require.NoError(t, err)
+		assert.Equal(t, models.RoleViewer, workspace.Role)
+
+		// участники и роли сохраняются после перезапуска
+		s = reopen()
+		role, err := s.GetWorkspaceRole(ctx, workspaceID, viewerID)
+		require.NoError(t, err)
+		assert.Equal(t, models.RoleViewer, role)
+		role, err = s.GetWorkspaceRole(ctx, workspaceID, uuid.NewString())
+		require.NoError(t, err)
+		assert.Empty(t, role)
+		members, err := s.GetWorkspaceMembers(ctx, workspaceID)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.WorkspaceMember{
+			{UserID: ownerID, Role: models.RoleOwner},
+			{UserID: editorID, Role: models.RoleEditor},
+			{UserID: viewerID, Role: models.RoleViewer},
+		}, members)
+		workspaces, err := s.GetWorkspaces(ctx, editorID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Workspace{{ID: workspaceID, Name: "team", Role: models.RoleEditor}}, workspaces)
+
+		// ссылку рабочего пространства изменяют редакторы, участник с ролью viewer только видит ее
+		id := newID()
+		_, err = s.Put(ctx, models.Link{ID: id, OriginalURL: newURL(), UserID: ownerID, WorkspaceID: workspaceID})
+		require.NoError(t, err)
+		_, err = s.UpdateURL(ctx, id, editorID, newURL())
+		require.NoError(t, err)
+		_, err = s.UpdateURL(ctx, id, viewerID, newURL())
+		assert.ErrorIs(t, err, models.ErrForbidden)
+		_, err = s.UpdateURL(ctx, id, uuid.NewString(), newURL())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		history, err := s.GetHistory(ctx, id, viewerID)
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+		records, err := s.GetAllByUserID(ctx, viewerID, models.URLFilter{WorkspaceID: workspaceID})
+		require.NoError(t, err)
+		assert.Equal(t, []string{id}, shortURLs(records))
+
+		// последнего владельца нельзя понизить, исключенный участник теряет доступ
+		assert.ErrorIs(t, s.SetWorkspaceMember(ctx, workspaceID, ownerID, models.RoleEditor), models.ErrLastOwner)
+		assert.ErrorIs(t, s.SetWorkspaceMember(ctx, workspaceID, uuid.NewString(), models.RoleEditor), models.ErrNotFound)
+		require.NoError(t, s.SetWorkspaceMember(ctx, workspaceID, editorID, ""))
+		s = reopen()
+		_, err = s.UpdateURL(ctx, id, editorID, newURL())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		workspaces, err = s.GetWorkspaces(ctx, editorID)
+		require.NoError(t, err)
+		assert.Empty(t, workspaces)
+	})
+}
+
+// webhookDeliveries доставки подписки webhookID из выборки к отправке
+func webhookDeliveries(deliveries []models.WebhookDelivery, webhookID string) []models.WebhookDelivery {
+	var result []models.WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.WebhookID == webhookID {
+			result = append(result, delivery)
+		}
+	}
+	return result
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		webhook := models.Webhook{
+			ID:  uuid.NewString(),
+			URL: "https://hooks.example.com/" + uuid.NewString(),
+			Events: []string{
+				models.EventLinkCreated, models.EventLinkDeleted, models.EventLinkClickThreshold, models.EventLinkExpired,
+			},
+			ClickThreshold: 2,
+			CreatedAt:      time.Now().UTC(),
+		}
+		require.NoError(t, s.CreateWebhook(ctx, webhook, userID, "secret"))
+		// подписка другого пользователя не получает события чужих ссылок
+		other := models.Webhook{ID: uuid.NewString(), URL: webhook.URL, Events: webhook.Events, CreatedAt: time.Now().UTC()}
+		otherUserID := uuid.NewString()
+		require.NoError(t, s.CreateWebhook(ctx, other, otherUserID, "secret"))
+
+		// события ставятся в очередь вместе с изменениями ссылок
+		clicked, expiring := newID(), newID()
+		expired := time.Now().Add(-time.Minute)
+		_, err := s.Put(ctx, models.Link{ID: clicked, OriginalURL: newURL(), UserID: userID})
+		require.NoError(t, err)
+		_, err = s.Put(ctx, models.Link{ID: expiring, OriginalURL: newURL(), UserID: userID, Options: models.LinkOptions{ExpiresAt: &expired}})
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, err = s.RecordClick(context.Background(), clicked, "")
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.DeleteMany(ctx, models.DeleteUserURLsReq{clicked}, userID))
+		_, err = s.ExpireLinks(context.Background(), time.Now())
+		require.NoError(t, err)
+
+		s = reopen()
+		deliveries, err := s.GetDeliveries(ctx, webhook.ID, userID)
+		require.NoError(t, err)
+		var got []string
+		for _, delivery := range deliveries {
+			assert.Equal(t, models.DeliveryPending, delivery.Status)
+			got = append(got, delivery.Event+" "+delivery.Data.LinkID)
+		}
+		assert.Equal(t, []string{
+			models.EventLinkExpired + " " + expiring,
+			models.EventLinkDeleted + " " + clicked,
+			models.EventLinkClickThreshold + " " + clicked,
+			models.EventLinkCreated + " " + expiring,
+			models.EventLinkCreated + " " + clicked,
+		}, got)
+		_, err = s.GetDeliveries(ctx, webhook.ID, uuid.NewString())
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		deliveries, err = s.GetDeliveries(ctx, other.ID, otherUserID)
+		require.NoError(t, err)
+		assert.Empty(t, deliveries)
+
+		// выбранные доставки не выбираются повторно до истечения аренды
+		now := time.Now()
+		claimed, err := s.ClaimDeliveries(context.Background(), now, time.Minute, math.MaxInt32)
+		require.NoError(t, err)
+		claimed = webhookDeliveries(claimed, webhook.ID)
+		require.Len(t, claimed, 5)
+		assert.Equal(t, models.EventLinkCreated, claimed[0].Event)
+		assert.Equal(t, clicked, claimed[0].Data.LinkID)
+		assert.Equal(t, webhook.URL, claimed[0].URL)
+		assert.Equal(t, "secret", claimed[0].Secret)
+		assert.Equal(t, int64(2), claimed[2].Data.Clicks)
+		again, err := s.ClaimDeliveries(context.Background(), now, time.Minute, math.MaxInt32)
+		require.NoError(t, err)
+		assert.Empty(t, webhookDeliveries(again, webhook.ID))
+
+		delivered := claimed[0]
+		delivered.Status = models.DeliveryDelivered
+		delivered.Attempts = 1
+		delivered.LastStatus = 200
+		require.NoError(t, s.UpdateDelivery(context.Background(), delivered))
+
+		// результат попытки сохраняется после перезапуска, доставленное событие больше не выбирается
+		s = reopen()
+		deliveries, err = s.GetDeliveries(ctx, webhook.ID, userID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 5)
+		assert.Equal(t, models.DeliveryDelivered, deliveries[4].Status)
+		assert.Equal(t, 1, deliveries[4].Attempts)
+		assert.Equal(t, 200, deliveries[4].LastStatus)
+		claimed, err = s.ClaimDeliveries(context.Background(), now.Add(2*time.Minute), time.Minute, math.MaxInt32)
+		require.NoError(t, err)
+		assert.Len(t, webhookDeliveries(claimed, webhook.ID), 4)
+
+		// доставки удаляются вместе с подпиской
+		require.NoError(t, s.DeleteWebhook(ctx, webhook.ID, userID))
+		_, err = s.GetDeliveries(ctx, webhook.ID, userID)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+		assert.NoError(t, s.UpdateDelivery(context.Background(), delivered))
+	})
+}
+
+func TestRules(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		id := newID()
+		_, err := s.Put(ctx, models.Link{ID: id, OriginalURL: newURL(), UserID: userID})
+		require.NoError(t, err)
+		rules := []models.RedirectRule{
+			{Platform: "ios", URL: newURL()},
+			{Language: "pt", Query: map[string]string{"ref": ""}, URL: newURL()},
+		}
+		require.NoError(t, s.SetRules(ctx, id, userID, rules))
+		assert.ErrorIs(t, s.SetRules(ctx, id, uuid.NewString(), nil), models.ErrNotFound)
+
+		// правила сохраняются после перезапуска и снимаются пустым списком
+		s = reopen()
+		link, err := s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, rules, link.Options.Rules)
+		require.NoError(t, s.SetRules(ctx, id, userID, nil))
+		s = reopen()
+		link, err = s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Empty(t, link.Options.Rules)
+	})
+}
+
+func TestDestinations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		userID := uuid.NewString()
+		id := newID()
+		_, err := s.Put(ctx, models.Link{ID: id, OriginalURL: newURL(), UserID: userID})
+		require.NoError(t, err)
+		destinations := []models.Destination{{ID: "a", URL: newURL(), Weight: 3}, {ID: "b", URL: newURL(), Weight: 1}}
+		require.NoError(t, s.SetDestinations(ctx, id, userID, destinations, true))
+		assert.ErrorIs(t, s.SetDestinations(ctx, id, uuid.NewString(), nil, false), models.ErrNotFound)
+
+		// переходы считаются по ссылке и по каждому варианту
+		for _, destination := range []string{"a", "a", "b", ""} {
+			_, err = s.RecordClick(context.Background(), id, destination)
+			require.NoError(t, err)
+		}
+		clicks, err := s.RecordClick(context.Background(), id, "a")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), clicks)
+
+		// варианты и счетчики сохраняются после перезапуска
+		s = reopen()
+		link, err := s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, destinations, link.Options.Destinations)
+		assert.True(t, link.Options.Sticky)
+		assert.Equal(t, int64(5), link.Clicks)
+		counts, err := s.GetDestinationClicks(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{"a": 3, "b": 1}, counts)
+
+		// без вариантов закрепление не сохраняется
+		require.NoError(t, s.SetDestinations(ctx, id, userID, nil, true))
+		s = reopen()
+		link, err = s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Empty(t, link.Options.Destinations)
+		assert.False(t, link.Options.Sticky)
+	})
+}
+
+func TestPasswordHash(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store, reopen func() Store) {
+		ctx := &gin.Context{}
+		protected, open := newID(), newID()
+		_, err := s.Put(ctx, models.Link{ID: protected, OriginalURL: newURL(), UserID: uuid.NewString(), PasswordHash: "$2a$10$hash"})
+		require.NoError(t, err)
+		_, err = s.Put(ctx, models.Link{ID: open, OriginalURL: newURL(), UserID: uuid.NewString()})
+		require.NoError(t, err)
+
+		// хэш пароля сохраняется после перезапуска, ссылка без пароля остается открытой
+		s = reopen()
+		link, err := s.Get(ctx, protected)
+		require.NoError(t, err)
+		assert.Equal(t, "$2a$10$hash", link.PasswordHash)
+		link, err = s.Get(ctx, open)
+		require.NoError(t, err)
+		assert.Empty(t, link.PasswordHash)
+	})
+}
@@ -0,0 +1,110 @@
+// Модуль проверки и приведения URL к каноническому виду.
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// MaxLength максимальная длина URL до и после нормализации
+const MaxLength = 2048
+
+// Ошибки проверки URL
+var (
+	ErrEmpty       = errors.New("url is empty")
+	ErrTooLong     = fmt.Errorf("url is longer than %d characters", MaxLength)
+	ErrMalformed   = errors.New("url is malformed")
+	ErrNotAbsolute = errors.New("url must be absolute")
+	ErrScheme      = errors.New("url scheme must be http or https")
+	ErrHost        = errors.New("url host is invalid")
+)
+
+// defaultPorts порты по умолчанию, которые удаляются из URL
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Options параметры нормализации
+type Options struct {
+	// SortQuery сортировка параметров запроса по имени
+	SortQuery bool
+}
+
+// Normalize проверка URL и приведение его к каноническому виду: схема и хост в нижнем регистре,
+// интернационализированный домен в punycode, без порта по умолчанию, пустой путь заменяется на "/".
+// Эквивалентные URL после нормализации совпадают, что позволяет хранилищам находить дубликаты.
+func Normalize(raw string, opts Options) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrEmpty
+	}
+	if len(raw) > MaxLength {
+		return "", ErrTooLong
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if u.Scheme == "" || u.Opaque != "" {
+		return "", ErrNotAbsolute
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return "", ErrScheme
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return "", fmt.Errorf("%w: bad port %q", ErrHost, port)
+		}
+	}
+	switch {
+	case port != "" && port != defaultPorts[u.Scheme]:
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if opts.SortQuery && u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+
+	result := u.String()
+	if len(result) > MaxLength {
+		return "", ErrTooLong
+	}
+	return result, nil
+}
+
+// normalizeHost проверка хоста: IP-адрес или доменное имя, которое переводится в punycode
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("%w: host is empty", ErrHost)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil || ascii == "" {
+		return "", fmt.Errorf("%w: %q", ErrHost, host)
+	}
+	return strings.ToLower(ascii), nil
+}
@@ -0,0 +1,46 @@
+package urlnorm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		opts Options
+		want string
+		err  error
+	}{
+		{name: "already canonical", raw: "https://example.com/path?b=2&a=1", want: "https://example.com/path?b=2&a=1"},
+		{name: "case and default port", raw: "HTTP://Example.COM:80", want: "http://example.com/"},
+		{name: "custom port kept", raw: "https://example.com:8443/x", want: "https://example.com:8443/x"},
+		{name: "idn", raw: "https://пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "ipv6", raw: "http://[::1]:80/", want: "http://[::1]/"},
+		{name: "sorted query", raw: "https://example.com/?b=2&a=1", opts: Options{SortQuery: true}, want: "https://example.com/?a=1&b=2"},
+		{name: "surrounding spaces", raw: " https://example.com/\n", want: "https://example.com/"},
+		{name: "empty", raw: "", err: ErrEmpty},
+		{name: "javascript", raw: "javascript:alert(1)", err: ErrNotAbsolute},
+		{name: "ftp", raw: "ftp://example.com/file", err: ErrScheme},
+		{name: "relative", raw: "/path", err: ErrNotAbsolute},
+		{name: "no host", raw: "https:///path", err: ErrHost},
+		{name: "bad host", raw: "https://exa mple.com/", err: ErrMalformed},
+		{name: "too long", raw: "https://example.com/" + strings.Repeat("a", MaxLength), err: ErrTooLong},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.raw, tt.opts)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
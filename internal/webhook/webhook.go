@@ -0,0 +1,197 @@
+// Модуль доставки событий ссылок на адреса подписок пользователей.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+)
+
+// Заголовки запроса с событием
+const (
+	// SignatureHeader подпись тела запроса, см. Sign
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader время отправки в секундах Unix, входит в подпись
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	// DeliveryHeader ID доставки, одинаковый при повторных попытках
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// Параметры доставки по умолчанию
+const (
+	DefaultMaxAttempts  = 8
+	DefaultBaseDelay    = time.Second * 30
+	DefaultMaxDelay     = time.Hour
+	DefaultTimeout      = time.Second * 10
+	DefaultPollInterval = time.Second
+	// batchSize количество доставок, выбираемых за один раз
+	batchSize = 20
+	// maxErrorLen максимальная длина сохраняемой ошибки попытки
+	maxErrorLen = 512
+	// maxResponseLen количество читаемых байт ответа получателя
+	maxResponseLen = 64 << 10
+)
+
+// Sign подпись тела запроса: HMAC-SHA256 секретом подписки от строки "timestamp.body" в hex с префиксом "sha256=".
+// Время входит в подпись, чтобы получатель мог отклонять повторно отправленные старые запросы.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify проверка подписи на стороне получателя
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff задержка перед следующей попыткой после attempts неудачных: base, 2*base, 4*base..., не больше max
+func Backoff(attempts int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// Queue очередь доставок в хранилище
+type Queue interface {
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+}
+
+// PayloadFunc построение тела запроса по событию
+type PayloadFunc func(event models.WebhookEvent) ([]byte, error)
+
+// Dispatcher отправка событий из очереди с повторными попытками. Доставка, не принятая
+// за MaxAttempts попыток, переходит в состояние dead и больше не отправляется.
+type Dispatcher struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+	queue        Queue
+	client       *http.Client
+	payload      PayloadFunc
+}
+
+// NewDispatcher конструктор с параметрами по умолчанию, тело запроса строит payload
+func NewDispatcher(queue Queue, client *http.Client, payload PayloadFunc) *Dispatcher {
+	return &Dispatcher{
+		MaxAttempts:  DefaultMaxAttempts,
+		BaseDelay:    DefaultBaseDelay,
+		MaxDelay:     DefaultMaxDelay,
+		PollInterval: DefaultPollInterval,
+		queue:        queue,
+		client:       client,
+		payload:      payload,
+	}
+}
+
+// Run отправка событий до отмены ctx
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// очередь разбирается без ожидания, пока в ней есть доставки
+			for {
+				n, err := d.DeliverPending(ctx)
+				if err != nil {
+					log.Printf("Error delivering webhooks: %v", err)
+				}
+				if err != nil || n < batchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DeliverPending отправка доставок, время попытки которых наступило. Возвращает количество попыток.
+func (d *Dispatcher) DeliverPending(ctx context.Context) (int, error) {
+	// доставка откладывается на время, заведомо большее времени попытки
+	lease := d.client.Timeout*2 + time.Minute
+	deliveries, err := d.queue.ClaimDeliveries(ctx, time.Now(), lease, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		if err := d.queue.UpdateDelivery(ctx, d.attempt(ctx, delivery)); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// attempt попытка доставки, возвращает доставку с ее результатом
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) models.WebhookDelivery {
+	delivery.Attempts++
+	delivery.LastStatus, delivery.LastError = 0, ""
+	status, err := d.post(ctx, delivery)
+	delivery.LastStatus = status
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		return delivery
+	case len(err.Error()) > maxErrorLen:
+		delivery.LastError = err.Error()[:maxErrorLen]
+	default:
+		delivery.LastError = err.Error()
+	}
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = models.DeliveryDead
+		return delivery
+	}
+	delivery.NextAttemptAt = time.Now().Add(Backoff(delivery.Attempts, d.BaseDelay, d.MaxDelay))
+	return delivery
+}
+
+// post отправка события, ответ со статусом 2xx означает успешную доставку
+func (d *Dispatcher) post(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+	body, err := d.payload(delivery.Data)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shortener-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseLen))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
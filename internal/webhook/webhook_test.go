@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/EvgeniyBudaev/shortener/internal/models"
+	"github.com/EvgeniyBudaev/shortener/internal/store/memory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"link.created"}`)
+	signature := Sign("secret", 1700000000, body)
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.True(t, Verify("secret", 1700000000, body, signature))
+	assert.False(t, Verify("other", 1700000000, body, signature))
+	assert.False(t, Verify("secret", 1700000001, body, signature))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second*30, Backoff(1, time.Second*30, time.Hour))
+	assert.Equal(t, time.Minute*2, Backoff(3, time.Second*30, time.Hour))
+	assert.Equal(t, time.Hour, Backoff(20, time.Second*30, time.Hour))
+}
+
+func TestDispatcher(t *testing.T) {
+	var failing atomic.Bool
+	received := make(chan *http.Request, 10)
+	payloads := make(chan models.WebhookPayload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if failing.Load() || !Verify("secret", timestamp, body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload models.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- r
+		payloads <- payload
+	}))
+	defer receiver.Close()
+
+	storage, err := memory.NewMemoryStorage(map[string]models.URLRecordMemory{})
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	webhook := models.Webhook{ID: "w1", URL: receiver.URL, Events: []string{models.EventLinkCreated}, CreatedAt: time.Now()}
+	require.NoError(t, storage.CreateWebhook(ctx, webhook, "user", "secret"))
+
+	dispatcher := NewDispatcher(storage, receiver.Client(), func(event models.WebhookEvent) ([]byte, error) {
+		return json.Marshal(event.Payload("http://localhost/" + event.LinkID))
+	})
+	dispatcher.MaxAttempts = 2
+	dispatcher.BaseDelay = 0
+
+	// событие ставится в очередь хранилищем вместе с изменением ссылки, только для подписанных событий
+	_, err = storage.Put(ctx, models.Link{ID: "abc", OriginalURL: "https://example.com/", UserID: "user"})
+	require.NoError(t, err)
+	require.NoError(t, storage.DeleteMany(ctx, models.DeleteUserURLsReq{"abc"}, "user"))
+	assert.Equal(t, int64(1), storage.LastDeliveryID())
+
+	n, err := dispatcher.DeliverPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	req := <-received
+	assert.Equal(t, models.EventLinkCreated, req.Header.Get(EventHeader))
+	assert.Equal(t, "1", req.Header.Get(DeliveryHeader))
+	payload := <-payloads
+	assert.Equal(t, models.EventLinkCreated, payload.Type)
+	assert.NotEmpty(t, payload.ID)
+	assert.Equal(t, "http://localhost/abc", payload.Data.ShortURL)
+	assert.Equal(t, "https://example.com/", payload.Data.OriginalURL)
+
+	// неуспешная доставка повторяется и после исчерпания попыток переходит в dead
+	failing.Store(true)
+	_, err = storage.Put(ctx, models.Link{ID: "def", OriginalURL: "https://example.com/other", UserID: "user"})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := dispatcher.DeliverPending(context.Background())
+		require.NoError(t, err)
+	}
+	deliveries, err := storage.GetDeliveries(ctx, "w1", "user")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, models.DeliveryDead, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].LastStatus)
+	assert.Equal(t, models.DeliveryDelivered, deliveries[1].Status)
+	assert.Equal(t, 1, deliveries[1].Attempts)
+}